@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/raitses/ask/pkg/ask"
+)
+
+// runCheck verifies the configuration with a test request, printing a
+// report. It returns the process exit code.
+func runCheck(cfg *ask.Config) int {
+	keySource := cfg.APIKeySource
+	if keySource == "" {
+		keySource = "(none)"
+	}
+	fmt.Printf("Endpoint: %s\n", cfg.APIURL)
+	fmt.Printf("Model:    %s\n", cfg.Model)
+	fmt.Printf("API key:  %s\n", keySource)
+	if cfg.Profile != "" {
+		fmt.Printf("Profile:  %s\n", cfg.Profile)
+	}
+
+	if warning := cfg.ModelMismatchWarning(); warning != "" {
+		fmt.Fprintf(os.Stderr, "⚠️  Warning: %s\n", warning)
+	}
+
+	result := ask.Check(cfg)
+	if result.Err != nil {
+		fmt.Fprintf(os.Stderr, "✗ Check failed: %s\n  %v\n", result.Problem, result.Err)
+		return 1
+	}
+
+	fmt.Printf("✓ OK in %s (reply: %q)\n", result.Latency.Round(time.Millisecond), strings.TrimSpace(result.Reply))
+	return 0
+}
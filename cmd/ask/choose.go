@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// chooseResponse lists numbered choices on w and reads the pick from r, the
+// reader shared by all prompts (see stdin). An empty or invalid answer picks
+// the first choice.
+func chooseResponse(r *bufio.Reader, w io.Writer, choices []string) int {
+	for i, choice := range choices {
+		fmt.Fprintf(w, "── Choice %d ──\n%s\n\n", i+1, choice)
+	}
+	fmt.Fprintf(w, "Keep which response? [1-%d, default 1] ", len(choices))
+
+	answer, _ := r.ReadString('\n')
+	n, err := strconv.Atoi(strings.TrimSpace(answer))
+	if err != nil || n < 1 || n > len(choices) {
+		return 0
+	}
+	return n - 1
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
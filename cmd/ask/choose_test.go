@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestChooseResponse(t *testing.T) {
+	choices := []string{"First idea", "Second idea", "Third idea"}
+
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"2\n", 1},
+		{" 3 \n", 2},
+		{"\n", 0},
+		{"", 0},
+		{"4\n", 0},
+		{"two\n", 0},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if got := chooseResponse(bufio.NewReader(strings.NewReader(tt.input)), &out, choices); got != tt.want {
+			t.Errorf("chooseResponse(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+		if !strings.Contains(out.String(), "Choice 3 ──\nThird idea") {
+			t.Errorf("Choices should be listed, got %q", out.String())
+		}
+	}
+}
+
+func TestChooseResponseSharesReader(t *testing.T) {
+	// The pick and a later prompt's answer arrive together, as when piped
+	r := bufio.NewReader(strings.NewReader("2\ny\n"))
+	if got := chooseResponse(r, &bytes.Buffer{}, []string{"First", "Second"}); got != 1 {
+		t.Fatalf("chooseResponse() = %d, want 1", got)
+	}
+	if rest, _ := r.ReadString('\n'); rest != "y\n" {
+		t.Errorf("The next prompt read %q, want the answer after the pick", rest)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/raitses/ask/pkg/ask"
+)
+
+// parseCutoff parses a --trim argument into a cutoff time. It accepts an age
+// relative to now ("90m", "12h", "2d", "1w") or a date ("2024-05-01" or RFC 3339).
+func parseCutoff(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, now.Location()); err == nil {
+		return t, nil
+	}
+
+	age, err := ask.ParseAge(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid trim cutoff %q (use an age like 2d or 12h, or a date like 2024-05-01)", value)
+	}
+	return now.Add(-age), nil
+}
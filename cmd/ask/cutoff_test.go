@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCutoff(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2d", now.Add(-48 * time.Hour), false},
+		{"1w", now.Add(-7 * 24 * time.Hour), false},
+		{"90m", now.Add(-90 * time.Minute), false},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), false},
+		{"2024-05-01T08:30:00Z", time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+		{"-2d", time.Time{}, true},
+		{"d", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCutoff(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCutoff(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseCutoff(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
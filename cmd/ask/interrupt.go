@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptExitCode is the exit status used when an interrupt that was held
+// back can't be re-raised (128 + SIGINT, as shells report it)
+const interruptExitCode = 130
+
+// notifyInterrupts starts delivering interrupt signals to c (replaced in tests)
+var notifyInterrupts = func(c chan<- os.Signal) {
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+}
+
+// raiseInterrupt re-delivers a held signal to this process, now that it is no
+// longer caught, exiting directly where signals can't be sent (replaced in tests)
+var raiseInterrupt = func(sig os.Signal) {
+	if proc, err := os.FindProcess(os.Getpid()); err == nil && proc.Signal(sig) == nil {
+		return
+	}
+	os.Exit(interruptExitCode)
+}
+
+// holdInterrupts defers Ctrl-C and SIGTERM until release is called, so saving
+// an answer already received can finish. A signal that arrived in the
+// meantime is re-raised on release.
+func holdInterrupts() (release func()) {
+	signals := make(chan os.Signal, 1)
+	notifyInterrupts(signals)
+
+	return func() {
+		signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			raiseInterrupt(sig)
+		default:
+		}
+	}
+}
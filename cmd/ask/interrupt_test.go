@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHoldInterrupts(t *testing.T) {
+	origNotify, origRaise := notifyInterrupts, raiseInterrupt
+	defer func() { notifyInterrupts, raiseInterrupt = origNotify, origRaise }()
+
+	var raised []os.Signal
+	raiseInterrupt = func(sig os.Signal) { raised = append(raised, sig) }
+
+	// Nothing arrives while held: nothing is raised
+	notifyInterrupts = func(chan<- os.Signal) {}
+	holdInterrupts()()
+	if len(raised) != 0 {
+		t.Fatalf("Raised %v with no interrupt held", raised)
+	}
+
+	// Ctrl-C arrives while held: it is raised once, on release
+	notifyInterrupts = func(c chan<- os.Signal) { c <- os.Interrupt }
+	release := holdInterrupts()
+	if len(raised) != 0 {
+		t.Fatal("The interrupt should be held until release")
+	}
+	release()
+	if len(raised) != 1 || raised[0] != os.Interrupt {
+		t.Errorf("Raised %v, want the held interrupt re-raised once", raised)
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"time"
 
-	"github.com/raitses/ask/internal/config"
-	"github.com/raitses/ask/internal/context"
+	"github.com/raitses/ask/pkg/ask"
 )
 
 var (
@@ -16,14 +21,96 @@ var (
 	date    = "unknown"
 )
 
+// userAgent identifies this build in API requests, e.g.
+// "ask/1.4.0 (commit 3f2a1bc; linux/amd64)"
+func userAgent() string {
+	return fmt.Sprintf("ask/%s (commit %s; %s/%s)", version, commit, runtime.GOOS, runtime.GOARCH)
+}
+
+// listFlag collects comma-separated values from a repeatable flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
 func main() {
 	// Define flags
 	analyze := flag.Bool("analyze", false, "Analyze directory structure before responding")
 	analyzeShort := flag.Bool("a", false, "Analyze directory structure before responding (short)")
+	var include, exclude listFlag
+	flag.Var(&include, "include", "With --analyze, include paths matching these globs even if ignored (repeatable)")
+	flag.Var(&exclude, "exclude", "With --analyze, exclude paths matching these globs (repeatable)")
+	maxFiles := flag.Int("max-context-files", 0, "With --analyze, stop after examining this many entries (overrides ASK_ANALYZE_MAX_FILES)")
+	ephemeral := flag.Bool("ephemeral", false, "With --analyze, use the analysis for this query only without saving it")
+	noAnalysis := flag.Bool("no-analysis", false, "Leave the cached analysis out of this query without deleting it")
 	reset := flag.Bool("reset", false, "Clear conversation context for current directory")
 	resetShort := flag.Bool("r", false, "Clear conversation context for current directory (short)")
 	info := flag.Bool("info", false, "Show context information")
 	infoShort := flag.Bool("i", false, "Show context information (short)")
+	jsonOutput := flag.Bool("json", false, "With --info, print machine-readable JSON")
+	history := flag.Bool("history", false, "Show conversation history for current directory")
+	full := flag.Bool("full", false, "With --history, show complete message content")
+	previewLen := flag.Int("preview", 100, "With --history, maximum preview length per message")
+	raw := flag.Bool("raw", false, "Send the conversation without the CLI system prompt")
+	persona := flag.String("as", "", "Answer using a persona preset (see --list-personas)")
+	listPersonas := flag.Bool("list-personas", false, "List available persona presets")
+	template := flag.String("template", "", "Expand a query template from ~/.config/ask/templates/NAME.txt")
+	listTemplates := flag.Bool("list-templates", false, "List available query templates")
+	forget := flag.String("forget", "", "Delete the stored context for a directory")
+	relocate := flag.String("relocate", "", "Move the stored context of a directory's old path to its new path (ask --relocate OLD NEW)")
+	keepStale := flag.Bool("keep-stale", false, "Keep a context older than ASK_CONTEXT_TTL instead of starting fresh")
+	noCache := flag.Bool("no-cache", false, "Don't use cached responses (ASK_RESPONSE_CACHE)")
+	clearCache := flag.Bool("clear-cache", false, "Delete all cached responses")
+	prune := flag.Bool("prune", false, "Prune the context now (AI-selected with an API key, oldest first without)")
+	trim := flag.String("trim", "", "Remove messages older than an age (2d, 12h) or date (2024-05-01)")
+	summarize := flag.Bool("summarize", false, "Summarize the conversation for current directory")
+	save := flag.Bool("save", false, "With --summarize, replace the conversation with the summary; with --replay, keep the new answer")
+	replay := flag.Bool("replay", false, "Re-send the last question (e.g. to another --model) and print the answer without storing it")
+	model := flag.String("model", "", "Use this model for this run (overrides ASK_MODEL)")
+	trace := flag.String("trace", "", "Write every request and raw response of this run, with timing and usage, to this file as JSON")
+	output := flag.String("output", "", "Write the response to a file instead of stdout")
+	outputShort := flag.String("o", "", "Write the response to a file instead of stdout (short)")
+	appendFile := flag.String("append-file", "", "Append the response to a file, separated from earlier answers")
+	codeOnly := flag.Bool("code", false, "With --output or --append-file, write only the first fenced code block")
+	format := flag.String("format", "", "Output preset: plain, markdown, json, or shell (overrides ASK_FORMAT)")
+	noFences := flag.Bool("strip-fences", false, "Print an answer that is a single fenced code block without the fences")
+	force := flag.Bool("force", false, "Overwrite existing files")
+	autoContinue := flag.Bool("complete", false, "Automatically continue answers cut off by the output token limit")
+	continueAnswer := flag.Bool("continue", false, "Get the rest of the last answer, cut off by the output token limit")
+	showUsage := flag.Bool("show-usage", false, "Print how full the context is and the response time after the answer (stderr)")
+	noStreamDelay := flag.Bool("no-stream-delay", false, "Print responses immediately, ignoring ASK_STREAM_DELAY")
+	var files listFlag
+	flag.Var(&files, "files", "Attach comma-separated files or globs to the query (repeatable)")
+	var images listFlag
+	flag.Var(&images, "image", "Attach comma-separated images to the query for vision models (repeatable)")
+	tools := flag.Bool("tools", false, "Let the model propose shell commands and call .ask/tools.json tools (each needs approval)")
+	web := flag.Bool("web", false, "Run ASK_SEARCH_CMD with the query and send its output as search results")
+	retries := flag.Int("retries", -1, "Retry failed API requests this many times (overrides ASK_RETRIES)")
+	choices := flag.Int("n", 0, "Request this many responses and pick one (overrides ASK_N)")
+	seed := flag.Int("seed", -1, "Request reproducible sampling with this seed; temperature defaults to 0 (overrides ASK_SEED)")
+	verbose := flag.Bool("verbose", false, "Print diagnostics such as the provider's system fingerprint and pruning choices (stderr)")
+	explainPrune := flag.Bool("explain-prune", false, "Have AI-driven pruning give a reason for each message it removes, and print them (stderr)")
+	yes := flag.Bool("yes", false, "Send large prompts without asking for confirmation")
+	workDir := flag.String("dir", "", "Use the context of this directory instead of the current one")
+	session := flag.String("session", "", "Use this named session of the directory's context")
+	contextFile := flag.String("context-file", "", "Read and write the context at this path instead of ~/.config/ask/contexts (overrides ASK_CONTEXT_FILE)")
+	listSessions := flag.Bool("list-sessions", false, "List the directory's named sessions")
+	switchSession := flag.String("switch-session", "", "Make a named session the directory's current one (\"default\" to go back)")
+	profile := flag.String("profile", "", "Load ~/.config/ask/profiles/NAME.env (overrides ASK_PROFILE)")
+	warmCache := flag.Bool("warm-cache", false, "Prime Claude's prompt cache with the system prompt and analysis")
+	check := flag.Bool("check", false, "Verify the configuration with a tiny test request")
+	quiet := flag.Bool("quiet", false, "Suppress progress notes and warnings on stderr (errors are still shown)")
+	quietShort := flag.Bool("q", false, "Suppress progress notes and warnings on stderr (short)")
 	showVersion := flag.Bool("version", false, "Show version information")
 	versionShort := flag.Bool("v", false, "Show version information (short)")
 	showHelp := flag.Bool("help", false, "Show help message")
@@ -37,6 +124,24 @@ func main() {
 	*info = *info || *infoShort
 	*showVersion = *showVersion || *versionShort
 	*showHelp = *showHelp || *helpShort
+	*quiet = *quiet || *quietShort
+	if *output == "" {
+		*output = *outputShort
+	}
+	if *output != "" && *appendFile != "" {
+		fmt.Fprintln(os.Stderr, "Error: --output and --append-file can't be combined")
+		os.Exit(1)
+	}
+	if *contextFile != "" && *session != "" {
+		fmt.Fprintln(os.Stderr, "Error: --context-file can't be combined with --session")
+		os.Exit(1)
+	}
+	if *noAnalysis && (*analyze || *ephemeral) {
+		fmt.Fprintln(os.Stderr, "Error: --no-analysis can't be combined with --analyze or --ephemeral")
+		os.Exit(1)
+	}
+
+	ask.SetQuiet(*quiet)
 
 	// Handle special flags
 	if *showVersion {
@@ -55,30 +160,254 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Handle list-personas command
+	if *listPersonas {
+		personas, err := ask.Personas()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		names := make([]string, 0, len(personas))
+		for name := range personas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			description := strings.SplitN(personas[name], "\n", 2)[0]
+			fmt.Printf("  %-16s %s\n", name, description)
+		}
+		os.Exit(0)
+	}
+
+	// Handle list-templates command
+	if *listTemplates {
+		templates, err := ask.Templates()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		names := make([]string, 0, len(templates))
+		for name := range templates {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			firstLine := strings.SplitN(templates[name], "\n", 2)[0]
+			fmt.Printf("  %-16s %s\n", name, firstLine)
+		}
+		os.Exit(0)
+	}
+
+	// Handle clear-cache command (doesn't need API configuration)
+	if *clearCache {
+		removed, err := ask.ClearResponseCache()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(3)
+		}
+		fmt.Printf("Removed %d cached response(s)\n", removed)
+		os.Exit(0)
+	}
+
 	// Load configuration
-	cfg, err := config.Load()
+	if *profile == "" {
+		*profile = os.Getenv("ASK_PROFILE")
+	}
+	cfg, err := ask.LoadConfigProfile(*profile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to load configuration: %v\n", err)
 		os.Exit(2)
 	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = userAgent()
+	}
 
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		fmt.Fprintf(os.Stderr, "Set it with: export ASK_API_KEY='your-api-key'\n")
-		os.Exit(2)
+	// Handle check command (reports invalid configuration itself)
+	if *check {
+		os.Exit(runCheck(cfg))
+	}
+
+	// Handle relocate command (needs only the store and encryption key from the configuration)
+	if *relocate != "" {
+		os.Exit(runRelocate(cfg, *relocate, flag.Args()))
+	}
+
+	// Handle session commands (need only the store from the configuration)
+	if *listSessions || *switchSession != "" {
+		dir := *workDir
+		if dir == "" {
+			dir = "."
+		}
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid path: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *switchSession != "" {
+			if err := ask.SwitchSession(dir, *switchSession); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(3)
+			}
+			fmt.Printf("Switched to session %s\n", *switchSession)
+			os.Exit(0)
+		}
+
+		sessions, current, err := ask.Sessions(cfg, dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(3)
+		}
+		for _, name := range append([]string{""}, sessions...) {
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			if name == "" {
+				name = "default"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+		os.Exit(0)
+	}
+
+	// Handle forget command (needs only the context file and store from the configuration)
+	if *forget != "" {
+		if *contextFile != "" {
+			cfg.ContextFile = *contextFile
+		}
+		dir, err := filepath.Abs(*forget)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid path: %v\n", err)
+			os.Exit(1)
+		}
+		// With a context file, that file is the context every directory uses
+		target := "context for " + dir
+		if cfg.ContextFile != "" {
+			target = "context file " + cfg.ContextFile
+		}
+		if !*force && !confirm(fmt.Sprintf("Delete %s?", target)) {
+			fmt.Println("Aborted")
+			os.Exit(1)
+		}
+		if err := ask.Forget(cfg, dir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(3)
+		}
+		fmt.Printf("Deleted %s\n", target)
+		os.Exit(0)
+	}
+
+	if *workDir != "" {
+		absDir, err := filepath.Abs(*workDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid path: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.Dir = absDir
+	}
+	cfg.Session = *session
+	cfg.TraceFile = *trace
+	if *contextFile != "" {
+		cfg.ContextFile = *contextFile
+	}
+	cfg.Persona = *persona
+	if *model != "" {
+		cfg.Model = *model
+	}
+	cfg.RawPrompt = *raw
+	if *format != "" {
+		cfg.Format = strings.ToLower(*format)
+	}
+	if (*output != "" || *appendFile != "") && cfg.Format == "" {
+		// Files are read in an editor or renderer, not a terminal
+		cfg.Markdown = true
+	}
+	cfg.Tools = *tools
+	cfg.Web = *web
+	if len(include) > 0 {
+		cfg.AnalyzeInclude = include
+	}
+	if len(exclude) > 0 {
+		cfg.AnalyzeExclude = exclude
+	}
+	if *maxFiles > 0 {
+		cfg.AnalyzeMaxFiles = *maxFiles
+	}
+	if *retries >= 0 {
+		cfg.Retries = *retries
+	}
+	cfg.KeepStale = *keepStale
+	cfg.NoAnalysis = *noAnalysis
+	cfg.Verbose = *verbose
+	cfg.ExplainPrune = *explainPrune
+	if *seed >= 0 {
+		cfg.Seed = seed
+	}
+	if cfg.Seed != nil && cfg.Temperature == nil {
+		// Seeded sampling is only reproducible without temperature noise
+		zero := 0.0
+		cfg.Temperature = &zero
+	}
+	if *autoContinue {
+		cfg.AutoContinue = true
+	}
+	if *showUsage {
+		cfg.ShowUsage = true
+	}
+	if *noCache {
+		cfg.ResponseCache = false
+	}
+	if *choices > 0 {
+		cfg.Choices = *choices
 	}
 
-	// Create context manager
-	manager, err := context.NewManager(cfg)
+	// Validate configuration (pruning falls back to hard pruning without a key)
+	if !*prune {
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			if cfg.APIKey == "" {
+				fmt.Fprintf(os.Stderr, "Set it with: export ASK_API_KEY='your-api-key'\n")
+			}
+			os.Exit(2)
+		}
+		if warning := cfg.ModelMismatchWarning(); warning != "" {
+			ask.Infof("⚠️  Warning: %s\n", warning)
+		}
+	}
+
+	// Create client for the directory's context (current directory unless --dir)
+	client, err := ask.New(cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: Failed to initialize context: %v\n", err)
 		os.Exit(3)
 	}
 
+	if *yes {
+		client.SetConfirm(func(string) bool { return true })
+	} else {
+		client.SetConfirm(confirm)
+	}
+
+	// Don't let Ctrl-C lose an answer while it's being saved
+	client.SetSaveGuard(holdInterrupts)
+
+	// Commands always need explicit approval, even with --yes
+	client.SetApproveCommand(func(command string) bool {
+		return confirm(fmt.Sprintf("Run command: %s\n  Allow?", command))
+	})
+
+	// Offer a pick between multiple responses only when someone can answer;
+	// scripts get the first
+	if isTerminal(os.Stdin) && isTerminal(os.Stderr) {
+		client.SetChooseResponse(func(responses []string) int {
+			return chooseResponse(stdin, os.Stderr, responses)
+		})
+	}
+
 	// Handle reset command
 	if *reset {
-		if err := manager.Reset(); err != nil {
+		if err := client.Reset(); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: Failed to reset context: %v\n", err)
 			os.Exit(3)
 		}
@@ -88,40 +417,227 @@ func main() {
 
 	// Handle info command
 	if *info {
-		fmt.Print(manager.GetInfo())
+		if *jsonOutput {
+			data, err := json.MarshalIndent(client.Stats(), "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+			os.Exit(0)
+		}
+		fmt.Print(client.Info())
 		os.Exit(0)
 	}
 
-	// Get query from remaining arguments
-	args := flag.Args()
-	if len(args) == 0 {
-		printUsage()
-		os.Exit(1)
+	// Handle history command
+	if *history {
+		fmt.Print(client.History(*previewLen, *full))
+		os.Exit(0)
 	}
 
-	query := strings.Join(args, " ")
+	// Handle trim command
+	if *trim != "" {
+		cutoff, err := parseCutoff(*trim, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		removed, err := client.Trim(cutoff)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to trim context: %v\n", err)
+			os.Exit(3)
+		}
+		fmt.Printf("Removed %d message(s) older than %s\n", removed, cutoff.Format("2006-01-02 15:04:05"))
+		os.Exit(0)
+	}
 
-	// Perform analysis if requested
-	if *analyze {
-		fmt.Fprintln(os.Stderr, "Analyzing directory structure...")
-		err := manager.Analyze()
+	// Handle prune command
+	if *prune {
+		result, err := client.Prune()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(3)
+		}
+		if result.MessagesAfter == result.MessagesBefore {
+			fmt.Printf("Nothing to prune (%d messages, ~%d tokens)\n", result.MessagesBefore, result.TokensBefore)
+			os.Exit(0)
+		}
+		fmt.Printf("Pruned %d message(s): %d → %d messages, ~%d → ~%d tokens\n",
+			result.MessagesBefore-result.MessagesAfter, result.MessagesBefore, result.MessagesAfter,
+			result.TokensBefore, result.TokensAfter)
+		os.Exit(0)
+	}
+
+	// Handle summarize command
+	if *summarize {
+		summary, err := client.Summarize(*save)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to summarize context: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(summary)
+		if *save {
+			ask.Infof("Conversation replaced with summary\n")
+		}
+		os.Exit(0)
+	}
+
+	// Handle replay command
+	if *replay {
+		answer, err := client.Replay(*save)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Failed to replay: %v\n", err)
+			os.Exit(3)
+		}
+		fmt.Println(answer)
+		if *save {
+			ask.Infof("Stored answer replaced with the %s answer\n", cfg.Model)
+		}
+		os.Exit(0)
+	}
+
+	// Handle warm-cache command (after --analyze, so the analysis is cached too)
+	if *warmCache {
+		if *analyze {
+			runAnalysis(client, false)
+		}
+		usage, err := client.WarmCache()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: Analysis failed: %v\n", err)
-			// Continue with query even if analysis fails
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
-		if err == nil {
-			fmt.Fprintln(os.Stderr, "Analysis complete.")
+		switch {
+		case usage.CacheCreationInputTokens > 0:
+			fmt.Printf("Prompt cache warmed: %d tokens written (%d read)\n", usage.CacheCreationInputTokens, usage.CacheReadInputTokens)
+		case usage.CacheReadInputTokens > 0:
+			fmt.Printf("Prompt cache already warm: %d tokens read\n", usage.CacheReadInputTokens)
+		default:
+			fmt.Println("Provider reported no cache activity (prompts under ~1024 tokens aren't cached)")
 		}
+		os.Exit(0)
+	}
+
+	// Get query from remaining arguments
+	args := flag.Args()
+	query := strings.Join(args, " ")
+	if *continueAnswer {
+		if len(args) > 0 || *template != "" {
+			fmt.Fprintln(os.Stderr, "Error: --continue takes no query")
+			os.Exit(1)
+		}
+		if !client.LastAnswerTruncated() {
+			fmt.Fprintln(os.Stderr, "Error: The last answer wasn't cut off; nothing to continue")
+			os.Exit(1)
+		}
+		query = ask.ContinuePrompt
+	} else if len(args) == 0 && *template == "" {
+		// A bare ask in a terminal runs the default query; piped input or
+		// no default still gets usage
+		if cfg.DefaultQuery == "" || !isTerminal(os.Stdin) {
+			printUsage()
+			os.Exit(1)
+		}
+		query = cfg.DefaultQuery
+	}
+
+	if *template != "" {
+		query, err = expandTemplate(*template, query)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Perform analysis if requested
+	if *analyze || *ephemeral {
+		runAnalysis(client, *ephemeral)
 	}
 
 	// Execute query
-	response, err := manager.Query(query)
+	response, err := client.AskWithImages(query, files, images)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *noFences {
+		response = stripFences(response)
+	}
+	response, err = formatAnswer(cfg.Format, query, response, cfg.Model, client.SystemFingerprint())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		client.Wait()
 		os.Exit(1)
 	}
 
-	fmt.Println(response)
+	if *output != "" {
+		if err := writeOutput(*output, response, *codeOnly, *force); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			client.Wait()
+			os.Exit(1)
+		}
+		ask.Infof("Response written to %s\n", *output)
+	} else if *appendFile != "" {
+		if err := appendOutput(*appendFile, response, *codeOnly); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			client.Wait()
+			os.Exit(1)
+		}
+		ask.Infof("Response appended to %s\n", *appendFile)
+	} else if cfg.StreamDelay > 0 && !*noStreamDelay && cfg.Format != ask.FormatJSON && cfg.Format != ask.FormatShell {
+		w := newThrottledWriter(os.Stdout, cfg.StreamDelay)
+		fmt.Fprintln(w, response)
+		_ = w.Close()
+	} else {
+		fmt.Println(response)
+	}
+
+	if cfg.ShowUsage {
+		fmt.Fprintln(os.Stderr, client.UsageFooter())
+	}
+	if cfg.ShowUsage || cfg.Verbose {
+		fmt.Fprintf(os.Stderr, "Responded in %.1fs\n", client.Latency().Seconds())
+	}
+	if cfg.Verbose {
+		fingerprint := client.SystemFingerprint()
+		if fingerprint == "" {
+			fingerprint = "(not reported)"
+		}
+		fmt.Fprintf(os.Stderr, "System fingerprint: %s\n", fingerprint)
+	}
+
+	// Let background pruning finish before exiting
+	client.Wait()
+}
+
+// runAnalysis analyzes the directory, continuing with a warning on failure
+func runAnalysis(client *ask.Client, ephemeral bool) {
+	ask.Infof("Analyzing directory structure...\n")
+	var err error
+	if ephemeral {
+		err = client.AnalyzeEphemeral()
+	} else {
+		err = client.Analyze()
+	}
+	if err != nil {
+		ask.Infof("Warning: Analysis failed: %v\n", err)
+		return
+	}
+	ask.Infof("Analysis complete.\n")
+}
+
+// stdin reads answers to every prompt. A single reader is shared because a
+// reader may buffer past the line it returns, and a second reader would
+// lose what the first buffered, e.g. the next answer piped to ask.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := stdin.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 func printUsage() {
@@ -129,8 +645,63 @@ func printUsage() {
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  -a, --analyze      Analyze directory structure before responding")
+	fmt.Println("  --include GLOBS    With --analyze, include matching paths even if ignored")
+	fmt.Println("  --exclude GLOBS    With --analyze, skip matching paths (e.g. testdata)")
+	fmt.Println("  --max-context-files N  With --analyze, stop after N entries (default: ASK_ANALYZE_MAX_FILES or 5000)")
+	fmt.Println("  --ephemeral        With --analyze, use the analysis for this query only (not saved)")
+	fmt.Println("  --no-analysis      Leave the cached analysis out of this query (it stays cached)")
 	fmt.Println("  -r, --reset        Clear conversation context for current directory")
-	fmt.Println("  -i, --info         Show context information")
+	fmt.Println("  -i, --info         Show context information (add --json for machine-readable output)")
+	fmt.Println("  --history          Show conversation history (--full for complete content,")
+	fmt.Println("                     --preview N to set preview length)")
+	fmt.Println("  --trim AGE|DATE    Remove messages older than AGE (2d, 12h) or DATE (2024-05-01)")
+	fmt.Println("  --keep-stale       Keep a context older than ASK_CONTEXT_TTL instead of resetting it")
+	fmt.Println("  --prune            Prune older messages now (AI-selected when an API key is set)")
+	fmt.Println("  --summarize        Summarize the conversation (add --save to replace history)")
+	fmt.Println("  --replay           Re-send the last question and print the answer (add --save to store it)")
+	fmt.Println("  --model NAME       Use this model for this run (overrides ASK_MODEL)")
+	fmt.Println("  -o, --output FILE  Write the response to FILE (add --code for first code block only)")
+	fmt.Println("  --append-file FILE Append the response to FILE, separated by ---, to build it up across asks")
+	fmt.Println("  --format PRESET    plain, markdown, json (answer in a JSON object), or shell (just a command)")
+	fmt.Println("  --strip-fences     Drop the ``` fences when the whole answer is one code block")
+	fmt.Println("  --complete         Automatically continue truncated answers")
+	fmt.Println("  --continue         Get the rest of the last answer, if it was truncated")
+	fmt.Println("  --no-stream-delay  Print responses immediately, ignoring ASK_STREAM_DELAY")
+	fmt.Println("  --show-usage       Show context usage and response time after the answer, e.g. (context: 18k/25k tokens)")
+	fmt.Println("  --files A,B        Attach files or globs ('pkg/**/*.go') to this query (repeatable)")
+	fmt.Println("  --image A,B        Attach PNG/JPEG/GIF/WebP images for vision models (repeatable)")
+	fmt.Println("  --tools            Let the model propose shell commands and call the project's")
+	fmt.Println("                     .ask/tools.json tools (each needs approval)")
+	fmt.Println("  --web              Search with ASK_SEARCH_CMD first and send the results along")
+	fmt.Println("  --retries N        Retry failed API requests N times (default: ASK_RETRIES or 2)")
+	fmt.Println("  --n N              Request N responses and pick one to keep (first when not a terminal)")
+	fmt.Println("  --seed N           Reproducible sampling with seed N (temperature 0 unless ASK_TEMPERATURE is set)")
+	fmt.Println("  --verbose          Show response time, the provider's system fingerprint, and which messages pruning removed")
+	fmt.Println("  --trace FILE       Write this run's requests and raw responses (API key redacted) to FILE as JSON")
+	fmt.Println("  --explain-prune    Ask AI-driven pruning for a reason per removed message and show them")
+	fmt.Println("  --yes              Skip the ASK_CONFIRM_TOKENS confirmation prompt")
+	fmt.Println("  --raw              Skip the CLI system prompt (markdown, long answers allowed)")
+	fmt.Println("  --as NAME          Answer using a persona preset (reviewer, teacher, shell-wizard, ...)")
+	fmt.Println("  --list-personas    List available persona presets")
+	fmt.Println("  --template NAME    Use ~/.config/ask/templates/NAME.txt as the query, filling")
+	fmt.Println("                     {{input}} with the arguments and {{stdin}} with piped input")
+	fmt.Println("  --list-templates   List available query templates")
+	fmt.Println("  --no-cache         Ignore cached responses for this query (ASK_RESPONSE_CACHE)")
+	fmt.Println("  --clear-cache      Delete all cached responses")
+	fmt.Println("  --forget PATH      Delete the stored context for PATH (. for current directory),")
+	fmt.Println("                     or the context file if one is set")
+	fmt.Println("  --relocate OLD NEW Move the stored context of a project moved from OLD to NEW")
+	fmt.Println("  --profile NAME     Use ~/.config/ask/profiles/NAME.env over the global config")
+	fmt.Println("  --dir PATH         Use the context (and --analyze target) of PATH instead of")
+	fmt.Println("                     the current directory")
+	fmt.Println("  --session NAME     Use a separate named conversation in this directory")
+	fmt.Println("  --list-sessions    List this directory's sessions (* marks the current one)")
+	fmt.Println("  --switch-session NAME  Make NAME the current session (default to go back)")
+	fmt.Println("  --context-file PATH  Keep the context in PATH instead of ~/.config/ask/contexts")
+	fmt.Println("  --force            Overwrite files / skip confirmation prompts")
+	fmt.Println("  --warm-cache       Prime Claude's prompt cache (add --analyze to refresh analysis first)")
+	fmt.Println("  --check            Verify the configuration works (exits nonzero on failure)")
+	fmt.Println("  -q, --quiet        Suppress progress notes and warnings on stderr (errors still shown)")
 	fmt.Println("  -h, --help         Show this help message")
 	fmt.Println("  -v, --version      Show version information")
 	fmt.Println()
@@ -138,8 +709,26 @@ func printUsage() {
 	fmt.Println("  ask how do I run tests")
 	fmt.Println("  ask \"how does this work?\"")
 	fmt.Println("  ask --analyze what is the project structure")
+	fmt.Println("  ask --analyze --ephemeral where is the retry logic")
 	fmt.Println("  ask --reset")
 	fmt.Println("  ask --info")
+	fmt.Println("  ask --as reviewer is this error handling correct")
+	fmt.Println("  ask --template explain \"undefined: foo\"")
+	fmt.Println("  git diff | ask --template review")
+	fmt.Println("  ask --history --full")
+	fmt.Println("  ask --forget ~/old-project")
+	fmt.Println("  ask --relocate ~/src/old-name ~/src/new-name")
+	fmt.Println("  ask --profile work how do I deploy")
+	fmt.Println("  ask --dir ~/src/api --info")
+	fmt.Println("  ask --session debug why does the test hang")
+	fmt.Println("  ask --trim 2d")
+	fmt.Println("  ask --summarize --save")
+	fmt.Println("  ask --replay --model gpt-4o-mini")
+	fmt.Println("  ask --files main.go,config.go why does startup fail")
+	fmt.Println("  ask --image screenshot.png what's wrong here")
+	fmt.Println("  ask --tools why is the build failing")
+	fmt.Println("  ask --web what is the latest Go release")
+	fmt.Println("  ask -o Dockerfile --code generate a Dockerfile for this project")
 }
 
 func printHelp() {
@@ -148,12 +737,13 @@ func printHelp() {
 	fmt.Println("Environment Variables:")
 	fmt.Println("  ASK_API_KEY        API key for LLM provider (required for OpenAI)")
 	fmt.Println("  ASK_MODEL          Model to use (default: gpt-4o)")
-	fmt.Println("  ASK_OS             Operating system (default: macOS)")
+	fmt.Println("  ASK_OS             Operating system (default: detected, e.g. Linux)")
 	fmt.Println("  ASK_API_URL        API endpoint (default: OpenAI)")
 	fmt.Println()
 	fmt.Println("Configuration:")
 	fmt.Println("  Config files are loaded in this order:")
-	fmt.Println("  1. ~/.config/ask/.env (global)")
+	fmt.Println("  1. ~/.config/ask/.env (global), with ~/.config/ask/profiles/NAME.env")
+	fmt.Println("     layered over it when --profile NAME or ASK_PROFILE is set")
 	fmt.Println("  2. ./.env (local, overrides global)")
 	fmt.Println("  3. Environment variables (highest priority)")
 	fmt.Println()
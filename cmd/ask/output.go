@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/raitses/ask/pkg/ask"
+)
+
+// answerJSON is the result printed with --format json
+type answerJSON struct {
+	Query             string `json:"query"`
+	Answer            string `json:"answer"`
+	Model             string `json:"model"`
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+}
+
+// formatAnswer shapes the response for the output format preset: shell
+// answers lose any code fence and surrounding whitespace so they can be run
+// as-is, and json answers are wrapped with the query and model
+func formatAnswer(format, query, response, model, fingerprint string) (string, error) {
+	switch format {
+	case ask.FormatShell:
+		return strings.TrimSpace(stripFences(response)), nil
+	case ask.FormatJSON:
+		data, err := json.MarshalIndent(answerJSON{
+			Query:             query,
+			Answer:            response,
+			Model:             model,
+			SystemFingerprint: fingerprint,
+		}, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to encode answer: %w", err)
+		}
+		return string(data), nil
+	}
+	return response, nil
+}
+
+// writeOutput writes the response to path, optionally keeping only the first
+// fenced code block. Existing files are only overwritten when force is set.
+func writeOutput(path, response string, codeOnly, force bool) error {
+	content, err := outputContent(response, codeOnly)
+	if err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+		}
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+
+	return nil
+}
+
+// appendSeparator goes between answers appended to the same file
+const appendSeparator = "\n---\n\n"
+
+// appendOutput adds the response (or its first code block) to the end of the
+// file at path, creating it if needed. Answers after the first are preceded
+// by appendSeparator so a file built up over several asks stays readable.
+func appendOutput(path, response string, codeOnly bool) error {
+	content, err := outputContent(response, codeOnly)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		content = appendSeparator + content
+	}
+
+	if _, err := file.WriteString(content); err != nil {
+		return fmt.Errorf("failed to append to output file: %w", err)
+	}
+
+	return nil
+}
+
+// outputContent returns what to write for a response: the whole response or
+// its first code block, ending in a newline
+func outputContent(response string, codeOnly bool) (string, error) {
+	content := response
+	if codeOnly {
+		code, ok := extractCodeBlock(response)
+		if !ok {
+			return "", fmt.Errorf("no fenced code block found in response")
+		}
+		content = code
+	}
+
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content, nil
+}
+
+// extractCodeBlock returns the contents of the first fenced code block,
+// without the fence lines
+func extractCodeBlock(text string) (string, bool) {
+	lines := strings.Split(text, "\n")
+
+	start := -1
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			continue
+		}
+		if start == -1 {
+			start = i
+			continue
+		}
+		return strings.Join(lines[start+1:i], "\n"), true
+	}
+
+	return "", false
+}
+
+// stripFences returns the contents of a response that is exactly one fenced
+// code block, without the fences. Anything else, such as prose around the
+// block or several blocks, is returned unchanged.
+func stripFences(response string) string {
+	lines := strings.Split(strings.TrimSpace(response), "\n")
+	if len(lines) < 2 {
+		return response
+	}
+
+	// The closing fence must be at least as long as the opening one; inner
+	// fences that long would close the block early, so leave those alone
+	fence := fenceLength(lines[0])
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if fence == 0 || fenceLength(last) < fence || strings.Trim(last, "`") != "" {
+		return response
+	}
+	inner := lines[1 : len(lines)-1]
+	for _, line := range inner {
+		if fenceLength(line) >= fence {
+			return response
+		}
+	}
+
+	return strings.Join(inner, "\n")
+}
+
+// fenceLength returns the number of backticks opening a fence line, or 0 if
+// line isn't a fence (fewer than three backticks)
+func fenceLength(line string) int {
+	line = strings.TrimSpace(line)
+	n := len(line) - len(strings.TrimLeft(line, "`"))
+	if n < 3 {
+		return 0
+	}
+	return n
+}
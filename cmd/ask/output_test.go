@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raitses/ask/pkg/ask"
+)
+
+func TestExtractCodeBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{"single block", "Here:\n```dockerfile\nFROM golang\nRUN make\n```\nDone.", "FROM golang\nRUN make", true},
+		{"first of several", "```sh\nmake\n```\n```sh\nmake test\n```", "make", true},
+		{"no block", "Just prose", "", false},
+		{"unterminated block", "```go\nfunc main() {}", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractCodeBlock(tt.text)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("extractCodeBlock() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestStripFences(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"single block", "```sh\nls -la\n```", "ls -la"},
+		{"no language", "```\nls -la\n```", "ls -la"},
+		{"surrounding whitespace", "\n```go\nfunc main() {}\n```\n", "func main() {}"},
+		{"multi-line block", "```yaml\na: 1\nb: 2\n```", "a: 1\nb: 2"},
+		{"longer fence with nested block", "````md\nUse:\n```sh\nmake\n```\n````", "Use:\n```sh\nmake\n```"},
+		{"unfenced", "ls -la", "ls -la"},
+		{"prose before block", "Run:\n```sh\nls\n```", "Run:\n```sh\nls\n```"},
+		{"prose after block", "```sh\nls\n```\nThen check the output.", "```sh\nls\n```\nThen check the output."},
+		{"two blocks", "```sh\nmake\n```\n```sh\nmake test\n```", "```sh\nmake\n```\n```sh\nmake test\n```"},
+		{"unterminated", "```go\nfunc main() {}", "```go\nfunc main() {}"},
+		{"inline code", "`ls`", "`ls`"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripFences(tt.text); got != tt.want {
+				t.Errorf("stripFences(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteOutputRefusesOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Dockerfile")
+
+	if err := writeOutput(path, "first", false, false); err != nil {
+		t.Fatalf("writeOutput() failed: %v", err)
+	}
+
+	if err := writeOutput(path, "second", false, false); err == nil {
+		t.Error("writeOutput() should refuse to overwrite without force")
+	}
+
+	if err := writeOutput(path, "```\nthird\n```", true, true); err != nil {
+		t.Fatalf("writeOutput() with force failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "third\n" {
+		t.Errorf("File content = %q, want %q", data, "third\n")
+	}
+}
+
+func TestAppendOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.md")
+
+	if err := appendOutput(path, "First answer", false); err != nil {
+		t.Fatalf("appendOutput() failed: %v", err)
+	}
+	if err := appendOutput(path, "Use:\n```sh\nmake\n```", true); err != nil {
+		t.Fatalf("appendOutput() with code failed: %v", err)
+	}
+	if err := appendOutput(path, "No code here", true); err == nil {
+		t.Error("appendOutput() should fail without a code block")
+	}
+
+	want := "First answer\n" + appendSeparator + "make\n"
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("File content = %q, want %q", data, want)
+	}
+}
+
+func TestFormatAnswer(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		response string
+		want     string
+	}{
+		{"plain unchanged", "", "Use `ls -a`.", "Use `ls -a`."},
+		{"markdown unchanged", ask.FormatMarkdown, "**Use** ls -a", "**Use** ls -a"},
+		{"shell strips fences", ask.FormatShell, "```sh\nls -a\n```\n", "ls -a"},
+		{"shell trims bare command", ask.FormatShell, "  ls -a\n", "ls -a"},
+		{"json wraps answer", ask.FormatJSON, "Use ls -a.", "{\n  \"query\": \"list hidden files\",\n  \"answer\": \"Use ls -a.\",\n  \"model\": \"gpt-4o\"\n}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatAnswer(tt.format, "list hidden files", tt.response, "gpt-4o", "")
+			if err != nil {
+				t.Fatalf("formatAnswer() failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("formatAnswer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/raitses/ask/pkg/ask"
+)
+
+// runRelocate moves the stored context of a project from its old path to
+// args[0], its new path. It returns the process exit code.
+func runRelocate(cfg *ask.Config, from string, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ask --relocate OLD-PATH NEW-PATH")
+		return 1
+	}
+
+	from, err := filepath.Abs(from)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Invalid path: %v\n", err)
+		return 1
+	}
+	to, err := filepath.Abs(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Invalid path: %v\n", err)
+		return 1
+	}
+	if info, err := os.Stat(to); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Error: %s is not a directory\n", to)
+		return 1
+	}
+
+	moved, err := ask.Relocate(cfg, from, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 3
+	}
+	if moved == 1 {
+		fmt.Printf("Context moved from %s to %s\n", from, to)
+	} else {
+		fmt.Printf("%d contexts (including named sessions) moved from %s to %s\n", moved, from, to)
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/raitses/ask/pkg/ask"
+)
+
+// expandTemplate builds the query from the named template, reading piped
+// standard input if the template uses {{stdin}}
+func expandTemplate(name, input string) (string, error) {
+	templates, err := ask.Templates()
+	if err != nil {
+		return "", err
+	}
+	template, ok := templates[name]
+	if !ok {
+		return "", fmt.Errorf("unknown template %q (see ask --list-templates)", name)
+	}
+
+	var stdin string
+	if ask.TemplateUsesStdin(template) {
+		if isTerminal(os.Stdin) {
+			return "", fmt.Errorf("template %q reads {{stdin}}; pipe input into ask", name)
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read stdin: %w", err)
+		}
+		stdin = string(data)
+	}
+
+	return ask.ExpandTemplate(template, input, stdin), nil
+}
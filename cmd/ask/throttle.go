@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// sleep pauses between chunks (replaced in tests)
+var sleep = time.Sleep
+
+// throttledWriter writes to out one word at a time with a delay between
+// chunks for a "typing" effect. Writes are queued and flushed by a separate
+// goroutine, so a producer (e.g. a network reader) never waits on the delay.
+type throttledWriter struct {
+	out   io.Writer
+	delay time.Duration
+
+	mu      sync.Mutex
+	pending [][]byte
+	closed  bool
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// newThrottledWriter starts a writer that flushes chunks to out every delay
+func newThrottledWriter(out io.Writer, delay time.Duration) *throttledWriter {
+	w := &throttledWriter{
+		out:   out,
+		delay: delay,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues p for throttled output and returns immediately
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	chunks := splitWords(p)
+
+	w.mu.Lock()
+	w.pending = append(w.pending, chunks...)
+	w.mu.Unlock()
+
+	w.signal()
+	return len(p), nil
+}
+
+// Close flushes all queued output and stops the writer
+func (w *throttledWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	w.signal()
+	<-w.done
+	return nil
+}
+
+// signal wakes the flush loop without blocking
+func (w *throttledWriter) signal() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run flushes queued chunks until the writer is closed and drained
+func (w *throttledWriter) run() {
+	defer close(w.done)
+
+	for {
+		w.mu.Lock()
+		if len(w.pending) == 0 {
+			closed := w.closed
+			w.mu.Unlock()
+			if closed {
+				return
+			}
+			<-w.wake
+			continue
+		}
+		chunk := w.pending[0]
+		w.pending = w.pending[1:]
+		w.mu.Unlock()
+
+		_, _ = w.out.Write(chunk)
+		sleep(w.delay)
+	}
+}
+
+// splitWords splits p into chunks that each end after a run of whitespace
+func splitWords(p []byte) [][]byte {
+	var chunks [][]byte
+	start := 0
+	for i := 0; i < len(p); i++ {
+		isSpace := p[i] == ' ' || p[i] == '\n' || p[i] == '\t'
+		nextIsSpace := i+1 < len(p) && (p[i+1] == ' ' || p[i+1] == '\n' || p[i+1] == '\t')
+		if isSpace && !nextIsSpace {
+			chunks = append(chunks, append([]byte(nil), p[start:i+1]...))
+			start = i + 1
+		}
+	}
+	if start < len(p) {
+		chunks = append(chunks, append([]byte(nil), p[start:]...))
+	}
+	return chunks
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	// Sleeps are recorded, and held until the test lets them finish
+	var mu sync.Mutex
+	var slept []time.Duration
+	resume := make(chan struct{})
+	origSleep := sleep
+	defer func() { sleep = origSleep }()
+	sleep = func(d time.Duration) {
+		<-resume
+		mu.Lock()
+		slept = append(slept, d)
+		mu.Unlock()
+	}
+
+	var out bytes.Buffer
+	w := newThrottledWriter(&out, 10*time.Millisecond)
+
+	// Write must not wait on the flush loop, which is held in sleep until resumed
+	text := "Run the tests with:\n\n  make test\n"
+	fmt.Fprint(w, text)
+	close(resume)
+	_ = w.Close()
+
+	if out.String() != text {
+		t.Errorf("Output = %q, want %q", out.String(), text)
+	}
+	want := len(splitWords([]byte(text)))
+	if len(slept) != want {
+		t.Fatalf("Slept %d times, want once after each of %d chunks", len(slept), want)
+	}
+	for _, d := range slept {
+		if d != 10*time.Millisecond {
+			t.Errorf("Slept %v, want the 10ms delay", d)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	chunks := splitWords([]byte("one two  three\nfour"))
+
+	var got []string
+	for _, c := range chunks {
+		got = append(got, string(c))
+	}
+
+	want := []string{"one ", "two  ", "three\n", "four"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("splitWords() = %q, want %q", got, want)
+	}
+}
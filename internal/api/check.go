@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Ping sends a minimal completion request to verify the configuration works.
+// It returns the reply and how long the request took.
+func (c *Client) Ping() (string, time.Duration, error) {
+	start := time.Now()
+	completion, err := c.Complete([]ChatMessage{{Role: "user", Content: "Reply with OK and nothing else."}})
+	return completion.Content, time.Since(start), err
+}
+
+// ProbeTimeout bounds the connectivity check for local endpoints
+const ProbeTimeout = 2 * time.Second
+
+// Probe checks that a local endpoint (localhost or a private address) accepts
+// connections, so a mistyped port or a stopped server fails fast instead of
+// after the query is stored. Remote endpoints aren't probed; their failures
+// surface from the request itself.
+func (c *Client) Probe() error {
+	u, err := url.Parse(c.config.APIURL)
+	if err != nil || !isLocalHost(u.Hostname()) {
+		return nil
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), ProbeTimeout)
+	if err != nil {
+		return fmt.Errorf("%w: can't reach %s, is your local server running?", ErrNetwork, c.config.APIURL)
+	}
+	return conn.Close()
+}
+
+// isLocalHost reports whether host names this machine or a private network
+func isLocalHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified())
+}
+
+// Diagnose classifies a request error as a short hint at what to fix
+func Diagnose(err error) string {
+	switch {
+	case errors.Is(err, ErrAuth):
+		return "bad API key: the provider rejected ASK_API_KEY"
+	case errors.Is(err, ErrRateLimited):
+		return "rate limited or out of quota"
+	case errors.Is(err, ErrServer):
+		return "provider error: the API is failing, try again later"
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		if statusErr.StatusCode == http.StatusNotFound {
+			return "bad URL or model: nothing found at ASK_API_URL for ASK_MODEL"
+		}
+		return "request rejected: check ASK_MODEL and the sampling settings"
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "bad URL: the host in ASK_API_URL could not be resolved"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "network timeout: the API did not respond in time"
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		if urlErr.Op == "parse" || strings.Contains(urlErr.Err.Error(), "unsupported protocol scheme") {
+			return "bad URL: ASK_API_URL is not a valid http(s) URL"
+		}
+		return "network error: could not connect to ASK_API_URL"
+	}
+	if errors.Is(err, ErrContextLengthExceeded) {
+		return "request too long for the model"
+	}
+	return "unexpected response: check that ASK_API_URL is a chat completions endpoint"
+}
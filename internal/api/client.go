@@ -3,8 +3,10 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
 	"net/http"
 	"strings"
 	"time"
@@ -16,6 +18,8 @@ import (
 type Client struct {
 	config     *config.Config
 	httpClient *http.Client
+	sleep      func(time.Duration) // Waits between retries (replaced in tests)
+	trace      *Trace              // Records every exchange when --trace is set
 }
 
 // NewClient creates a new API client
@@ -25,9 +29,19 @@ func NewClient(cfg *config.Config) *Client {
 		httpClient: &http.Client{
 			Timeout: 60 * time.Second,
 		},
+		sleep: time.Sleep,
+		trace: newTrace(cfg, cfg.TraceFile),
 	}
 }
 
+// NewClientWithTransport creates an API client that sends requests through
+// transport, e.g. a stub in tests
+func NewClientWithTransport(cfg *config.Config, transport http.RoundTripper) *Client {
+	client := NewClient(cfg)
+	client.httpClient.Transport = transport
+	return client
+}
+
 // isClaudeAPI detects if the configured API is Anthropic's Claude
 func (c *Client) isClaudeAPI() bool {
 	url := strings.ToLower(c.config.APIURL)
@@ -39,44 +53,186 @@ func (c *Client) IsClaudeAPI() bool {
 	return c.isClaudeAPI()
 }
 
+// UncachedPromptTokens returns the prompt tokens in u that neither read from
+// nor were written to the prompt cache. Claude reports cache tokens apart
+// from the prompt tokens; OpenAI-compatible providers count them in it.
+func (c *Client) UncachedPromptTokens(u Usage) int {
+	if c.isClaudeAPI() {
+		return u.PromptTokens
+	}
+	return max(u.PromptTokens-u.CacheCreationInputTokens-u.CacheReadInputTokens, 0)
+}
+
+// userAgent returns the User-Agent header sent with requests
+func (c *Client) userAgent() string {
+	if c.config.UserAgent != "" {
+		return c.config.UserAgent
+	}
+	return config.DefaultUserAgent
+}
+
+// InstructionRole returns the role to use for system prompt messages.
+// ASK_INSTRUCTION_ROLE overrides detection; otherwise OpenAI models that
+// prefer it get "developer" and everything else gets "system".
+func (c *Client) InstructionRole() string {
+	if c.config.InstructionRole != "" {
+		return c.config.InstructionRole
+	}
+	if !c.isClaudeAPI() && PrefersDeveloperRole(c.config.Model) {
+		return RoleDeveloper
+	}
+	return RoleSystem
+}
+
 // ChatCompletion sends a chat completion request and returns the response
 func (c *Client) ChatCompletion(messages []ChatMessage) (string, error) {
-	req := ChatCompletionRequest{
-		Model:    c.config.Model,
-		Messages: messages,
+	completion, err := c.Complete(messages)
+	if err != nil {
+		return "", err
 	}
+	return completion.Content, nil
+}
+
+// Complete sends a chat completion request and returns the response
+// along with the reason the model stopped generating
+func (c *Client) Complete(messages []ChatMessage) (Completion, error) {
+	return c.send(c.buildRequest(messages))
+}
+
+// CompleteWithModel sends a chat completion request like Complete, but to
+// model instead of the configured one, e.g. a cheaper model for housekeeping
+func (c *Client) CompleteWithModel(messages []ChatMessage, model string) (Completion, error) {
+	return c.send(c.buildRequestFor(model, messages))
+}
+
+// CompleteChoices sends a chat completion request asking for n choices.
+// When more than one is returned, the completion's Choices lists them all.
+// Claude's API has no n parameter, so it always returns one choice.
+func (c *Client) CompleteChoices(messages []ChatMessage, n int) (Completion, error) {
+	req := c.buildRequest(messages)
+	if n > 1 && !c.isClaudeAPI() {
+		req.N = n
+	}
+	return c.send(req)
+}
 
-	body, err := json.Marshal(req)
+// CompleteWithTools sends a chat completion request offering the given tools.
+// The returned completion's ToolCalls lists any tools the model wants called.
+func (c *Client) CompleteWithTools(messages []ChatMessage, tools []Tool) (Completion, error) {
+	req := c.buildRequest(messages)
+	req.Tools = tools
+	return c.send(req)
+}
+
+// send marshals the request and performs it with retries
+func (c *Client) send(req ChatCompletionRequest) (Completion, error) {
+	// The mock provider answers locally
+	if IsMockURL(c.config.APIURL) {
+		completion := mockCompletion(req.Messages)
+		if c.trace != nil {
+			// Trace the reply in the chat completions shape a provider would send
+			var choice ChatChoice
+			choice.Message.Role, choice.Message.Content = "assistant", completion.Content
+			choice.FinishReason = completion.FinishReason
+			body, _ := json.Marshal(req)
+			reply, _ := json.Marshal(ChatCompletionResponse{Choices: []ChatChoice{choice}})
+			c.trace.record(nil, body, 0, reply, completion, nil, time.Now())
+		}
+		return completion, nil
+	}
+
+	body, err := c.marshalRequest(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return Completion{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Retry transient failures with jittered exponential backoff
+	retries := c.config.Retries
+	if retries < 0 {
+		retries = 0
 	}
 
-	// Retry logic (up to 3 attempts with exponential backoff)
 	var lastErr error
-	for attempt := 0; attempt < 3; attempt++ {
+	for attempt := 0; attempt <= retries; attempt++ {
 		if attempt > 0 {
-			backoff := time.Duration(attempt*attempt) * time.Second
-			time.Sleep(backoff)
+			c.sleep(retryWait(lastErr, attempt))
 		}
 
-		response, err := c.makeRequest(body)
+		completion, err := c.makeRequest(body)
 		if err == nil {
-			return response, nil
+			return completion, nil
 		}
 		lastErr = err
+
+		if !isRetryable(err) {
+			return Completion{}, err
+		}
+	}
+
+	return Completion{}, fmt.Errorf("failed after %d attempts: %w", retries+1, lastErr)
+}
+
+// retryWait returns how long to wait before retrying after err: the
+// provider's Retry-After if it sent one (capped at MaxRetryAfter), otherwise
+// the backoff for the attempt
+func retryWait(err error, attempt int) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return min(statusErr.RetryAfter, MaxRetryAfter)
 	}
+	return backoff(attempt)
+}
+
+// backoff returns the wait before the given retry: attempt² seconds,
+// randomized by ±50% so concurrent processes don't retry in lockstep
+func backoff(attempt int) time.Duration {
+	base := time.Duration(attempt*attempt) * time.Second
+	return time.Duration(float64(base) * (0.5 + rand.Float64()))
+}
 
-	return "", fmt.Errorf("failed after 3 attempts: %w", lastErr)
+// buildRequest creates the request body for the configured model
+func (c *Client) buildRequest(messages []ChatMessage) ChatCompletionRequest {
+	return c.buildRequestFor(c.config.Model, messages)
+}
+
+// buildRequestFor creates the request body for model, omitting parameters it rejects.
+// Reasoning models don't accept temperature/top_p and use max_completion_tokens.
+func (c *Client) buildRequestFor(model string, messages []ChatMessage) ChatCompletionRequest {
+	req := ChatCompletionRequest{
+		Model:    model,
+		Messages: messages,
+		Seed:     c.config.Seed,
+	}
+
+	if IsReasoningModel(model) {
+		req.MaxCompletionTokens = c.config.MaxTokens
+		return req
+	}
+
+	req.Temperature = c.config.Temperature
+	req.TopP = c.config.TopP
+	req.MaxTokens = c.config.MaxTokens
+	return req
 }
 
 // makeRequest performs the HTTP request
-func (c *Client) makeRequest(body []byte) (string, error) {
+func (c *Client) makeRequest(body []byte) (completion Completion, err error) {
 	httpReq, err := http.NewRequest("POST", c.config.APIURL, bytes.NewReader(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return Completion{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	var status int
+	var respBody []byte
+	if c.trace != nil {
+		start := time.Now()
+		defer func() {
+			c.trace.record(httpReq, body, status, respBody, completion, err, start)
+		}()
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", c.userAgent())
 
 	// Set authentication based on API provider
 	if c.isClaudeAPI() {
@@ -94,29 +250,69 @@ func (c *Client) makeRequest(body []byte) (string, error) {
 
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+		return Completion{}, &retryableError{fmt.Errorf("request failed: %w: %w", ErrNetwork, err)}
 	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
 
-	respBody, err := io.ReadAll(resp.Body)
+	respBody, err = io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
+		return Completion{}, &retryableError{fmt.Errorf("failed to read response: %w: %w", ErrNetwork, err)}
 	}
 
 	var chatResp ChatCompletionResponse
-	if err := json.Unmarshal(respBody, &chatResp); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
-	}
+	parseErr := c.unmarshalResponse(respBody, &chatResp)
 
 	// Check for API errors
+	if isRetryableStatus(resp.StatusCode) {
+		err := fmt.Errorf("API returned status %d", resp.StatusCode)
+		if parseErr == nil && chatResp.Error != nil {
+			err = fmt.Errorf("API error (status %d): %s", resp.StatusCode, chatResp.Error.Message)
+		}
+		return Completion{}, &retryableError{&StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			err:        err,
+		}}
+	}
+	if parseErr != nil {
+		if resp.StatusCode >= 400 {
+			return Completion{}, &StatusError{StatusCode: resp.StatusCode, err: fmt.Errorf("API returned status %d", resp.StatusCode)}
+		}
+		return Completion{}, fmt.Errorf("failed to parse response: %w: %w", ErrInvalidResponse, parseErr)
+	}
 	if chatResp.Error != nil {
-		return "", fmt.Errorf("API error: %s", chatResp.Error.Message)
+		err := fmt.Errorf("API error: %s", chatResp.Error.Message)
+		if isContextLengthError(chatResp.Error) {
+			err = fmt.Errorf("%w: %s", ErrContextLengthExceeded, chatResp.Error.Message)
+		}
+		if resp.StatusCode >= 400 {
+			return Completion{}, &StatusError{StatusCode: resp.StatusCode, err: err}
+		}
+		return Completion{}, err
 	}
 
 	// Check for valid response
 	if len(chatResp.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned")
+		return Completion{}, fmt.Errorf("%w: no response choices returned", ErrInvalidResponse)
 	}
 
-	return chatResp.Choices[0].Message.Content, nil
+	choices := make([]Completion, len(chatResp.Choices))
+	for i, choice := range chatResp.Choices {
+		choices[i] = Completion{
+			Content:      choice.Message.Content,
+			FinishReason: choice.FinishReason,
+			ToolCalls:    choice.Message.ToolCalls,
+		}
+	}
+
+	completion = choices[0]
+	if len(choices) > 1 {
+		completion.Choices = choices
+	}
+	if chatResp.Usage != nil {
+		completion.Usage = *chatResp.Usage
+	}
+	completion.SystemFingerprint = chatResp.SystemFingerprint
+	return completion, nil
 }
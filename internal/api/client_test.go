@@ -1,7 +1,16 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/raitses/ask/internal/config"
 )
@@ -31,3 +40,783 @@ func TestIsClaudeAPI(t *testing.T) {
 		})
 	}
 }
+
+func TestUncachedPromptTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		apiURL string
+		usage  Usage
+		want   int
+	}{
+		{"OpenAI counts cache reads in prompt tokens", "https://api.openai.com/v1/chat/completions", Usage{PromptTokens: 5200, CacheReadInputTokens: 4100}, 1100},
+		{"Claude reports cache tokens separately", "https://api.anthropic.com/v1/messages", Usage{PromptTokens: 40, CacheCreationInputTokens: 3900}, 40},
+		{"Claude with a prompt larger than the cache", "https://api.anthropic.com/v1/messages", Usage{PromptTokens: 5000, CacheReadInputTokens: 3000}, 5000},
+		{"No cache activity", "http://localhost:8080/v1/chat", Usage{PromptTokens: 1200}, 1200},
+	}
+
+	for _, tt := range tests {
+		client := NewClient(&config.Config{APIURL: tt.apiURL})
+		if got := client.UncachedPromptTokens(tt.usage); got != tt.want {
+			t.Errorf("%s: UncachedPromptTokens() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompleteFinishReason(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantReason    string
+		wantTruncated bool
+	}{
+		{"stop", `{"choices":[{"message":{"role":"assistant","content":"Done"},"finish_reason":"stop"}]}`, "stop", false},
+		{"length", `{"choices":[{"message":{"role":"assistant","content":"Partial"},"finish_reason":"length"}]}`, "length", true},
+		{"not reported", `{"choices":[{"message":{"role":"assistant","content":"Done"}}]}`, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewClient(&config.Config{APIURL: server.URL})
+			completion, err := client.Complete([]ChatMessage{{Role: "user", Content: "Hi"}})
+			if err != nil {
+				t.Fatalf("Complete() failed: %v", err)
+			}
+
+			if completion.FinishReason != tt.wantReason {
+				t.Errorf("FinishReason = %q, want %q", completion.FinishReason, tt.wantReason)
+			}
+			if completion.Truncated() != tt.wantTruncated {
+				t.Errorf("Truncated() = %v, want %v", completion.Truncated(), tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestResponsesAPI(t *testing.T) {
+	var sent map[string]any
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		_ = json.NewDecoder(req.Body).Decode(&sent)
+		return jsonResponse(http.StatusOK, `{"status":"completed","output":[`+
+			`{"type":"reasoning","content":[]},`+
+			`{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Hello "},{"type":"output_text","text":"there"}]}],`+
+			`"usage":{"input_tokens":120,"output_tokens":8,"total_tokens":128,"input_tokens_details":{"cached_tokens":100}}}`), nil
+	})
+
+	cfg := &config.Config{APIURL: "https://api.openai.com/v1/responses", Model: "gpt-4o", MaxTokens: 500}
+	client := NewClientWithTransport(cfg, transport)
+	completion, err := client.Complete([]ChatMessage{
+		{Role: "system", Content: "Be brief"},
+		{Role: "user", Parts: []ContentPart{TextPart("What is this?"), ImagePart("image/png", []byte("png"))}},
+	})
+	if err != nil {
+		t.Fatalf("Complete() failed: %v", err)
+	}
+
+	if completion.Content != "Hello there" || completion.FinishReason != "stop" {
+		t.Errorf("Completion = %q (%s), want the joined output text", completion.Content, completion.FinishReason)
+	}
+	if completion.Usage.PromptTokens != 120 || completion.Usage.CompletionTokens != 8 || completion.Usage.CacheReadInputTokens != 100 {
+		t.Errorf("Usage = %+v, want input/output/cached tokens mapped", completion.Usage)
+	}
+
+	body, _ := json.Marshal(sent)
+	want := `{"input":[{"content":"Be brief","role":"system"},` +
+		`{"content":[{"text":"What is this?","type":"input_text"},{"image_url":"data:image/png;base64,cG5n","type":"input_image"}],"role":"user"}],` +
+		`"max_output_tokens":500,"model":"gpt-4o","store":false}`
+	if string(body) != want {
+		t.Errorf("Request body =\n%s\nwant\n%s", body, want)
+	}
+}
+
+func TestResponsesAPIToolsAndTruncation(t *testing.T) {
+	var sent responsesRequest
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		_ = json.NewDecoder(req.Body).Decode(&sent)
+		return jsonResponse(http.StatusOK, `{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},"output":[`+
+			`{"type":"function_call","call_id":"call_2","name":"run_command","arguments":"{\"command\":\"ls\"}"}]}`), nil
+	})
+
+	// Detected from ASK_API_PROVIDER rather than the URL
+	cfg := &config.Config{APIURL: "https://gateway.example.com/openai", APIProvider: config.ProviderOpenAIResponses}
+	client := NewClientWithTransport(cfg, transport)
+	completion, err := client.CompleteWithTools([]ChatMessage{
+		{Role: "user", Content: "List files"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "run_command", Arguments: `{"command":"pwd"}`}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: "/tmp"},
+	}, []Tool{{Type: "function", Function: ToolFunction{Name: "run_command", Description: "Run a command"}}})
+	if err != nil {
+		t.Fatalf("CompleteWithTools() failed: %v", err)
+	}
+
+	if len(sent.Input) != 3 || sent.Input[1].Type != "function_call" || sent.Input[2].Type != "function_call_output" || sent.Input[2].CallID != "call_1" {
+		t.Errorf("Input = %+v, want message, function_call, function_call_output", sent.Input)
+	}
+	if len(sent.Tools) != 1 || sent.Tools[0].Name != "run_command" {
+		t.Errorf("Tools = %+v, want the flattened run_command tool", sent.Tools)
+	}
+	if len(completion.ToolCalls) != 1 || completion.ToolCalls[0].ID != "call_2" || completion.ToolCalls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("ToolCalls = %+v, want the model's function_call", completion.ToolCalls)
+	}
+	if !completion.Truncated() {
+		t.Errorf("FinishReason = %q, want length for max_output_tokens", completion.FinishReason)
+	}
+}
+
+func TestCompleteChoices(t *testing.T) {
+	const body = `{"choices":[{"message":{"content":"One"},"finish_reason":"stop"},{"message":{"content":"Two"},"finish_reason":"length"}]}`
+
+	tests := []struct {
+		name   string
+		apiURL string
+		wantN  int
+	}{
+		{"OpenAI sends n", "https://api.openai.com/v1/chat/completions", 2},
+		{"Claude omits n", "https://api.anthropic.com/v1/messages", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent ChatCompletionRequest
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				_ = json.NewDecoder(req.Body).Decode(&sent)
+				return jsonResponse(http.StatusOK, body), nil
+			})
+
+			client := NewClientWithTransport(&config.Config{APIURL: tt.apiURL}, transport)
+			completion, err := client.CompleteChoices([]ChatMessage{{Role: "user", Content: "Ideas?"}}, 2)
+			if err != nil {
+				t.Fatalf("CompleteChoices() failed: %v", err)
+			}
+
+			if sent.N != tt.wantN {
+				t.Errorf("Sent n = %d, want %d", sent.N, tt.wantN)
+			}
+			if completion.Content != "One" || len(completion.Choices) != 2 {
+				t.Fatalf("Got content %q with %d choices, want the first of 2", completion.Content, len(completion.Choices))
+			}
+			if second := completion.Choices[1]; second.Content != "Two" || !second.Truncated() {
+				t.Errorf("Second choice = %+v, want truncated \"Two\"", second)
+			}
+		})
+	}
+}
+
+func TestInstructionRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		apiURL   string
+		override string
+		want     string
+	}{
+		{"GPT-4o", "gpt-4o", "https://api.openai.com/v1/chat/completions", "", "system"},
+		{"o1", "o1", "https://api.openai.com/v1/chat/completions", "", "developer"},
+		{"o3-mini", "o3-mini", "https://api.openai.com/v1/chat/completions", "", "developer"},
+		{"gpt-4.1", "gpt-4.1-mini", "https://api.openai.com/v1/chat/completions", "", "developer"},
+		{"Provider prefix", "openai/o4-mini", "https://openrouter.ai/api/v1/chat/completions", "", "developer"},
+		{"Claude", "claude-3-5-sonnet-20241022", "https://api.anthropic.com/v1/messages", "", "system"},
+		{"Override", "o3", "https://api.openai.com/v1/chat/completions", "system", "system"},
+		{"Not an o-series model", "omni-local", "http://localhost:8080/v1/chat", "", "system"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(&config.Config{
+				Model:           tt.model,
+				APIURL:          tt.apiURL,
+				InstructionRole: tt.override,
+			})
+
+			if got := client.InstructionRole(); got != tt.want {
+				t.Errorf("InstructionRole() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSystemFingerprint(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, `{"choices":[{"message":{"content":"Hi"}}],"system_fingerprint":"fp_44709d6fcb"}`), nil
+	})
+
+	client := NewClientWithTransport(&config.Config{APIURL: "https://api.openai.com/v1/chat/completions"}, transport)
+	completion, err := client.Complete([]ChatMessage{{Role: "user", Content: "Hi"}})
+	if err != nil {
+		t.Fatalf("Complete() failed: %v", err)
+	}
+	if completion.SystemFingerprint != "fp_44709d6fcb" {
+		t.Errorf("SystemFingerprint = %q, want fp_44709d6fcb", completion.SystemFingerprint)
+	}
+}
+
+func TestBuildRequestParameters(t *testing.T) {
+	temperature := 0.2
+	topP := 0.9
+	seed := 42
+
+	tests := []struct {
+		name     string
+		model    string
+		wantJSON string
+	}{
+		{
+			name:     "standard model keeps sampling params",
+			model:    "gpt-4o",
+			wantJSON: `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}],"temperature":0.2,"top_p":0.9,"max_tokens":1000,"seed":42}`,
+		},
+		{
+			name:     "reasoning model strips sampling params",
+			model:    "o3-mini",
+			wantJSON: `{"model":"o3-mini","messages":[{"role":"user","content":"Hi"}],"max_completion_tokens":1000,"seed":42}`,
+		},
+		{
+			name:     "gpt-5 treated as reasoning model",
+			model:    "gpt-5",
+			wantJSON: `{"model":"gpt-5","messages":[{"role":"user","content":"Hi"}],"max_completion_tokens":1000,"seed":42}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient(&config.Config{
+				Model:       tt.model,
+				Temperature: &temperature,
+				TopP:        &topP,
+				MaxTokens:   1000,
+				Seed:        &seed,
+			})
+
+			body, err := json.Marshal(client.buildRequest([]ChatMessage{{Role: "user", Content: "Hi"}}))
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+
+			if string(body) != tt.wantJSON {
+				t.Errorf("Request body mismatch:\ngot:  %s\nwant: %s", body, tt.wantJSON)
+			}
+		})
+	}
+
+	// Unset parameters are omitted entirely
+	client := NewClient(&config.Config{Model: "gpt-4o"})
+	body, _ := json.Marshal(client.buildRequest(nil))
+	if string(body) != `{"model":"gpt-4o","messages":null}` {
+		t.Errorf("Request with defaults = %s, want only model and messages", body)
+	}
+}
+
+func TestRunCommandArgs(t *testing.T) {
+	tests := []struct {
+		arguments string
+		want      string
+		wantErr   bool
+	}{
+		{`{"command":"ls -la"}`, "ls -la", false},
+		{`{"command":""}`, "", true},
+		{`not json`, "", true},
+	}
+
+	for _, tt := range tests {
+		call := ToolCall{Function: ToolCallFunction{Name: ToolRunCommand, Arguments: tt.arguments}}
+		got, err := RunCommandArgs(call)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("RunCommandArgs(%s) error = %v, wantErr %v", tt.arguments, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("RunCommandArgs(%s) = %q, want %q", tt.arguments, got, tt.want)
+		}
+	}
+}
+
+// roundTripFunc adapts a function to http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// jsonResponse builds a response with the given status and body
+func jsonResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestCompleteRetries(t *testing.T) {
+	const success = `{"choices":[{"message":{"role":"assistant","content":"Done"},"finish_reason":"stop"}]}`
+
+	tests := []struct {
+		name         string
+		retries      int
+		failures     int
+		failure      func() (*http.Response, error)
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"network errors then success", 2, 2, func() (*http.Response, error) { return nil, errors.New("connection reset") }, 3, false},
+		{"rate limited then success", 2, 1, func() (*http.Response, error) {
+			return jsonResponse(429, `{"error":{"message":"Rate limit exceeded"}}`), nil
+		}, 2, false},
+		{"server errors exhaust retries", 1, 5, func() (*http.Response, error) { return jsonResponse(503, "unavailable"), nil }, 2, true},
+		{"client errors not retried", 2, 5, func() (*http.Response, error) {
+			return jsonResponse(400, `{"error":{"message":"Invalid model"}}`), nil
+		}, 1, true},
+		{"zero retries", 0, 1, func() (*http.Response, error) { return nil, errors.New("timeout") }, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			cfg := &config.Config{APIURL: "https://api.example.com/v1/chat", Retries: tt.retries}
+			client := NewClientWithTransport(cfg, roundTripFunc(func(*http.Request) (*http.Response, error) {
+				attempts++
+				if attempts <= tt.failures {
+					return tt.failure()
+				}
+				return jsonResponse(200, success), nil
+			}))
+
+			var waits []time.Duration
+			client.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+			completion, err := client.Complete([]ChatMessage{{Role: "user", Content: "Hi"}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Complete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && completion.Content != "Done" {
+				t.Errorf("Content = %q, want Done", completion.Content)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Made %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if len(waits) != attempts-1 {
+				t.Errorf("Waited %d times, want %d", len(waits), attempts-1)
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	for attempt := 1; attempt <= 3; attempt++ {
+		base := time.Duration(attempt*attempt) * time.Second
+		for i := 0; i < 20; i++ {
+			if d := backoff(attempt); d < base/2 || d >= base*3/2 {
+				t.Errorf("backoff(%d) = %v, want within ±50%% of %v", attempt, d, base)
+			}
+		}
+	}
+}
+
+func TestMockProvider(t *testing.T) {
+	// Any network access fails the test
+	cfg := &config.Config{APIURL: "mock://echo", Retries: 2}
+	client := NewClientWithTransport(cfg, roundTripFunc(func(*http.Request) (*http.Response, error) {
+		t.Fatal("Mock provider made a network request")
+		return nil, nil
+	}))
+
+	completion, err := client.Complete([]ChatMessage{
+		{Role: "system", Content: "Be concise"},
+		{Role: "user", Content: "First question"},
+		{Role: "assistant", Content: "First answer"},
+		{Role: "user", Content: "How do I run tests?"},
+	})
+	if err != nil {
+		t.Fatalf("Complete() failed: %v", err)
+	}
+
+	want := "[mock] You asked: How do I run tests?\n(4 messages in request)"
+	if completion.Content != want {
+		t.Errorf("Content = %q, want %q", completion.Content, want)
+	}
+	if completion.FinishReason != "stop" {
+		t.Errorf("FinishReason = %q, want stop", completion.FinishReason)
+	}
+}
+
+func TestContextLengthError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"OpenAI code", `{"error":{"message":"Too long","code":"context_length_exceeded"}}`, true},
+		{"OpenAI message", `{"error":{"message":"This model's maximum context length is 8192 tokens"}}`, true},
+		{"Claude message", `{"error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`, true},
+		{"Other error", `{"error":{"message":"Invalid API key","code":"invalid_api_key"}}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClientWithTransport(&config.Config{APIURL: "https://api.example.com/v1/chat"},
+				roundTripFunc(func(*http.Request) (*http.Response, error) {
+					return jsonResponse(400, tt.body), nil
+				}))
+
+			_, err := client.Complete([]ChatMessage{{Role: "user", Content: "Hi"}})
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if got := errors.Is(err, ErrContextLengthExceeded); got != tt.want {
+				t.Errorf("errors.Is(%v, ErrContextLengthExceeded) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4o", 128000},
+		{"gpt-4o-mini", 128000},
+		{"gpt-4", 8192},
+		{"gpt-4-0613", 8192},
+		{"gpt-4-32k-0613", 32768},
+		{"gpt-4-turbo", 128000},
+		{"gpt-4-1106-preview", 128000},
+		{"gpt-4.5-preview", 128000},
+		{"gpt-4.5-preview-2025-02-27", 128000},
+		{"gpt-4.1-mini", 1047576},
+		{"openai/o1-mini", 128000},
+		{"o3", 200000},
+		{"o3-mini", 200000},
+		{"o30", 0},
+		{"claude-3-5-sonnet-20241022", 200000},
+		{"llama3:8b", 0},
+	}
+
+	for _, tt := range tests {
+		if got := ContextWindow(tt.model); got != tt.want {
+			t.Errorf("ContextWindow(%q) = %d, want %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		apiURL  string
+		wantErr bool
+	}{
+		{"running local server", server.URL + "/v1/chat/completions", false},
+		{"dead local port", "http://127.0.0.1:1/v1/chat/completions", true},
+		{"remote host not probed", "https://api.example.com/v1/chat/completions", false},
+		{"mock provider not probed", "mock://echo", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewClient(&config.Config{APIURL: tt.apiURL}).Probe()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && (!errors.Is(err, ErrNetwork) || !strings.Contains(err.Error(), "is your local server running?")) {
+				t.Errorf("Probe() error = %v, want a network error naming the local server", err)
+			}
+		})
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiURL  string
+		respond func(w http.ResponseWriter)
+		want    string
+	}{
+		{"bad key", "", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"message":"Incorrect API key provided"}}`))
+		}, "bad API key"},
+		{"not found", "", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<html>Not Found</html>`))
+		}, "bad URL or model"},
+		{"bad request", "", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"invalid model"}}`))
+		}, "request rejected"},
+		{"server error", "", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, "provider error"},
+		{"not a URL", "localhost:8080/v1", nil, "bad URL"},
+		{"connection refused", "http://127.0.0.1:1/v1/chat", nil, "network error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiURL := tt.apiURL
+			if tt.respond != nil {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					tt.respond(w)
+				}))
+				defer server.Close()
+				apiURL = server.URL
+			}
+
+			client := NewClient(&config.Config{APIURL: apiURL})
+			_, _, err := client.Ping()
+			if err == nil {
+				t.Fatal("Ping() should fail")
+			}
+			if got := Diagnose(err); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Diagnose(%v) = %q, want prefix %q", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		respond        func() (*http.Response, error)
+		want           error
+		wantRetryAfter time.Duration
+	}{
+		{"auth", func() (*http.Response, error) {
+			return jsonResponse(401, `{"error":{"message":"Incorrect API key provided"}}`), nil
+		}, ErrAuth, 0},
+		{"rate limited", func() (*http.Response, error) {
+			resp := jsonResponse(429, `{"error":{"message":"Rate limit exceeded"}}`)
+			resp.Header.Set("Retry-After", "7")
+			return resp, nil
+		}, ErrRateLimited, 7 * time.Second},
+		{"server", func() (*http.Response, error) { return jsonResponse(502, "bad gateway"), nil }, ErrServer, 0},
+		{"context length", func() (*http.Response, error) {
+			return jsonResponse(400, `{"error":{"message":"Too long","code":"context_length_exceeded"}}`), nil
+		}, ErrContextLengthExceeded, 0},
+		{"network", func() (*http.Response, error) { return nil, errors.New("connection reset") }, ErrNetwork, 0},
+		{"invalid response", func() (*http.Response, error) { return jsonResponse(200, "<html>"), nil }, ErrInvalidResponse, 0},
+	}
+
+	sentinels := []error{ErrAuth, ErrRateLimited, ErrServer, ErrContextLengthExceeded, ErrNetwork, ErrInvalidResponse}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{APIURL: "https://api.example.com/v1/chat"}
+			client := NewClientWithTransport(cfg, roundTripFunc(func(*http.Request) (*http.Response, error) {
+				return tt.respond()
+			}))
+
+			_, err := client.Complete([]ChatMessage{{Role: "user", Content: "Hi"}})
+			for _, sentinel := range sentinels {
+				if got := errors.Is(err, sentinel); got != (sentinel == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, sentinel, got)
+				}
+			}
+
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("RetryAfter = %v, want %v", statusErr.RetryAfter, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	attempts := 0
+	cfg := &config.Config{APIURL: "https://api.example.com/v1/chat", Retries: 2}
+	retryAfter := []string{"3", "600"} // The second is capped at MaxRetryAfter
+	client := NewClientWithTransport(cfg, roundTripFunc(func(*http.Request) (*http.Response, error) {
+		attempts++
+		if attempts > len(retryAfter) {
+			return jsonResponse(200, `{"choices":[{"message":{"content":"Done"}}]}`), nil
+		}
+		resp := jsonResponse(429, `{"error":{"message":"Rate limit exceeded"}}`)
+		resp.Header.Set("Retry-After", retryAfter[attempts-1])
+		return resp, nil
+	}))
+
+	var waits []time.Duration
+	client.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	if _, err := client.Complete([]ChatMessage{{Role: "user", Content: "Hi"}}); err != nil {
+		t.Fatalf("Complete() failed: %v", err)
+	}
+	want := []time.Duration{3 * time.Second, MaxRetryAfter}
+	if !slices.Equal(waits, want) {
+		t.Errorf("Waits = %v, want %v", waits, want)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-5", 0},
+		{"Wed, 01 May 2024 12:00:45 GMT", 45 * time.Second},
+		{"Wed, 01 May 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	var sent EmbeddingsRequest
+	cfg := &config.Config{APIKey: "test", APIURL: "https://api.example.com/v1/chat/completions", EmbeddingsURL: "https://api.example.com/v1/embeddings", EmbeddingsModel: "text-embedding-3-small"}
+	client := NewClientWithTransport(cfg, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		_ = json.NewDecoder(req.Body).Decode(&sent)
+		// Out of order, as providers may return them
+		return jsonResponse(200, `{"data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}]}`), nil
+	}))
+
+	vectors, err := client.Embed([]string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+	if sent.Model != "text-embedding-3-small" || len(sent.Input) != 2 {
+		t.Errorf("Unexpected request: %+v", sent)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("Embeddings should follow the input order, got %v", vectors)
+	}
+
+	// Missing embeddings and HTTP failures are errors
+	client = NewClientWithTransport(cfg, roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return jsonResponse(200, `{"data":[{"index":0,"embedding":[1,0]}]}`), nil
+	}))
+	if _, err := client.Embed([]string{"first", "second"}); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("Expected ErrInvalidResponse for a missing embedding, got %v", err)
+	}
+	client = NewClientWithTransport(cfg, roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return jsonResponse(401, `{"error":{"message":"Incorrect API key provided"}}`), nil
+	}))
+	if _, err := client.Embed([]string{"first"}); !errors.Is(err, ErrAuth) {
+		t.Errorf("Expected ErrAuth, got %v", err)
+	}
+}
+
+func TestEmbedAuthorization(t *testing.T) {
+	tests := []struct {
+		name          string
+		embeddingsURL string
+		embeddingsKey string
+		want          string
+	}{
+		{"same host", "https://api.example.com/v1/embeddings", "", "Bearer chat-key"},
+		{"other host", "https://embed.example.net/v1/embeddings", "", ""},
+		{"other host with embeddings key", "https://embed.example.net/v1/embeddings", "embed-key", "Bearer embed-key"},
+		{"same host with embeddings key", "https://api.example.com/v1/embeddings", "embed-key", "Bearer embed-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				APIKey:           "chat-key",
+				APIURL:           "https://api.example.com/v1/chat/completions",
+				EmbeddingsURL:    tt.embeddingsURL,
+				EmbeddingsAPIKey: tt.embeddingsKey,
+			}
+			var got string
+			client := NewClientWithTransport(cfg, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				got = req.Header.Get("Authorization")
+				return jsonResponse(200, `{"data":[{"index":0,"embedding":[1,0]}]}`), nil
+			}))
+			if _, err := client.Embed([]string{"first"}); err != nil {
+				t.Fatalf("Embed failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	tests := []struct {
+		configured string
+		want       string
+	}{
+		{"", "ask"},
+		{"ask/1.4.0 (commit 3f2a1bc; linux/amd64)", "ask/1.4.0 (commit 3f2a1bc; linux/amd64)"},
+	}
+
+	for _, tt := range tests {
+		var got string
+		cfg := &config.Config{APIURL: "https://api.example.com/v1/chat", UserAgent: tt.configured}
+		client := NewClientWithTransport(cfg, roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			got = req.Header.Get("User-Agent")
+			return jsonResponse(200, `{"choices":[{"message":{"content":"OK"}}]}`), nil
+		}))
+
+		if _, err := client.ChatCompletion([]ChatMessage{{Role: "user", Content: "Hi"}}); err != nil {
+			t.Fatalf("ChatCompletion failed: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("User-Agent = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	path := t.TempDir() + "/trace.json"
+	cfg := &config.Config{APIURL: "https://api.openai.com/v1/chat/completions", APIKey: "sk-secret-test-key", Model: "gpt-4o", TraceFile: path}
+
+	attempts := 0
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return jsonResponse(http.StatusServiceUnavailable, "<html>overloaded</html>"), nil
+		}
+		return jsonResponse(http.StatusOK, `{"choices":[{"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":1,"total_tokens":13}}`), nil
+	})
+	client := NewClientWithTransport(cfg, transport)
+	client.sleep = func(time.Duration) {}
+	cfg.Retries = 1
+
+	if _, err := client.Complete([]ChatMessage{{Role: "user", Content: "Hello"}}); err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("trace file not written: %v", err)
+	}
+	if strings.Contains(string(data), "sk-secret-test-key") {
+		t.Error("trace should not contain the API key")
+	}
+
+	var trace Trace
+	if err := json.Unmarshal(data, &trace); err != nil {
+		t.Fatalf("trace isn't valid JSON: %v", err)
+	}
+	if len(trace.Exchanges) != 2 {
+		t.Fatalf("got %d exchanges, want the failed attempt and the retry", len(trace.Exchanges))
+	}
+
+	failed, ok := trace.Exchanges[0], trace.Exchanges[1]
+	if failed.Status != http.StatusServiceUnavailable || failed.ResponseText != "<html>overloaded</html>" || failed.Error == "" {
+		t.Errorf("failed exchange = %+v, want status 503 with the raw body and error", failed)
+	}
+	if ok.Headers["Authorization"] != "Bearer [REDACTED]" {
+		t.Errorf("Authorization header = %q, want it redacted", ok.Headers["Authorization"])
+	}
+	if !strings.Contains(string(ok.Request), `"Hello"`) || !strings.Contains(string(ok.Response), `"Hi"`) {
+		t.Errorf("exchange should hold the request and raw response, got %s / %s", ok.Request, ok.Response)
+	}
+	if ok.Usage == nil || ok.Usage.TotalTokens != 13 {
+		t.Errorf("Usage = %+v, want 13 total tokens", ok.Usage)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// EmbeddingsRequest is the body of an OpenAI-compatible embeddings request
+type EmbeddingsRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingsResponse is the response from an embeddings endpoint
+type EmbeddingsResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Error *APIError `json:"error,omitempty"`
+}
+
+// Embed returns an embedding vector for each text from the configured
+// embeddings endpoint (ASK_EMBEDDINGS_URL), in the same order as texts
+func (c *Client) Embed(texts []string) ([][]float64, error) {
+	if c.config.EmbeddingsURL == "" {
+		return nil, fmt.Errorf("no embeddings endpoint configured (ASK_EMBEDDINGS_URL)")
+	}
+
+	body, err := json.Marshal(EmbeddingsRequest{Model: c.config.EmbeddingsModel, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", c.config.EmbeddingsURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", c.userAgent())
+	if key := c.embeddingsKey(); key != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w: %w", ErrNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w: %w", ErrNetwork, err)
+	}
+
+	var embResp EmbeddingsResponse
+	parseErr := json.Unmarshal(respBody, &embResp)
+	if resp.StatusCode >= 400 {
+		err := fmt.Errorf("API returned status %d", resp.StatusCode)
+		if parseErr == nil && embResp.Error != nil {
+			err = fmt.Errorf("API error (status %d): %s", resp.StatusCode, embResp.Error.Message)
+		}
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+			err:        err,
+		}
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("failed to parse response: %w: %w", ErrInvalidResponse, parseErr)
+	}
+
+	embeddings := make([][]float64, len(texts))
+	for _, d := range embResp.Data {
+		if d.Index < 0 || d.Index >= len(texts) {
+			return nil, fmt.Errorf("%w: embedding index %d out of range", ErrInvalidResponse, d.Index)
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	for i, e := range embeddings {
+		if len(e) == 0 {
+			return nil, fmt.Errorf("%w: no embedding returned for input %d", ErrInvalidResponse, i)
+		}
+	}
+	return embeddings, nil
+}
+
+// embeddingsKey returns the key to authenticate embeddings requests with:
+// ASK_EMBEDDINGS_API_KEY if set, otherwise the chat API key when the
+// embeddings endpoint is on the chat endpoint's host. The chat key is
+// never sent to another host.
+func (c *Client) embeddingsKey() string {
+	if c.config.EmbeddingsAPIKey != "" {
+		return c.config.EmbeddingsAPIKey
+	}
+	embeddings, err := url.Parse(c.config.EmbeddingsURL)
+	if err != nil {
+		return ""
+	}
+	chat, err := url.Parse(c.config.APIURL)
+	if err != nil || chat.Host == "" || !strings.EqualFold(chat.Host, embeddings.Host) {
+		return ""
+	}
+	return c.config.APIKey
+}
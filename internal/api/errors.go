@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Request failures can be told apart with errors.Is. A *StatusError (via
+// errors.As) also carries the HTTP status and any Retry-After the provider
+// sent. ErrContextLengthExceeded marks prompts that were too long.
+var (
+	// ErrAuth means the provider rejected the API key (401/403)
+	ErrAuth = errors.New("authentication failed")
+
+	// ErrRateLimited means the provider is throttling requests or the quota
+	// is used up (429)
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrServer means the provider failed to handle the request (5xx)
+	ErrServer = errors.New("provider error")
+
+	// ErrNetwork means the API could not be reached or the response was cut off
+	ErrNetwork = errors.New("network error")
+
+	// ErrInvalidResponse means the API answered with something other than a
+	// chat completion
+	ErrInvalidResponse = errors.New("invalid response")
+)
+
+// MaxRetryAfter caps how long a Retry-After header can make a retry wait
+const MaxRetryAfter = 60 * time.Second
+
+// StatusError is a failed request with the HTTP status the API returned
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // Set from the Retry-After header on 429 and 5xx responses
+	err        error
+}
+
+func (e *StatusError) Error() string { return e.err.Error() }
+func (e *StatusError) Unwrap() error { return e.err }
+
+// Is matches the sentinel error for the status code
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrAuth:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// retryableError marks a failure worth retrying (network errors, 429, 5xx)
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// isRetryable reports whether a request error is transient
+func isRetryable(err error) bool {
+	var retryable *retryableError
+	return errors.As(err, &retryable)
+}
+
+// isRetryableStatus reports whether an HTTP status indicates a transient failure
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as
+// an HTTP date. It returns 0 if the header is missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MockScheme is the ASK_API_URL scheme of the offline mock provider,
+// e.g. mock://echo. It answers without any network access.
+const MockScheme = "mock://"
+
+// IsMockURL reports whether url selects the offline mock provider
+func IsMockURL(url string) bool {
+	return strings.HasPrefix(strings.ToLower(url), MockScheme)
+}
+
+// mockCompletion returns a deterministic reply echoing the last user message
+// and the number of messages sent
+func mockCompletion(messages []ChatMessage) Completion {
+	lastUser := ""
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			lastUser = messages[i].Content
+			break
+		}
+	}
+
+	return Completion{
+		Content:      fmt.Sprintf("[mock] You asked: %s\n(%d messages in request)", lastUser, len(messages)),
+		FinishReason: "stop",
+	}
+}
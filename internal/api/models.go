@@ -0,0 +1,103 @@
+package api
+
+import "strings"
+
+// Instruction roles for the system prompt
+const (
+	RoleSystem    = "system"
+	RoleDeveloper = "developer"
+)
+
+// IsInstructionRole reports whether role carries instructions rather than conversation
+func IsInstructionRole(role string) bool {
+	return role == RoleSystem || role == RoleDeveloper
+}
+
+// modelBaseName strips any provider prefix (e.g. "openai/o3-mini") and lowercases the name
+func modelBaseName(model string) string {
+	model = strings.ToLower(model)
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	return model
+}
+
+// hasModelPrefix reports whether name is prefix or one of its variants
+// ("gpt-4" matches "gpt-4-0613" but not "gpt-4o" or "gpt-4.5-preview")
+func hasModelPrefix(name, prefix string) bool {
+	return name == prefix || strings.HasPrefix(name, prefix+"-")
+}
+
+// IsReasoningModel reports whether model is an OpenAI reasoning model (o1, o3, o4, gpt-5)
+func IsReasoningModel(model string) bool {
+	name := modelBaseName(model)
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5"} {
+		if hasModelPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrefersDeveloperRole reports whether model expects instructions in the
+// "developer" role instead of "system" (reasoning models and gpt-4.1+)
+func PrefersDeveloperRole(model string) bool {
+	name := modelBaseName(model)
+	return IsReasoningModel(name) || hasModelPrefix(name, "gpt-4.1")
+}
+
+// contextWindows lists known context window sizes in tokens by model name
+// prefix, matched with hasModelPrefix. More specific prefixes come first.
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4.1", 1047576},
+	{"gpt-4.5", 128000},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-1106", 128000},
+	{"gpt-4-0125", 128000},
+	{"gpt-4-vision", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"gpt-5", 400000},
+	{"o1-mini", 128000},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+}
+
+// ContextWindow returns the model's context window in tokens, or 0 if unknown
+func ContextWindow(model string) int {
+	name := modelBaseName(model)
+	for _, w := range contextWindows {
+		if hasModelPrefix(name, w.prefix) {
+			return w.tokens
+		}
+	}
+	return 0
+}
+
+// textOnlyModels lists model name prefixes known not to accept images
+var textOnlyModels = []string{"gpt-3.5", "o1-mini", "o1-preview", "o3-mini", "claude-2", "claude-instant"}
+
+// SupportsVision reports whether model accepts image input. Unknown models
+// are assumed to, leaving the provider to reject them.
+func SupportsVision(model string) bool {
+	name := modelBaseName(model)
+	for _, prefix := range textOnlyModels {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+
+	// The original gpt-4 snapshots are text-only; gpt-4-turbo, gpt-4o,
+	// gpt-4.1, and the vision previews aren't
+	if name == "gpt-4" || strings.HasPrefix(name, "gpt-4-0") || strings.HasPrefix(name, "gpt-4-32k") {
+		return strings.Contains(name, "vision")
+	}
+	return true
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/raitses/ask/internal/config"
+)
+
+// responsesRequest is the request body of OpenAI's Responses API
+type responsesRequest struct {
+	Model           string          `json:"model"`
+	Input           []responsesItem `json:"input"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"top_p,omitempty"`
+	MaxOutputTokens int             `json:"max_output_tokens,omitempty"`
+	Tools           []responsesTool `json:"tools,omitempty"`
+	Store           bool            `json:"store"` // Always false: ask keeps its own context
+}
+
+// responsesItem is one input item: a message, a tool call the model made,
+// or a tool's output
+type responsesItem struct {
+	Type      string `json:"type,omitempty"` // "function_call" or "function_call_output"; empty for messages
+	Role      string `json:"role,omitempty"`
+	Content   any    `json:"content,omitempty"` // A string, or []responsesPart for images
+	CallID    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Output    string `json:"output,omitempty"`
+}
+
+// responsesPart is one part of a multimodal input message
+type responsesPart struct {
+	Type     string `json:"type"` // "input_text" or "input_image"
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+// responsesTool is a function tool in the Responses API's flattened form
+type responsesTool struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+// responsesResponse is the part of a Responses API reply ask reads
+type responsesResponse struct {
+	Status            string `json:"status"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Output []struct {
+		Type    string `json:"type"` // "message", "function_call", "reasoning", ...
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+		CallID    string `json:"call_id"`
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"output"`
+	Usage *struct {
+		InputTokens        int `json:"input_tokens"`
+		OutputTokens       int `json:"output_tokens"`
+		TotalTokens        int `json:"total_tokens"`
+		InputTokensDetails struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"input_tokens_details"`
+	} `json:"usage"`
+	Error *APIError `json:"error"`
+}
+
+// usesResponsesAPI reports whether requests go to OpenAI's Responses API,
+// chosen with ASK_API_PROVIDER or detected from a /responses endpoint
+func (c *Client) usesResponsesAPI() bool {
+	if c.config.APIProvider == config.ProviderOpenAIResponses {
+		return true
+	}
+	return strings.HasSuffix(strings.TrimRight(c.config.APIURL, "/"), "/responses")
+}
+
+// marshalRequest encodes req for the configured API
+func (c *Client) marshalRequest(req ChatCompletionRequest) ([]byte, error) {
+	if c.usesResponsesAPI() {
+		return json.Marshal(newResponsesRequest(req))
+	}
+	return json.Marshal(req)
+}
+
+// unmarshalResponse decodes a reply from the configured API into the chat
+// completions shape the rest of the client reads
+func (c *Client) unmarshalResponse(data []byte, chatResp *ChatCompletionResponse) error {
+	if !c.usesResponsesAPI() {
+		return json.Unmarshal(data, chatResp)
+	}
+
+	var resp responsesResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return err
+	}
+	*chatResp = resp.chatResponse()
+	return nil
+}
+
+// newResponsesRequest translates a chat completions request into the
+// Responses API's input items. Seed and n have no equivalent and are dropped.
+func newResponsesRequest(req ChatCompletionRequest) responsesRequest {
+	out := responsesRequest{
+		Model:           req.Model,
+		Temperature:     req.Temperature,
+		TopP:            req.TopP,
+		MaxOutputTokens: max(req.MaxTokens, req.MaxCompletionTokens),
+	}
+
+	for _, msg := range req.Messages {
+		switch {
+		case msg.Role == "tool":
+			out.Input = append(out.Input, responsesItem{Type: "function_call_output", CallID: msg.ToolCallID, Output: msg.Content})
+			continue
+		case len(msg.Parts) > 0:
+			parts := make([]responsesPart, len(msg.Parts))
+			for i, part := range msg.Parts {
+				if part.ImageURL != nil {
+					parts[i] = responsesPart{Type: "input_image", ImageURL: part.ImageURL.URL}
+				} else {
+					parts[i] = responsesPart{Type: "input_text", Text: part.Text}
+				}
+			}
+			out.Input = append(out.Input, responsesItem{Role: msg.Role, Content: parts})
+		case msg.Content != "":
+			out.Input = append(out.Input, responsesItem{Role: msg.Role, Content: msg.Content})
+		}
+
+		for _, call := range msg.ToolCalls {
+			out.Input = append(out.Input, responsesItem{
+				Type:      "function_call",
+				CallID:    call.ID,
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+			})
+		}
+	}
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, responsesTool{
+			Type:        "function",
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  tool.Function.Parameters,
+		})
+	}
+
+	return out
+}
+
+// chatResponse converts a Responses API reply to a single-choice chat
+// completions response, joining the text of its output messages
+func (r responsesResponse) chatResponse() ChatCompletionResponse {
+	chat := ChatCompletionResponse{Error: r.Error}
+	if r.Error != nil {
+		return chat
+	}
+
+	var choice ChatChoice
+	choice.Message.Role = "assistant"
+	var text strings.Builder
+	for _, item := range r.Output {
+		switch item.Type {
+		case "message":
+			for _, part := range item.Content {
+				if part.Type == "output_text" {
+					text.WriteString(part.Text)
+				}
+			}
+		case "function_call":
+			choice.Message.ToolCalls = append(choice.Message.ToolCalls, ToolCall{
+				ID:       item.CallID,
+				Type:     "function",
+				Function: ToolCallFunction{Name: item.Name, Arguments: item.Arguments},
+			})
+		}
+	}
+	choice.Message.Content = text.String()
+
+	switch {
+	case r.Status == "incomplete" && r.IncompleteDetails != nil && r.IncompleteDetails.Reason == "max_output_tokens":
+		choice.FinishReason = FinishReasonLength
+	case len(choice.Message.ToolCalls) > 0:
+		choice.FinishReason = FinishReasonToolCalls
+	default:
+		choice.FinishReason = "stop"
+	}
+	chat.Choices = []ChatChoice{choice}
+
+	if r.Usage != nil {
+		chat.Usage = &Usage{
+			PromptTokens:         r.Usage.InputTokens,
+			CompletionTokens:     r.Usage.OutputTokens,
+			TotalTokens:          r.Usage.TotalTokens,
+			CacheReadInputTokens: r.Usage.InputTokensDetails.CachedTokens,
+		}
+	}
+	return chat
+}
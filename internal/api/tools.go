@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ToolRunCommand is the name of the shell command tool
+const ToolRunCommand = "run_command"
+
+// RunCommandTool describes the run_command tool offered with --tools
+func RunCommandTool() Tool {
+	return Tool{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        ToolRunCommand,
+			Description: "Run a shell command in the user's current directory and return its combined stdout and stderr. The user must approve every command before it runs.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"command": map[string]any{
+						"type":        "string",
+						"description": "The shell command to run",
+					},
+				},
+				"required": []string{"command"},
+			},
+		},
+	}
+}
+
+// RunCommandArgs parses the arguments of a run_command tool call
+func RunCommandArgs(call ToolCall) (string, error) {
+	var args struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+		return "", fmt.Errorf("invalid %s arguments: %w", ToolRunCommand, err)
+	}
+	if args.Command == "" {
+		return "", fmt.Errorf("invalid %s arguments: missing command", ToolRunCommand)
+	}
+	return args.Command, nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/internal/logging"
+)
+
+// traceRedacted replaces the API key wherever it would appear in a trace
+const traceRedacted = "[REDACTED]"
+
+// Trace is the transcript of every request a run sends (--trace): the
+// assembled request bodies, the raw responses, timing, and usage. It is
+// rewritten after each exchange so it survives a run that fails part way.
+type Trace struct {
+	StartedAt time.Time       `json:"started_at"`
+	Model     string          `json:"model"`
+	APIURL    string          `json:"api_url"`
+	Exchanges []TraceExchange `json:"exchanges"`
+
+	path   string
+	apiKey string
+	mu     sync.Mutex // Background pruning may send requests concurrently
+	failed bool       // A write failed and was reported
+}
+
+// TraceExchange is one HTTP request to the provider and its response
+type TraceExchange struct {
+	StartedAt    time.Time         `json:"started_at"`
+	DurationMs   int64             `json:"duration_ms"`
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Request      json.RawMessage   `json:"request"`
+	Status       int               `json:"status,omitempty"`
+	Response     json.RawMessage   `json:"response,omitempty"`      // Raw body when it is JSON
+	ResponseText string            `json:"response_text,omitempty"` // Raw body otherwise, e.g. an HTML error page
+	Usage        *Usage            `json:"usage,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// newTrace returns a trace written to path, or nil if path is empty
+func newTrace(cfg *config.Config, path string) *Trace {
+	if path == "" {
+		return nil
+	}
+	return &Trace{
+		StartedAt: time.Now(),
+		Model:     cfg.Model,
+		APIURL:    cfg.APIURL,
+		path:      path,
+		apiKey:    cfg.APIKey,
+	}
+}
+
+// minRedactLen is the shortest API key replaced inside URLs and bodies;
+// shorter (placeholder) keys would blank out ordinary text
+const minRedactLen = 8
+
+// redact removes the API key from s
+func (t *Trace) redact(s string) string {
+	if len(t.apiKey) < minRedactLen {
+		return s
+	}
+	return strings.ReplaceAll(s, t.apiKey, traceRedacted)
+}
+
+// record adds an exchange to the trace and rewrites the trace file.
+// A failed write is reported once and doesn't fail the request.
+func (t *Trace) record(req *http.Request, body []byte, status int, respBody []byte, completion Completion, err error, start time.Time) {
+	exchange := TraceExchange{
+		StartedAt:  start,
+		DurationMs: time.Since(start).Milliseconds(),
+		Request:    json.RawMessage(t.redact(string(body))),
+		Status:     status,
+		URL:        t.redact(t.APIURL),
+	}
+	if req != nil { // Nil for the mock provider, which answers locally
+		exchange.URL = t.redact(req.URL.String())
+		exchange.Headers = make(map[string]string, len(req.Header))
+		for name := range req.Header {
+			switch name {
+			case "Authorization":
+				exchange.Headers[name] = "Bearer " + traceRedacted
+			case "X-Api-Key":
+				exchange.Headers[name] = traceRedacted
+			default:
+				exchange.Headers[name] = t.redact(req.Header.Get(name))
+			}
+		}
+	}
+	if len(respBody) > 0 {
+		if text := t.redact(string(respBody)); json.Valid([]byte(text)) {
+			exchange.Response = json.RawMessage(text)
+		} else {
+			exchange.ResponseText = text
+		}
+	}
+	if err != nil {
+		exchange.Error = t.redact(err.Error())
+	} else if completion.Usage != (Usage{}) {
+		usage := completion.Usage
+		exchange.Usage = &usage
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Exchanges = append(t.Exchanges, exchange)
+	if err := t.write(); err != nil && !t.failed {
+		t.failed = true
+		logging.Infof("Warning: %v\n", err)
+	}
+}
+
+// write saves the trace as indented JSON
+func (t *Trace) write() error {
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace: %w", err)
+	}
+	if err := os.WriteFile(t.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
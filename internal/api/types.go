@@ -1,10 +1,58 @@
 package api
 
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
 // ChatMessage represents a message in the chat completion request
 type ChatMessage struct {
 	Role         string        `json:"role"`
 	Content      string        `json:"content"`
 	CacheControl *CacheControl `json:"cache_control,omitempty"`
+	ToolCalls    []ToolCall    `json:"tool_calls,omitempty"`   // Set on assistant messages that call tools
+	ToolCallID   string        `json:"tool_call_id,omitempty"` // Set on "tool" result messages
+
+	// Parts replaces Content in the request when set, e.g. text plus images
+	Parts []ContentPart `json:"-"`
+}
+
+// MarshalJSON sends Parts as the content array when set. Text-only messages
+// keep a plain string content.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	type plain ChatMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []ContentPart `json:"content"`
+	}{plain(m), m.Parts})
+}
+
+// ContentPart is one part of a multimodal message
+type ContentPart struct {
+	Type     string    `json:"type"` // "text" or "image_url"
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL points at an image, here always a base64 data URI
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+// TextPart returns a text content part
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+// ImagePart returns an image content part with the image inlined as a data URI
+func ImagePart(mediaType string, data []byte) ContentPart {
+	url := "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}}
 }
 
 // CacheControl specifies caching behavior for Claude API
@@ -14,19 +62,110 @@ type CacheControl struct {
 
 // ChatCompletionRequest represents the request to the chat completions API
 type ChatCompletionRequest struct {
-	Model    string        `json:"model"`
-	Messages []ChatMessage `json:"messages"`
+	Model               string        `json:"model"`
+	Messages            []ChatMessage `json:"messages"`
+	Temperature         *float64      `json:"temperature,omitempty"`
+	TopP                *float64      `json:"top_p,omitempty"`
+	MaxTokens           int           `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int           `json:"max_completion_tokens,omitempty"` // Replaces max_tokens for reasoning models
+	Tools               []Tool        `json:"tools,omitempty"`
+	N                   int           `json:"n,omitempty"`    // Number of choices to generate
+	Seed                *int          `json:"seed,omitempty"` // Best-effort deterministic sampling (OpenAI)
+}
+
+// Tool describes a function the model may call
+type Tool struct {
+	Type     string       `json:"type"` // Always "function"
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction is the name, description, and JSON Schema parameters of a tool
+type ToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+// ToolCall is a model request to call a tool
+type ToolCall struct {
+	ID       string           `json:"id"`
+	Type     string           `json:"type"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction names the called tool and its arguments
+type ToolCallFunction struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON-encoded arguments
 }
 
 // ChatCompletionResponse represents the response from the chat completions API
 type ChatCompletionResponse struct {
-	Choices []struct {
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
-	Error *APIError `json:"error,omitempty"`
+	Choices           []ChatChoice `json:"choices"`
+	Usage             *Usage       `json:"usage,omitempty"`
+	SystemFingerprint string       `json:"system_fingerprint,omitempty"`
+	Error             *APIError    `json:"error,omitempty"`
+}
+
+// ChatChoice is one generated response in a chat completion
+type ChatChoice struct {
+	Message struct {
+		Role      string     `json:"role"`
+		Content   string     `json:"content"`
+		ToolCalls []ToolCall `json:"tool_calls"`
+	} `json:"message"`
+	FinishReason string `json:"finish_reason"`
+}
+
+// Usage is the token usage reported by the provider
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+
+	// Claude prompt caching: tokens written to and read from the cache
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// Add returns the sum of two usages, e.g. across continuation requests
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:             u.PromptTokens + other.PromptTokens,
+		CompletionTokens:         u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:              u.TotalTokens + other.TotalTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens + other.CacheCreationInputTokens,
+		CacheReadInputTokens:     u.CacheReadInputTokens + other.CacheReadInputTokens,
+	}
+}
+
+const (
+	// FinishReasonLength means the model stopped because it hit the output token limit
+	FinishReasonLength = "length"
+
+	// FinishReasonToolCalls means the model stopped to call one or more tools
+	FinishReasonToolCalls = "tool_calls"
+)
+
+// Completion is the result of a chat completion request
+type Completion struct {
+	Content      string
+	FinishReason string     // "stop", "length", etc. Empty if the provider didn't report one
+	ToolCalls    []ToolCall // Tools the model wants called before it answers
+	Usage        Usage      // Zero if the provider didn't report usage
+
+	// SystemFingerprint identifies the backend configuration that served the
+	// request; empty if the provider didn't report one
+	SystemFingerprint string
+
+	// Choices holds every returned choice when more than one was requested;
+	// the fields above describe the first
+	Choices []Completion
+}
+
+// Truncated reports whether the response was cut off by the output token limit
+func (c Completion) Truncated() bool {
+	return c.FinishReason == FinishReasonLength
 }
 
 // APIError represents an error from the API
@@ -35,3 +174,21 @@ type APIError struct {
 	Type    string `json:"type"`
 	Code    string `json:"code"`
 }
+
+// ErrContextLengthExceeded is returned when the request is larger than the
+// model's context window
+var ErrContextLengthExceeded = errors.New("request exceeds the model's context window")
+
+// isContextLengthError reports whether an API error means the prompt was too long
+func isContextLengthError(apiErr *APIError) bool {
+	if apiErr.Code == "context_length_exceeded" {
+		return true
+	}
+	msg := strings.ToLower(apiErr.Message)
+	for _, phrase := range []string{"maximum context length", "context window", "context length", "prompt is too long", "too many tokens"} {
+		if strings.Contains(msg, phrase) {
+			return true
+		}
+	}
+	return false
+}
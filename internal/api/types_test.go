@@ -28,6 +28,15 @@ func TestChatMessageCacheControl(t *testing.T) {
 			},
 			wantJSON: `{"role":"system","content":"You are helpful","cache_control":{"type":"ephemeral"}}`,
 		},
+		{
+			name: "message with image parts",
+			msg: ChatMessage{
+				Role:    "user",
+				Content: "What's this?",
+				Parts:   []ContentPart{TextPart("What's this?"), ImagePart("image/png", []byte("png"))},
+			},
+			wantJSON: `{"role":"user","content":[{"type":"text","text":"What's this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,cG5n"}}]}`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -43,3 +52,30 @@ func TestChatMessageCacheControl(t *testing.T) {
 		})
 	}
 }
+
+func TestSupportsVision(t *testing.T) {
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"gpt-4o", true},
+		{"gpt-4o-mini", true},
+		{"gpt-4-turbo", true},
+		{"gpt-4.1", true},
+		{"openai/gpt-4o", true},
+		{"claude-3-5-sonnet-20241022", true},
+		{"llama3.2-vision", true},
+		{"gpt-4", false},
+		{"gpt-4-0613", false},
+		{"gpt-4-1106-vision-preview", true},
+		{"gpt-3.5-turbo", false},
+		{"o3-mini", false},
+		{"claude-2.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := SupportsVision(tt.model); got != tt.want {
+			t.Errorf("SupportsVision(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+}
@@ -5,51 +5,481 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Config holds the runtime configuration
 type Config struct {
-	APIKey  string
-	Model   string
-	OS      string
-	APIURL  string
+	APIKey string
+	Model  string
+	OS     string
+	APIURL string
+
+	// APIProvider selects the request format: "" for chat completions, or
+	// ProviderOpenAIResponses (also detected from a /responses URL)
+	APIProvider string
+
+	// AllowedModels restricts Model to these models when set, so a shared
+	// deployment can rule out expensive ones
+	AllowedModels []string
+
+	// PruneModel is the model AI-driven pruning asks (see PruningModel)
+	PruneModel string
+
+	// Shell and Distro refine OS in the system prompt so commands suit the
+	// user's shell and Linux package manager; both are detected by default
+	Shell  string
+	Distro string
+
+	// APIKeySource describes where APIKey came from (a file path or the environment)
+	APIKeySource string
+
+	// UserAgent is sent with every API request; empty sends DefaultUserAgent
+	UserAgent string
+
+	// Profile is the named profile that was loaded, if any
+	Profile string
+
+	// Dir is the directory whose context is used; empty means the current directory
+	Dir string
+
+	// Session is the named session whose context is used; empty means the
+	// directory's current session (see --switch-session)
+	Session string
+
+	// ContextFile is a context file used instead of the directory's file
+	// under ~/.config/ask/contexts, e.g. a per-job artifact in CI (empty disables)
+	ContextFile string
+
+	// Pruning preservation settings
+	PreserveKeywords        []string // Extra keywords that protect a message from pruning
+	ReplacePreserveKeywords bool     // Use PreserveKeywords instead of the built-in defaults
+	PreserveCodeBlocks      bool     // Protect messages containing code blocks
+	KeepRecent              int      // Most recent exchanges always kept (0 uses the default)
+
+	// Analysis path filters (globs); includes override .gitignore and the common ignores
+	AnalyzeInclude []string
+	AnalyzeExclude []string
+
+	// AnalyzeMaxFiles caps the directory entries analysis examines (0 uses the default)
+	AnalyzeMaxFiles int
+
+	// AnalyzeMaxPerDir caps the entries listed per directory in the analysis tree (0 uses the default)
+	AnalyzeMaxPerDir int
+
+	// AnalyzeBinaries is how binary files appear in the analysis tree:
+	// "" to list them, "annotate" to mark them, or "skip" to leave them out
+	AnalyzeBinaries string
+
+	// AnalysisMode is when cached analysis is sent: AnalysisAlways (or ""),
+	// AnalysisAuto, or AnalysisNever
+	AnalysisMode string
+
+	// NoAnalysis leaves cached analysis out of this run's query, whatever AnalysisMode says
+	NoAnalysis bool
+
+	// ShareAnalysis reuses the nearest analyzed ancestor's analysis (up to the git root)
+	ShareAnalysis bool
+
+	// ConfirmTokens requires confirmation before sending prompts estimated above
+	// this many tokens (0 disables the check)
+	ConfirmTokens int
+
+	// Persona selects a persona preset appended to the system prompt
+	Persona string
+
+	// MaxMessageLength caps stored message length in characters (0 uses the default)
+	MaxMessageLength int
+
+	// OffloadLarge moves messages longer than this many characters out of the
+	// active context into a blob file, leaving a reference (0 disables it)
+	OffloadLarge int
+
+	// Retries is how many times a failed API request is retried (network errors, 429, 5xx)
+	Retries int
+
+	// Sampling parameters (nil/0 means use the provider default)
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int
+
+	// Seed asks the provider for reproducible sampling; with a seed and no
+	// explicit temperature, temperature 0 is used
+	Seed *int
+
+	// Choices is how many responses to request (n); above 1 the user picks one
+	Choices int
+
+	// InstructionRole forces the system prompt role ("system" or "developer");
+	// empty picks one based on the model
+	InstructionRole string
+
+	// RawPrompt omits the base system prompt, letting the model's defaults through
+	RawPrompt bool
+
+	// Markdown lets the system prompt allow markdown instead of asking for
+	// plain terminal text; --output turns it on
+	Markdown bool
+
+	// Format is the output preset: FormatPlain (or ""), FormatMarkdown,
+	// FormatJSON, or FormatShell. It sets both the system prompt framing and
+	// how the CLI prints the answer.
+	Format string
+
+	// Tools offers the model a run_command tool; each command needs user approval
+	Tools bool
+
+	// AutoContinue requests continuations when a response hits the output token limit
+	AutoContinue bool
+
+	// ResponseCache reuses stored responses for identical deterministic
+	// (temperature 0) requests; entries expire after ResponseCacheTTL
+	ResponseCache    bool
+	ResponseCacheTTL time.Duration
+
+	// Verbose prints diagnostics on stderr, such as the provider's system
+	// fingerprint and the messages AI-driven pruning chose to remove
+	Verbose bool
+
+	// ExplainPrune asks AI-driven pruning for a reason per removed message
+	// and prints them like Verbose
+	ExplainPrune bool
+
+	// ContextTTL resets a context on load when it hasn't been updated for
+	// this long (0 keeps contexts forever); KeepStale skips the reset once
+	ContextTTL time.Duration
+	KeepStale  bool
+
+	// Dedup answers an immediately repeated question from the stored answer
+	// without calling the API or storing the question again
+	Dedup bool
+
+	// ShowUsage prints how full the context is after each answer
+	ShowUsage bool
+
+	// StreamDelay is the pause between words when printing responses (0 disables)
+	StreamDelay time.Duration
+
+	// Redact replaces detected secrets in messages before they are stored
+	Redact bool
+
+	// ScanInjection flags instruction-like text in analyzed READMEs and file
+	// names, warning the user and marking it as untrusted in the prompt
+	ScanInjection bool
+
+	// Compress gzips context files (written as .json.gz)
+	Compress bool
+
+	// Store is where contexts are kept: StoreFile (or "") or StoreSQLite
+	Store string
+
+	// TraceFile receives a JSON transcript of every request and response in
+	// this run, with the API key redacted (set by --trace; empty disables)
+	TraceFile string
+
+	// AuditLog is a file where every turn is appended as a JSON line (empty disables)
+	AuditLog string
+
+	// EmbeddingsURL is an OpenAI-compatible embeddings endpoint. When set,
+	// pruning keeps a semantically diverse set of exchanges using embeddings
+	// instead of asking the chat model which messages to remove.
+	EmbeddingsURL   string
+	EmbeddingsModel string
+
+	// EmbeddingsAPIKey authenticates embeddings requests. Without it, APIKey
+	// is sent only when EmbeddingsURL is on the same host as APIURL.
+	EmbeddingsAPIKey string
+
+	// DefaultQuery is asked when ask is run with no query in a terminal
+	DefaultQuery string
+
+	// SearchCmd is a shell command run with the query as its argument when Web
+	// is set; its output is sent to the model as search results
+	SearchCmd string
+	Web       bool
+
+	// Encryption-at-rest for context files. The key file is read when no key is set.
+	EncryptionKey     string
+	EncryptionKeyFile string
 }
 
-// Load reads configuration from .env files and environment variables
-// Priority: env vars > local .env > global .env
+// Load reads configuration from .env files and environment variables,
+// using the profile named by ASK_PROFILE if set
+// Priority: env vars > local .env > profile > global .env
 func Load() (*Config, error) {
+	return LoadProfile(os.Getenv("ASK_PROFILE"))
+}
+
+// LoadProfile reads configuration like Load, layering the named profile
+// (~/.config/ask/profiles/<name>.env) between the global and local .env.
+// An empty name loads no profile.
+func LoadProfile(profile string) (*Config, error) {
 	cfg := &Config{
 		Model:  DefaultModel,
-		OS:     DefaultOS,
+		OS:     DefaultOS(),
 		APIURL: DefaultAPIURL,
+		Shell:  DefaultShell(),
+		Distro: DefaultDistro(),
+
+		PreserveCodeBlocks: DefaultPreserveCodeBlocks,
+		Redact:             DefaultRedact,
+		ScanInjection:      DefaultScanInjection,
+		Retries:            DefaultRetries,
+		EmbeddingsModel:    DefaultEmbeddingsModel,
 	}
 
 	// Load global config
-	homeDir, err := os.UserHomeDir()
+	baseDir, err := BaseDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
 
-	globalEnvPath := filepath.Join(homeDir, GlobalConfigDir, GlobalEnvFile)
+	// Each layer overrides the one before it: global, profile, local, env vars
+	globalEnvPath := filepath.Join(baseDir, GlobalEnvFile)
 	_ = loadEnvFile(globalEnvPath, cfg) // Global config is optional, ignore errors
 
-	// Load local config (overrides global)
+	if profile != "" {
+		if strings.ContainsAny(profile, `/\`) || strings.HasPrefix(profile, ".") {
+			return nil, fmt.Errorf("invalid profile name %q", profile)
+		}
+		profilePath := filepath.Join(baseDir, ProfilesDir, profile+".env")
+		if err := loadEnvFile(profilePath, cfg); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("profile %q not found (expected %s)", profile, profilePath)
+			}
+			return nil, fmt.Errorf("failed to load profile %q: %w", profile, err)
+		}
+		cfg.Profile = profile
+	}
+
+	// Load local config (overrides global and profile)
 	_ = loadEnvFile(LocalEnvFile, cfg) // Local config is optional, ignore errors
 
 	// Environment variables override everything
 	if v := os.Getenv("ASK_API_KEY"); v != "" {
 		cfg.APIKey = v
+		cfg.APIKeySource = "environment variable ASK_API_KEY"
 	}
 	if v := os.Getenv("ASK_MODEL"); v != "" {
 		cfg.Model = v
 	}
+	if v := os.Getenv("ASK_ALLOWED_MODELS"); v != "" {
+		cfg.AllowedModels = parseList(v)
+	}
+	if v := os.Getenv("ASK_PRUNE_MODEL"); v != "" {
+		cfg.PruneModel = v
+	}
 	if v := os.Getenv("ASK_OS"); v != "" {
 		cfg.OS = v
 	}
+	if v := os.Getenv("ASK_SHELL"); v != "" {
+		cfg.Shell = v
+	}
+	if v := os.Getenv("ASK_DISTRO"); v != "" {
+		cfg.Distro = v
+	}
 	if v := os.Getenv("ASK_API_URL"); v != "" {
 		cfg.APIURL = v
 	}
+	if v := os.Getenv("ASK_API_PROVIDER"); v != "" {
+		cfg.APIProvider = strings.ToLower(v)
+	}
+	if v := os.Getenv("ASK_PRESERVE_KEYWORDS"); v != "" {
+		cfg.PreserveKeywords = parseList(v)
+	}
+	if v := os.Getenv("ASK_PRESERVE_KEYWORDS_REPLACE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.ReplacePreserveKeywords = b
+		}
+	}
+	if v := os.Getenv("ASK_PRESERVE_CODE_BLOCKS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.PreserveCodeBlocks = b
+		}
+	}
+	if v := os.Getenv("ASK_ANALYZE_INCLUDE"); v != "" {
+		cfg.AnalyzeInclude = parseList(v)
+	}
+	if v := os.Getenv("ASK_ANALYZE_EXCLUDE"); v != "" {
+		cfg.AnalyzeExclude = parseList(v)
+	}
+	if v := os.Getenv("ASK_ANALYZE_MAX_FILES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AnalyzeMaxFiles = n
+		}
+	}
+	if v := os.Getenv("ASK_ANALYZE_MAX_PER_DIR"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AnalyzeMaxPerDir = n
+		}
+	}
+	if v := os.Getenv("ASK_ANALYZE_BINARIES"); v != "" {
+		cfg.AnalyzeBinaries = strings.ToLower(v)
+	}
+	if v := os.Getenv("ASK_ANALYSIS_MODE"); v != "" {
+		cfg.AnalysisMode = strings.ToLower(v)
+	}
+	if v := os.Getenv("ASK_SHARE_ANALYSIS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.ShareAnalysis = b
+		}
+	}
+	if v := os.Getenv("ASK_CONFIRM_TOKENS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.ConfirmTokens = n
+		}
+	}
+	if v := os.Getenv("ASK_AUTO_CONTINUE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.AutoContinue = b
+		}
+	}
+	if v := os.Getenv("ASK_RESPONSE_CACHE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.ResponseCache = b
+		}
+	}
+	if v := os.Getenv("ASK_RESPONSE_CACHE_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.ResponseCacheTTL = d
+		}
+	}
+	if v := os.Getenv("ASK_CONTEXT_TTL"); v != "" {
+		if d, err := ParseAge(v); err == nil {
+			cfg.ContextTTL = d
+		}
+	}
+	if v := os.Getenv("ASK_DEDUP"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Dedup = b
+		}
+	}
+	if v := os.Getenv("ASK_SHOW_USAGE"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.ShowUsage = b
+		}
+	}
+	if v := os.Getenv("ASK_KEEP_RECENT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.KeepRecent = n
+		}
+	}
+	if v := os.Getenv("ASK_MAX_MESSAGE_LEN"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxMessageLength = n
+		}
+	}
+	if v := os.Getenv("ASK_OFFLOAD_LARGE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.OffloadLarge = n
+		}
+	}
+	if v := os.Getenv("ASK_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Retries = n
+		}
+	}
+	if v := os.Getenv("ASK_TEMPERATURE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.Temperature = &f
+		}
+	}
+	if v := os.Getenv("ASK_TOP_P"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.TopP = &f
+		}
+	}
+	if v := os.Getenv("ASK_SEED"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Seed = &n
+		}
+	}
+	if v := os.Getenv("ASK_MAX_TOKENS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.MaxTokens = n
+		}
+	}
+	if v := os.Getenv("ASK_N"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.Choices = n
+		}
+	}
+	if v := os.Getenv("ASK_INSTRUCTION_ROLE"); v != "" {
+		cfg.InstructionRole = v
+	}
+	if v := os.Getenv("ASK_REDACT"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Redact = b
+		}
+	}
+	if v := os.Getenv("ASK_SCAN_INJECTION"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.ScanInjection = b
+		}
+	}
+	if v := os.Getenv("ASK_STREAM_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.StreamDelay = d
+		}
+	}
+	if v := os.Getenv("ASK_FORMAT"); v != "" {
+		cfg.Format = strings.ToLower(v)
+	}
+	if v := os.Getenv("ASK_MARKDOWN"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Markdown = b
+		}
+	}
+	if v := os.Getenv("ASK_COMPRESS"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.Compress = b
+		}
+	}
+	if v := os.Getenv("ASK_STORE"); v != "" {
+		cfg.Store = strings.ToLower(v)
+	}
+	if v := os.Getenv("ASK_CONTEXT_FILE"); v != "" {
+		cfg.ContextFile = v
+	}
+	if v := os.Getenv("ASK_AUDIT_LOG"); v != "" {
+		cfg.AuditLog = v
+	}
+	if v := os.Getenv("ASK_DEFAULT_QUERY"); v != "" {
+		cfg.DefaultQuery = v
+	}
+	if v := os.Getenv("ASK_EMBEDDINGS_URL"); v != "" {
+		cfg.EmbeddingsURL = v
+	}
+	if v := os.Getenv("ASK_EMBEDDINGS_MODEL"); v != "" {
+		cfg.EmbeddingsModel = v
+	}
+	if v := os.Getenv("ASK_EMBEDDINGS_API_KEY"); v != "" {
+		cfg.EmbeddingsAPIKey = v
+	}
+	if v := os.Getenv("ASK_USER_AGENT"); v != "" {
+		cfg.UserAgent = v
+	}
+	if v := os.Getenv("ASK_SEARCH_CMD"); v != "" {
+		cfg.SearchCmd = v
+	}
+	if v := os.Getenv("ASK_ENCRYPTION_KEY"); v != "" {
+		cfg.EncryptionKey = v
+	}
+	if v := os.Getenv("ASK_ENCRYPTION_KEY_FILE"); v != "" {
+		cfg.EncryptionKeyFile = v
+	}
+
+	// Read the encryption key from a file if no key was given directly
+	if cfg.EncryptionKey == "" && cfg.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(cfg.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		cfg.EncryptionKey = strings.TrimSpace(string(data))
+	}
 
 	return cfg, nil
 }
@@ -80,34 +510,297 @@ func loadEnvFile(path string, cfg *Config) error {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
-		// Only set if not already set (respect previous values)
+		// List-type keys merge across files instead of keeping the first value
+		if list := listField(cfg, key); list != nil {
+			*list = mergeList(*list, parseList(value))
+			continue
+		}
+
+		// Later files override earlier ones; unparseable values are ignored
 		switch key {
 		case "ASK_API_KEY":
-			if cfg.APIKey == "" {
-				cfg.APIKey = value
-			}
+			cfg.APIKey = value
+			cfg.APIKeySource = path
 		case "ASK_MODEL":
-			if cfg.Model == DefaultModel {
-				cfg.Model = value
-			}
+			cfg.Model = value
+		case "ASK_ALLOWED_MODELS":
+			cfg.AllowedModels = parseList(value)
+		case "ASK_PRUNE_MODEL":
+			cfg.PruneModel = value
 		case "ASK_OS":
-			if cfg.OS == DefaultOS {
-				cfg.OS = value
-			}
+			cfg.OS = value
+		case "ASK_SHELL":
+			cfg.Shell = value
+		case "ASK_DISTRO":
+			cfg.Distro = value
 		case "ASK_API_URL":
-			if cfg.APIURL == DefaultAPIURL {
-				cfg.APIURL = value
+			cfg.APIURL = value
+		case "ASK_API_PROVIDER":
+			cfg.APIProvider = strings.ToLower(value)
+		case "ASK_PRESERVE_KEYWORDS_REPLACE":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.ReplacePreserveKeywords = b
+			}
+		case "ASK_PRESERVE_CODE_BLOCKS":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.PreserveCodeBlocks = b
+			}
+		case "ASK_SHARE_ANALYSIS":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.ShareAnalysis = b
+			}
+		case "ASK_CONFIRM_TOKENS":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.ConfirmTokens = n
+			}
+		case "ASK_AUTO_CONTINUE":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.AutoContinue = b
+			}
+		case "ASK_RESPONSE_CACHE":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.ResponseCache = b
+			}
+		case "ASK_RESPONSE_CACHE_TTL":
+			if d, err := time.ParseDuration(value); err == nil {
+				cfg.ResponseCacheTTL = d
+			}
+		case "ASK_CONTEXT_TTL":
+			if d, err := ParseAge(value); err == nil {
+				cfg.ContextTTL = d
+			}
+		case "ASK_DEDUP":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.Dedup = b
+			}
+		case "ASK_SHOW_USAGE":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.ShowUsage = b
+			}
+		case "ASK_ANALYZE_BINARIES":
+			cfg.AnalyzeBinaries = strings.ToLower(value)
+		case "ASK_ANALYSIS_MODE":
+			cfg.AnalysisMode = strings.ToLower(value)
+		case "ASK_ANALYZE_MAX_FILES":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.AnalyzeMaxFiles = n
+			}
+		case "ASK_ANALYZE_MAX_PER_DIR":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.AnalyzeMaxPerDir = n
+			}
+		case "ASK_KEEP_RECENT":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.KeepRecent = n
+			}
+		case "ASK_MAX_MESSAGE_LEN":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.MaxMessageLength = n
 			}
+		case "ASK_OFFLOAD_LARGE":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.OffloadLarge = n
+			}
+		case "ASK_RETRIES":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.Retries = n
+			}
+		case "ASK_TEMPERATURE":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				cfg.Temperature = &f
+			}
+		case "ASK_TOP_P":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				cfg.TopP = &f
+			}
+		case "ASK_SEED":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.Seed = &n
+			}
+		case "ASK_MAX_TOKENS":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.MaxTokens = n
+			}
+		case "ASK_N":
+			if n, err := strconv.Atoi(value); err == nil {
+				cfg.Choices = n
+			}
+		case "ASK_INSTRUCTION_ROLE":
+			cfg.InstructionRole = value
+		case "ASK_REDACT":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.Redact = b
+			}
+		case "ASK_SCAN_INJECTION":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.ScanInjection = b
+			}
+		case "ASK_STREAM_DELAY":
+			if d, err := time.ParseDuration(value); err == nil {
+				cfg.StreamDelay = d
+			}
+		case "ASK_FORMAT":
+			cfg.Format = strings.ToLower(value)
+		case "ASK_MARKDOWN":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.Markdown = b
+			}
+		case "ASK_COMPRESS":
+			if b, err := strconv.ParseBool(value); err == nil {
+				cfg.Compress = b
+			}
+		case "ASK_STORE":
+			cfg.Store = strings.ToLower(value)
+		case "ASK_CONTEXT_FILE":
+			cfg.ContextFile = value
+		case "ASK_AUDIT_LOG":
+			cfg.AuditLog = value
+		case "ASK_DEFAULT_QUERY":
+			cfg.DefaultQuery = value
+		case "ASK_EMBEDDINGS_URL":
+			cfg.EmbeddingsURL = value
+		case "ASK_EMBEDDINGS_MODEL":
+			cfg.EmbeddingsModel = value
+		case "ASK_EMBEDDINGS_API_KEY":
+			cfg.EmbeddingsAPIKey = value
+		case "ASK_USER_AGENT":
+			cfg.UserAgent = value
+		case "ASK_SEARCH_CMD":
+			cfg.SearchCmd = value
+		case "ASK_ENCRYPTION_KEY":
+			cfg.EncryptionKey = value
+		case "ASK_ENCRYPTION_KEY_FILE":
+			cfg.EncryptionKeyFile = value
 		}
 	}
 
 	return scanner.Err()
 }
 
+// listField returns the list setting for a list-type key, or nil if the key
+// holds a single value. Values for list-type keys from every .env file are
+// merged; an environment variable still replaces the merged list.
+func listField(cfg *Config, key string) *[]string {
+	switch key {
+	case "ASK_PRESERVE_KEYWORDS":
+		return &cfg.PreserveKeywords
+	case "ASK_ANALYZE_INCLUDE":
+		return &cfg.AnalyzeInclude
+	case "ASK_ANALYZE_EXCLUDE":
+		return &cfg.AnalyzeExclude
+	}
+	return nil
+}
+
+// mergeList appends the items not already in list, keeping their order
+func mergeList(list, items []string) []string {
+	for _, item := range items {
+		if !slices.Contains(list, item) {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseList splits a comma-separated value into trimmed, non-empty items
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
 	if c.APIKey == "" && c.APIURL == DefaultAPIURL {
 		return fmt.Errorf("ASK_API_KEY is required for OpenAI API")
 	}
+	if !c.modelAllowed(c.Model) {
+		return fmt.Errorf("model %q isn't allowed; ASK_ALLOWED_MODELS permits: %s", c.Model, strings.Join(c.AllowedModels, ", "))
+	}
+	if c.PruneModel != "" && !c.modelAllowed(c.PruneModel) {
+		return fmt.Errorf("prune model %q isn't allowed; ASK_ALLOWED_MODELS permits: %s", c.PruneModel, strings.Join(c.AllowedModels, ", "))
+	}
+	if c.InstructionRole != "" && c.InstructionRole != "system" && c.InstructionRole != "developer" {
+		return fmt.Errorf("ASK_INSTRUCTION_ROLE must be \"system\" or \"developer\", got %q", c.InstructionRole)
+	}
+	if c.APIProvider != "" && c.APIProvider != ProviderOpenAIResponses {
+		return fmt.Errorf("ASK_API_PROVIDER must be %q (or unset for chat completions), got %q", ProviderOpenAIResponses, c.APIProvider)
+	}
+	if c.Format != "" && !slices.Contains(Formats, c.Format) {
+		return fmt.Errorf("ASK_FORMAT must be one of %s, got %q", strings.Join(Formats, ", "), c.Format)
+	}
+	if c.AnalysisMode != "" && !slices.Contains(AnalysisModes, c.AnalysisMode) {
+		return fmt.Errorf("ASK_ANALYSIS_MODE must be one of %s, got %q", strings.Join(AnalysisModes, ", "), c.AnalysisMode)
+	}
+	if c.Store != "" && !slices.Contains(Stores, c.Store) {
+		return fmt.Errorf("ASK_STORE must be one of %s, got %q", strings.Join(Stores, ", "), c.Store)
+	}
+	if c.AnalyzeBinaries != "" && c.AnalyzeBinaries != "annotate" && c.AnalyzeBinaries != "skip" {
+		return fmt.Errorf("ASK_ANALYZE_BINARIES must be \"annotate\" or \"skip\", got %q", c.AnalyzeBinaries)
+	}
 	return nil
 }
+
+// modelAllowed reports whether ASK_ALLOWED_MODELS permits model
+func (c *Config) modelAllowed(model string) bool {
+	return len(c.AllowedModels) == 0 || slices.ContainsFunc(c.AllowedModels, func(m string) bool { return strings.EqualFold(m, model) })
+}
+
+// PruningModel returns the model AI-driven pruning uses: PruneModel if set,
+// DefaultPruneModel on OpenAI's API (when allowed), or else Model
+func (c *Config) PruningModel() string {
+	if c.PruneModel != "" {
+		return c.PruneModel
+	}
+	if c.APIURL == DefaultAPIURL && c.modelAllowed(DefaultPruneModel) {
+		return DefaultPruneModel
+	}
+	return c.Model
+}
+
+// ModelMismatchWarning returns a warning if ASK_MODEL looks like it belongs
+// to a different provider than ASK_API_URL, or "" if they appear to match.
+// It is only a warning because custom gateways can serve any model.
+func (c *Config) ModelMismatchWarning() string {
+	url := strings.ToLower(c.APIURL)
+	model := strings.ToLower(c.Model)
+
+	isClaudeURL := strings.Contains(url, "anthropic.com") || strings.Contains(url, "claude")
+	isOpenAIModel := strings.HasPrefix(model, "gpt-") || strings.HasPrefix(model, "o1") ||
+		strings.HasPrefix(model, "o3") || strings.HasPrefix(model, "o4")
+
+	if isClaudeURL && isOpenAIModel {
+		return fmt.Sprintf("ASK_MODEL=%s looks like an OpenAI model but ASK_API_URL points at Claude; did you mean ASK_MODEL=%s?",
+			c.Model, DefaultClaudeModel)
+	}
+	if strings.Contains(url, "api.openai.com") && strings.HasPrefix(model, "claude") {
+		return fmt.Sprintf("ASK_MODEL=%s looks like a Claude model but ASK_API_URL points at OpenAI; did you mean ASK_MODEL=%s?",
+			c.Model, DefaultModel)
+	}
+	return ""
+}
+
+// ParseAge parses a duration like time.ParseDuration, adding day (d) and
+// week (w) units, e.g. "90d" or "2w"
+func ParseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid age %q", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age %q", value)
+	}
+	return age, nil
+}
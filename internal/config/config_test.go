@@ -0,0 +1,354 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModelMismatchWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiURL   string
+		model    string
+		wantHint string // Suggested model, or "" for no warning
+	}{
+		{"OpenAI with gpt", DefaultAPIURL, "gpt-4o", ""},
+		{"Claude with claude", "https://api.anthropic.com/v1/messages", "claude-3-5-sonnet-20241022", ""},
+		{"Claude with gpt", "https://api.anthropic.com/v1/messages", "gpt-4o", DefaultClaudeModel},
+		{"Claude with o-series", "https://api.anthropic.com/v1/messages", "o3-mini", DefaultClaudeModel},
+		{"OpenAI with claude", DefaultAPIURL, "claude-3-opus", DefaultModel},
+		{"Custom gateway serves anything", "http://localhost:8080/v1/chat", "claude-3-opus", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{APIURL: tt.apiURL, Model: tt.model}
+			warning := cfg.ModelMismatchWarning()
+
+			if tt.wantHint == "" {
+				if warning != "" {
+					t.Errorf("Unexpected warning: %s", warning)
+				}
+				return
+			}
+			if !strings.Contains(warning, "ASK_MODEL="+tt.wantHint) {
+				t.Errorf("Warning %q should suggest %s", warning, tt.wantHint)
+			}
+		})
+	}
+}
+
+func TestValidateAllowedModels(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		model   string
+		wantErr bool
+	}{
+		{"no allowlist", nil, "gpt-4.5-preview", false},
+		{"allowed", []string{"gpt-4o-mini", "gpt-4o"}, "gpt-4o", false},
+		{"case-insensitive", []string{"GPT-4o"}, "gpt-4o", false},
+		{"not allowed", []string{"gpt-4o-mini", "gpt-4o"}, "o1-pro", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{APIKey: "test", APIURL: DefaultAPIURL, Model: tt.model, AllowedModels: tt.allowed}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "gpt-4o-mini, gpt-4o") {
+				t.Errorf("Error %q should list the allowed models", err)
+			}
+		})
+	}
+}
+
+func TestPruningModel(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"explicit", Config{APIURL: DefaultAPIURL, Model: "gpt-4o", PruneModel: "gpt-4.1-nano"}, "gpt-4.1-nano"},
+		{"OpenAI default", Config{APIURL: DefaultAPIURL, Model: "gpt-4o"}, DefaultPruneModel},
+		{"other provider", Config{APIURL: "http://localhost:11434/v1/chat/completions", Model: "llama3"}, "llama3"},
+		{"default not allowed", Config{APIURL: DefaultAPIURL, Model: "gpt-4o", AllowedModels: []string{"gpt-4o"}}, "gpt-4o"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.PruningModel(); got != tt.want {
+				t.Errorf("PruningModel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	cfg := &Config{APIKey: "test", APIURL: DefaultAPIURL, Model: "gpt-4o", PruneModel: "o1-pro", AllowedModels: []string{"gpt-4o"}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "prune model") {
+		t.Errorf("Validate() error = %v, want a disallowed prune model rejected", err)
+	}
+}
+
+// isolateHome points HOME at a fresh temporary directory and clears
+// XDG_CONFIG_HOME, so BaseDir can't reach the developer's real config,
+// and returns the home directory
+func isolateHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	return home
+}
+
+// writeFile writes content to path, creating parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	home := isolateHome(t)
+	t.Chdir(t.TempDir()) // No local .env
+	for _, key := range []string{"ASK_API_KEY", "ASK_MODEL", "ASK_OS", "ASK_API_URL", "ASK_PROFILE"} {
+		t.Setenv(key, "")
+	}
+
+	writeFile(t, filepath.Join(home, GlobalConfigDir, GlobalEnvFile), "ASK_API_KEY=personal-key\nASK_MODEL=gpt-4o-mini\nASK_OS=Linux\n")
+	writeFile(t, filepath.Join(home, GlobalConfigDir, ProfilesDir, "work.env"), "ASK_API_KEY=work-key\nASK_MODEL=gpt-4.1\n")
+
+	cfg, err := LoadProfile("work")
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+	if cfg.APIKey != "work-key" || cfg.Model != "gpt-4.1" {
+		t.Errorf("Profile values should win, got key=%s model=%s", cfg.APIKey, cfg.Model)
+	}
+	if cfg.OS != "Linux" {
+		t.Errorf("Unset profile values should fall back to global, got OS=%s", cfg.OS)
+	}
+	if cfg.Profile != "work" {
+		t.Errorf("Profile = %q, want work", cfg.Profile)
+	}
+
+	// No profile: global only
+	cfg, err = LoadProfile("")
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+	if cfg.APIKey != "personal-key" || cfg.Profile != "" {
+		t.Errorf("Without a profile expected global config, got key=%s profile=%q", cfg.APIKey, cfg.Profile)
+	}
+
+	// ASK_PROFILE selects the profile for Load
+	t.Setenv("ASK_PROFILE", "work")
+	if cfg, err := Load(); err != nil || cfg.APIKey != "work-key" {
+		t.Errorf("Load() with ASK_PROFILE=work: key=%v err=%v", cfg, err)
+	}
+
+	if _, err := LoadProfile("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found error for missing profile, got %v", err)
+	}
+	if _, err := LoadProfile("../work"); err == nil {
+		t.Error("Expected error for profile name with a path")
+	}
+}
+
+func TestLoadMergesListKeys(t *testing.T) {
+	home := isolateHome(t)
+	local := t.TempDir()
+	t.Chdir(local)
+	for _, key := range []string{"ASK_PROFILE", "ASK_PRESERVE_KEYWORDS", "ASK_ANALYZE_INCLUDE", "ASK_ANALYZE_EXCLUDE"} {
+		t.Setenv(key, "")
+	}
+
+	writeFile(t, filepath.Join(home, GlobalConfigDir, GlobalEnvFile), "ASK_PRESERVE_KEYWORDS=deploy,migration\nASK_ANALYZE_EXCLUDE=testdata\n")
+	writeFile(t, filepath.Join(local, LocalEnvFile), "ASK_PRESERVE_KEYWORDS=migration,schema\nASK_ANALYZE_INCLUDE=vendor/acme\n")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := strings.Join(cfg.PreserveKeywords, ","); got != "deploy,migration,schema" {
+		t.Errorf("PreserveKeywords = %s, want global then local without duplicates", got)
+	}
+	if got := strings.Join(cfg.AnalyzeExclude, ","); got != "testdata" {
+		t.Errorf("AnalyzeExclude = %s, want testdata from global", got)
+	}
+	if got := strings.Join(cfg.AnalyzeInclude, ","); got != "vendor/acme" {
+		t.Errorf("AnalyzeInclude = %s, want vendor/acme from local", got)
+	}
+
+	// An environment variable replaces the merged list
+	t.Setenv("ASK_PRESERVE_KEYWORDS", "release")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if got := strings.Join(cfg.PreserveKeywords, ","); got != "release" {
+		t.Errorf("PreserveKeywords = %s, want release from the environment", got)
+	}
+}
+
+func TestLoadPriority(t *testing.T) {
+	home := isolateHome(t)
+	local := t.TempDir()
+	t.Chdir(local)
+	for _, key := range []string{"ASK_PROFILE", "ASK_API_KEY", "ASK_MODEL", "ASK_OS", "ASK_API_URL", "ASK_PRESERVE_CODE_BLOCKS", "ASK_RETRIES", "ASK_REDACT"} {
+		t.Setenv(key, "")
+	}
+
+	// Global and local conflict on every key; global sets non-default values
+	writeFile(t, filepath.Join(home, GlobalConfigDir, GlobalEnvFile),
+		"ASK_API_KEY=global-key\nASK_MODEL=gpt-4o-mini\nASK_OS=Linux\nASK_PRESERVE_CODE_BLOCKS=false\nASK_RETRIES=5\nASK_REDACT=false\n")
+	writeFile(t, filepath.Join(local, LocalEnvFile),
+		"ASK_API_KEY=local-key\nASK_MODEL=gpt-4.1\nASK_PRESERVE_CODE_BLOCKS=true\nASK_RETRIES=1\nASK_REDACT=true\n")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.APIKey != "local-key" || cfg.Model != "gpt-4.1" {
+		t.Errorf("Local .env should override global, got key=%s model=%s", cfg.APIKey, cfg.Model)
+	}
+	if cfg.APIKeySource != LocalEnvFile {
+		t.Errorf("APIKeySource = %q, want the local .env", cfg.APIKeySource)
+	}
+	if !cfg.PreserveCodeBlocks || !cfg.Redact || cfg.Retries != 1 {
+		t.Errorf("Local values equal to the defaults should still override global, got code blocks=%v redact=%v retries=%d",
+			cfg.PreserveCodeBlocks, cfg.Redact, cfg.Retries)
+	}
+	if cfg.OS != "Linux" {
+		t.Errorf("Keys only in global should be kept, got OS=%s", cfg.OS)
+	}
+
+	// A profile sits between global and local
+	writeFile(t, filepath.Join(home, GlobalConfigDir, ProfilesDir, "work.env"), "ASK_API_KEY=work-key\nASK_OS=Windows\n")
+	cfg, err = LoadProfile("work")
+	if err != nil {
+		t.Fatalf("LoadProfile failed: %v", err)
+	}
+	if cfg.APIKey != "local-key" || cfg.OS != "Windows" {
+		t.Errorf("Expected local key and profile OS, got key=%s OS=%s", cfg.APIKey, cfg.OS)
+	}
+
+	// Environment variables override both files
+	t.Setenv("ASK_MODEL", "o3-mini")
+	t.Setenv("ASK_RETRIES", "0")
+	cfg, err = Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Model != "o3-mini" || cfg.Retries != 0 {
+		t.Errorf("Environment should override .env files, got model=%s retries=%d", cfg.Model, cfg.Retries)
+	}
+}
+
+func TestBaseDir(t *testing.T) {
+	tests := []struct {
+		name   string
+		xdg    string // Relative to the temp dir unless it starts with "rel:"
+		legacy bool   // ~/.config/ask has files
+		inXDG  bool   // $XDG_CONFIG_HOME/ask has files
+		want   string // "xdg" or "legacy"
+	}{
+		{"XDG unset", "", false, false, "legacy"},
+		{"XDG set, fresh install", "xdg", false, false, "xdg"},
+		{"XDG set, legacy config only", "xdg", true, false, "legacy"},
+		{"XDG set, both configured", "xdg", true, true, "xdg"},
+		{"relative XDG ignored", "rel:xdg", false, false, "legacy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			home := isolateHome(t)
+
+			xdg := ""
+			if rel, ok := strings.CutPrefix(tt.xdg, "rel:"); ok {
+				t.Setenv("XDG_CONFIG_HOME", rel)
+			} else if tt.xdg != "" {
+				xdg = filepath.Join(root, tt.xdg)
+				t.Setenv("XDG_CONFIG_HOME", xdg)
+			} else {
+				t.Setenv("XDG_CONFIG_HOME", "")
+			}
+
+			legacyDir := filepath.Join(home, GlobalConfigDir)
+			if tt.legacy {
+				writeFile(t, filepath.Join(legacyDir, GlobalEnvFile), "ASK_MODEL=gpt-4o\n")
+			}
+			if tt.inXDG {
+				writeFile(t, filepath.Join(xdg, "ask", GlobalEnvFile), "ASK_MODEL=gpt-4o\n")
+			}
+
+			want := legacyDir
+			if tt.want == "xdg" {
+				want = filepath.Join(xdg, "ask")
+			}
+			got, err := BaseDir()
+			if err != nil {
+				t.Fatalf("BaseDir failed: %v", err)
+			}
+			if got != want {
+				t.Errorf("BaseDir() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestOSLabel(t *testing.T) {
+	tests := []struct {
+		goos string
+		want string
+	}{
+		{"darwin", "macOS"},
+		{"linux", "Linux"},
+		{"windows", "Windows"},
+		{"freebsd", "FreeBSD"},
+		{"plan9", "plan9"},
+	}
+
+	for _, tt := range tests {
+		if got := OSLabel(tt.goos); got != tt.want {
+			t.Errorf("OSLabel(%q) = %q, want %q", tt.goos, got, tt.want)
+		}
+	}
+
+	isolateHome(t)
+	t.Chdir(t.TempDir())
+	t.Setenv("ASK_OS", "")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.OS != DefaultOS() {
+		t.Errorf("OS = %q, want the detected %q", cfg.OS, DefaultOS())
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"pretty name", "NAME=\"Ubuntu\"\nVERSION_ID=\"24.04\"\nPRETTY_NAME=\"Ubuntu 24.04 LTS\"\nID=ubuntu\n", "Ubuntu 24.04 LTS"},
+		{"name only", "# comment\nNAME=Arch Linux\nID=arch\n", "Arch Linux"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseOSRelease(strings.NewReader(tt.content)); got != tt.want {
+			t.Errorf("%s: parseOSRelease() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
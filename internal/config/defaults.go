@@ -1,24 +1,148 @@
 package config
 
+import (
+	"runtime"
+	"time"
+)
+
 const (
 	// DefaultModel is the default LLM model to use
 	DefaultModel = "gpt-4o"
 
-	// DefaultOS is the default operating system context
-	DefaultOS = "macOS"
+	// DefaultClaudeModel is the model suggested when ASK_API_URL points at Claude
+	DefaultClaudeModel = "claude-3-5-sonnet-20241022"
 
 	// DefaultAPIURL is the default OpenAI API endpoint
 	DefaultAPIURL = "https://api.openai.com/v1/chat/completions"
 
-	// ContextDir is the directory where context files are stored
-	ContextDir = ".config/ask/contexts"
+	// ProviderOpenAIResponses selects OpenAI's Responses API (ASK_API_PROVIDER)
+	ProviderOpenAIResponses = "openai-responses"
+
+	// DefaultUserAgent identifies requests from ask when no version is known
+	DefaultUserAgent = "ask"
+
+	// DefaultAnalyzeMaxFiles is how many directory entries analysis examines
+	// before it stops walking
+	DefaultAnalyzeMaxFiles = 5000
+
+	// DefaultAnalyzeMaxPerDir is how many entries of one directory analysis
+	// lists before summarizing the rest as "... and N more"
+	DefaultAnalyzeMaxPerDir = 100
+
+	// DefaultKeepRecent is how many recent exchanges pruning always keeps
+	DefaultKeepRecent = 2
+
+	// DefaultPruneModel is the cheaper model AI-driven pruning uses on
+	// OpenAI's API when ASK_PRUNE_MODEL isn't set
+	DefaultPruneModel = "gpt-4o-mini"
+
+	// DefaultEmbeddingsModel is the model used with ASK_EMBEDDINGS_URL
+	DefaultEmbeddingsModel = "text-embedding-3-small"
+
+	// DefaultPreserveCodeBlocks controls whether messages with code blocks survive pruning
+	DefaultPreserveCodeBlocks = true
+
+	// DefaultRetries is how many times a failed API request is retried
+	DefaultRetries = 2
 
-	// GlobalConfigDir is the directory for global configuration
+	// DefaultRedact controls whether secrets are redacted from messages before saving
+	DefaultRedact = false
+
+	// DefaultScanInjection controls whether directory analysis flags instruction-like text
+	DefaultScanInjection = true
+
+	// ContextDir is the directory where context files are stored, relative to BaseDir
+	ContextDir = "contexts"
+
+	// ContextDatabase is the database contexts are stored in with ASK_STORE=sqlite, relative to BaseDir
+	ContextDatabase = "contexts.db"
+
+	// ResponseCacheDir is the directory where cached responses are stored, relative to BaseDir
+	ResponseCacheDir = "contexts/responses"
+
+	// DefaultResponseCacheTTL is how long a cached response is reused
+	DefaultResponseCacheTTL = 24 * time.Hour
+
+	// BlobsDir is where large messages offloaded from contexts are stored, relative to BaseDir
+	BlobsDir = "contexts/blobs"
+
+	// GlobalConfigDir is the directory for global configuration relative to
+	// the home directory, used when XDG_CONFIG_HOME is unset (see BaseDir)
 	GlobalConfigDir = ".config/ask"
 
+	// PersonasDir is the directory for custom persona definitions (*.md), relative to BaseDir
+	PersonasDir = "personas"
+
+	// TemplatesDir is the directory for query templates (*.txt), relative to BaseDir
+	TemplatesDir = "templates"
+
+	// ExamplesFile is a project's few-shot examples, relative to its directory
+	ExamplesFile = ".ask/examples.json"
+
+	// ToolsFile is a project's tool definitions for --tools, relative to its directory
+	ToolsFile = ".ask/tools.json"
+
+	// ProfilesDir is the directory for named configuration profiles (<name>.env), relative to BaseDir
+	ProfilesDir = "profiles"
+
 	// GlobalEnvFile is the filename for global environment config
 	GlobalEnvFile = ".env"
 
 	// LocalEnvFile is the filename for local environment config
 	LocalEnvFile = ".env"
 )
+
+// Output format presets (ASK_FORMAT, --format)
+const (
+	FormatPlain    = "plain"    // Plain terminal text (the default)
+	FormatMarkdown = "markdown" // Markdown allowed
+	FormatJSON     = "json"     // Plain answer printed inside a JSON result
+	FormatShell    = "shell"    // One runnable command, nothing else
+)
+
+// Formats lists the output format presets
+var Formats = []string{FormatPlain, FormatMarkdown, FormatJSON, FormatShell}
+
+// Analysis inclusion policies (ASK_ANALYSIS_MODE)
+const (
+	AnalysisAlways = "always" // Send the analysis with every query (the default)
+	AnalysisAuto   = "auto"   // Send it only when the query seems to be about the codebase
+	AnalysisNever  = "never"  // Keep the analysis cached but never send it
+)
+
+// AnalysisModes lists the analysis inclusion policies
+var AnalysisModes = []string{AnalysisAlways, AnalysisAuto, AnalysisNever}
+
+// Context storage backends (ASK_STORE)
+const (
+	StoreFile   = "file"   // One JSON file per context under ContextDir (the default)
+	StoreSQLite = "sqlite" // One SQLite database, ContextDatabase
+)
+
+// Stores lists the context storage backends
+var Stores = []string{StoreFile, StoreSQLite}
+
+// osLabels maps runtime.GOOS values to the names used in prompts
+var osLabels = map[string]string{
+	"darwin":  "macOS",
+	"linux":   "Linux",
+	"windows": "Windows",
+	"freebsd": "FreeBSD",
+	"openbsd": "OpenBSD",
+	"netbsd":  "NetBSD",
+}
+
+// DefaultOS returns the operating system context for the platform ask is
+// running on, e.g. "Linux"
+func DefaultOS() string {
+	return OSLabel(runtime.GOOS)
+}
+
+// OSLabel returns the human-readable name for a runtime.GOOS value, or the
+// value itself if it has none
+func OSLabel(goos string) string {
+	if label, ok := osLabels[goos]; ok {
+		return label
+	}
+	return goos
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BaseDir returns the directory holding ask's global configuration,
+// contexts, and caches: $XDG_CONFIG_HOME/ask when XDG_CONFIG_HOME is set to
+// an absolute path, otherwise ~/.config/ask. While the XDG directory is
+// missing or empty, an existing ~/.config/ask is still used, so setting
+// XDG_CONFIG_HOME doesn't strand earlier settings and contexts.
+func BaseDir() (string, error) {
+	legacy := ""
+	homeDir, homeErr := os.UserHomeDir()
+	if homeErr == nil {
+		legacy = filepath.Join(homeDir, GlobalConfigDir)
+	}
+
+	// The XDG spec says relative paths are invalid and should be ignored
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(xdg) {
+		dir := filepath.Join(xdg, "ask")
+		if legacy == "" || dir == legacy || !isEmptyDir(dir) || isEmptyDir(legacy) {
+			return dir, nil
+		}
+		return legacy, nil
+	}
+
+	if homeErr != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", homeErr)
+	}
+	return legacy, nil
+}
+
+// isEmptyDir reports whether dir is missing or has no entries
+func isEmptyDir(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return err != nil || len(entries) == 0
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// osReleasePath is where Linux distributions describe themselves
+const osReleasePath = "/etc/os-release"
+
+// DefaultShell returns the name of the user's login shell from $SHELL, e.g.
+// "zsh", or "" if it isn't set
+func DefaultShell() string {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		return ""
+	}
+	return filepath.Base(shell)
+}
+
+// DefaultDistro returns the Linux distribution from /etc/os-release, e.g.
+// "Ubuntu 24.04 LTS", or "" on other platforms or if it can't be read
+func DefaultDistro() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	file, err := os.Open(osReleasePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	return parseOSRelease(file)
+}
+
+// parseOSRelease returns PRETTY_NAME from an os-release file, falling back
+// to NAME
+func parseOSRelease(r io.Reader) string {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		fields[key] = strings.Trim(value, `"'`)
+	}
+
+	if name := fields["PRETTY_NAME"]; name != "" {
+		return name
+	}
+	return fields["NAME"]
+}
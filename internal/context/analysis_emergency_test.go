@@ -50,9 +50,16 @@ func TestEmergencyPruneWithHugeAnalysisCache(t *testing.T) {
 	finalTokens := store.EstimateTokens()
 	t.Logf("Final tokens after emergency prune: %d", finalTokens)
 
-	// Analysis cache should be cleared
-	if store.AnalysisCache != nil {
-		t.Error("Analysis cache should have been cleared")
+	// Analysis cache should be shrunk, not cleared: README dropped, tree shortened
+	if store.AnalysisCache == nil {
+		t.Fatal("Analysis cache should have been shrunk, not cleared")
+	}
+	if store.AnalysisCache.ReadmeContent != "" {
+		t.Error("README should have been dropped first")
+	}
+	if !strings.HasPrefix(hugeFileTree, strings.TrimSuffix(store.AnalysisCache.FileTree, analysisShrunkNote)) ||
+		!strings.HasSuffix(store.AnalysisCache.FileTree, analysisShrunkNote) {
+		t.Error("File tree should be a prefix of the original ending in the shortened note")
 	}
 
 	// Should be dramatically reduced
@@ -60,9 +67,122 @@ func TestEmergencyPruneWithHugeAnalysisCache(t *testing.T) {
 		t.Errorf("Tokens not reduced enough: %d -> %d", initialTokens, finalTokens)
 	}
 
-	// Should be under emergency limits
-	if finalTokens > 37500 {
-		t.Errorf("Still over emergency limits after pruning: %d tokens", finalTokens)
+	// Should fit under the hard limit, leaving room for the conversation
+	if finalTokens > DefaultPruningLimits().MaxTokens {
+		t.Errorf("Still over the hard limit after pruning: %d tokens", finalTokens)
+	}
+}
+
+func TestEmergencyPruneGraduated(t *testing.T) {
+	tree := strings.Repeat("src/file.go\n", 500) // ~2k tokens
+	cfg := &config.Config{Model: "test", OS: "macOS", APIURL: "http://test", APIKey: "test"}
+
+	tests := []struct {
+		name        string
+		readme      string
+		messages    int // ~750-token messages
+		wantCleared bool
+		wantReadme  bool
+		wantTree    string
+	}{
+		{"dropping the README is enough", strings.Repeat("Documentation line.\n", 8000), 0, false, false, tree},
+		{"no room left beside the messages", strings.Repeat("Documentation line.\n", 8000), 40, true, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore("/test/dir")
+			for i := 0; i < tt.messages; i++ {
+				store.AddMessage("user", strings.Repeat("word ", 520))
+			}
+			store.AnalysisCache = &AnalysisCache{FileTree: tree, ReadmeContent: tt.readme, PrimaryConfigs: []string{"go.mod"}}
+			manager := &Manager{store: store, config: cfg}
+
+			if err := manager.checkEmergencyPrune(); err != nil {
+				t.Fatalf("checkEmergencyPrune failed: %v", err)
+			}
+
+			cache := store.AnalysisCache
+			if (cache == nil) != tt.wantCleared {
+				t.Fatalf("Analysis cleared = %v, want %v", cache == nil, tt.wantCleared)
+			}
+			if cache == nil {
+				return
+			}
+			if (cache.ReadmeContent != "") != tt.wantReadme {
+				t.Errorf("README kept = %v, want %v", cache.ReadmeContent != "", tt.wantReadme)
+			}
+			if cache.FileTree != tt.wantTree {
+				t.Errorf("File tree changed (%d chars), want it intact (%d chars)", len(cache.FileTree), len(tt.wantTree))
+			}
+		})
+	}
+}
+
+func TestEmergencyPruneEphemeralAnalysis(t *testing.T) {
+	readme := strings.Repeat("Documentation line.\n", 8000)
+	cfg := &config.Config{Model: "test", OS: "macOS", APIURL: "http://test", APIKey: "test"}
+
+	tests := []struct {
+		name        string
+		messages    int // ~750-token messages
+		wantCleared bool
+	}{
+		{"shrinks the analysis in the request", 0, false},
+		{"clears the stored analysis with it", 40, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore("/test/dir")
+			for i := 0; i < tt.messages; i++ {
+				store.AddMessage("user", strings.Repeat("word ", 520))
+			}
+			store.AnalysisCache = &AnalysisCache{FileTree: "main.go\n", ReadmeContent: readme}
+			manager := &Manager{store: store, config: cfg}
+			manager.ephemeralAnalysis = &AnalysisCache{FileTree: "main.go\n", ReadmeContent: readme}
+
+			if err := manager.checkEmergencyPrune(); err != nil {
+				t.Fatalf("checkEmergencyPrune failed: %v", err)
+			}
+
+			if tt.wantCleared {
+				if manager.ephemeralAnalysis != nil || store.AnalysisCache != nil {
+					t.Errorf("Analysis left in place (ephemeral %v, stored %v), want both cleared",
+						manager.ephemeralAnalysis != nil, store.AnalysisCache != nil)
+				}
+			} else if manager.ephemeralAnalysis == nil || manager.ephemeralAnalysis.ReadmeContent != "" {
+				t.Error("Ephemeral analysis should have been shrunk")
+			}
+			if tokens := manager.RequestTokens(); tokens > 37500 {
+				t.Errorf("Request still over emergency limits after pruning: %d tokens", tokens)
+			}
+		})
+	}
+}
+
+func TestShrinkAnalysis(t *testing.T) {
+	tree := strings.Repeat("src/file.go\n", 2000) // ~7k tokens
+	store := NewStore("/test/dir")
+	store.AnalysisCache = &AnalysisCache{FileTree: tree, ReadmeContent: "# Project\n", PrimaryConfigs: []string{"go.mod"}}
+	manager := &Manager{store: store}
+
+	if manager.shrinkAnalysis(MinAnalysisTokens - 1) {
+		t.Fatal("shrinkAnalysis should refuse a budget below MinAnalysisTokens")
+	}
+	if store.AnalysisCache.ReadmeContent == "" || store.AnalysisCache.FileTree != tree {
+		t.Fatal("A refused shrink should change nothing")
+	}
+
+	if !manager.shrinkAnalysis(1000) {
+		t.Fatal("shrinkAnalysis(1000) should succeed")
+	}
+	if got := manager.estimateAnalysisCacheTokens(); got > 1000 {
+		t.Errorf("Shrunk analysis is %d tokens, want at most 1000", got)
+	}
+	shortened := strings.TrimSuffix(store.AnalysisCache.FileTree, analysisShrunkNote)
+	if !strings.HasPrefix(tree, shortened) || !strings.HasSuffix(shortened, "\n") {
+		t.Errorf("Tree should be cut at a line boundary, got ...%q", shortened[max(0, len(shortened)-30):])
 	}
 }
 
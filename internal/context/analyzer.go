@@ -6,7 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
+
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/internal/logging"
 )
 
 // ConfigFiles are common configuration files to detect
@@ -36,9 +38,15 @@ var ReadmeFiles = []string{
 type Analyzer struct {
 	rootDir      string
 	gitignore    *GitignoreParser
+	filter       PathFilter
 	maxDepth     int
 	maxFileSize  int64
 	maxReadmeLen int
+	maxFiles     int    // Entries examined before the walk stops
+	maxPerDir    int    // Entries listed per directory before the rest are summarized
+	seen         int    // Entries examined so far
+	binaries     string // BinariesList, BinariesAnnotate, or BinariesSkip
+	scan         bool   // Flag instruction-like text in the README and tree
 }
 
 // NewAnalyzer creates a new directory analyzer
@@ -48,42 +56,212 @@ func NewAnalyzer(rootDir string) *Analyzer {
 		maxDepth:     2,          // Only descend 2 levels (reduced from 3)
 		maxFileSize:  1024 * 50,  // Skip files > 50KB for tree
 		maxReadmeLen: 5000,       // Max 5KB of README content
+		maxFiles:     config.DefaultAnalyzeMaxFiles,
+		maxPerDir:    config.DefaultAnalyzeMaxPerDir,
+	}
+}
+
+// SetMaxFiles caps how many directory entries the walk examines before
+// stopping, bounding analysis time on huge repositories. 0 keeps the default.
+func (a *Analyzer) SetMaxFiles(n int) {
+	if n > 0 {
+		a.maxFiles = n
+	}
+}
+
+// SetMaxPerDir caps how many entries of a single directory are listed, so
+// one huge directory doesn't crowd out the rest of the tree. 0 keeps the default.
+func (a *Analyzer) SetMaxPerDir(n int) {
+	if n > 0 {
+		a.maxPerDir = n
+	}
+}
+
+// FileNode is a file or directory in the analyzed tree
+type FileNode struct {
+	Name     string      `json:"name"`
+	IsDir    bool        `json:"is_dir"`
+	Size     int64       `json:"size,omitempty"`   // Files only
+	Binary   bool        `json:"binary,omitempty"` // Set only when binaries are annotated
+	Children []*FileNode `json:"children,omitempty"`
+	Omitted  int         `json:"omitted,omitempty"` // Entries left out by the per-directory cap
+}
+
+// Analysis is the structured result of analyzing a directory
+type Analysis struct {
+	Root        *FileNode `json:"root"`
+	Readme      string    `json:"readme,omitempty"`
+	Configs     []string  `json:"configs"`
+	ProjectType string    `json:"project_type,omitempty"` // e.g. "go", "node"; empty if unknown
+	StoppedAt   int       `json:"stopped_at,omitempty"`   // Entry limit the walk stopped at; 0 if it finished
+}
+
+// projectTypes maps configuration files to project types, in priority order
+var projectTypes = []struct {
+	file        string
+	projectType string
+}{
+	{"go.mod", "go"},
+	{"Cargo.toml", "rust"},
+	{"package.json", "node"},
+	{"pyproject.toml", "python"},
+	{"requirements.txt", "python"},
+	{"pom.xml", "java"},
+	{"build.gradle", "java"},
+}
+
+// PathFilter adjusts which paths analysis includes. Patterns are globs
+// relative to the analyzed directory ("**" matches any number of directories);
+// a pattern without "/" matches a name at any depth, and a matching directory
+// covers everything beneath it.
+//
+// Precedence: Include beats Exclude, which beats hidden files, .gitignore,
+// and the common ignores (node_modules, vendor, ...).
+type PathFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Included reports whether path (slash-separated, relative) is force-included
+func (f PathFilter) Included(path string) bool {
+	return matchesAny(f.Include, path)
+}
+
+// Excluded reports whether path (slash-separated, relative) is excluded
+func (f PathFilter) Excluded(path string) bool {
+	return matchesAny(f.Exclude, path)
+}
+
+// mayIncludeBelow reports whether an include pattern could match something
+// inside directory dir, so an otherwise ignored directory is still walked
+func (f PathFilter) mayIncludeBelow(dir string) bool {
+	dirSegments := strings.Split(dir, "/")
+	for _, pattern := range f.Include {
+		if !strings.Contains(pattern, "/") {
+			return true // Matches names at any depth
+		}
+		if matchPrefix(strings.Split(pattern, "/"), dirSegments) {
+			return true
+		}
 	}
+	return false
+}
+
+// matchesAny reports whether path or one of its parent directories matches a pattern
+func matchesAny(patterns []string, path string) bool {
+	segments := strings.Split(path, "/")
+	for _, pattern := range patterns {
+		pattern = strings.Trim(pattern, "/")
+		for i := 1; i <= len(segments); i++ {
+			if globMatch(pattern, strings.Join(segments[:i], "/")) {
+				return true
+			}
+			if !strings.Contains(pattern, "/") {
+				if ok, _ := filepath.Match(pattern, segments[i-1]); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// matchPrefix reports whether path could be a directory on the way to a
+// match of pattern
+func matchPrefix(pattern, path []string) bool {
+	for len(path) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return true
+}
+
+// SetFilter sets include/exclude patterns consulted alongside .gitignore
+func (a *Analyzer) SetFilter(filter PathFilter) {
+	a.filter = filter
+}
+
+// SetBinaries sets how binary files appear in the tree: BinariesList,
+// BinariesAnnotate, or BinariesSkip
+func (a *Analyzer) SetBinaries(mode string) {
+	a.binaries = mode
+}
+
+// SetScanInjection sets whether Analyze flags README and file tree content
+// that looks like instructions to the model
+func (a *Analyzer) SetScanInjection(scan bool) {
+	a.scan = scan
 }
 
 // Analyze performs directory analysis and returns the cache
 func (a *Analyzer) Analyze() (*AnalysisCache, error) {
+	analysis, err := a.Scan()
+	if err != nil {
+		return nil, err
+	}
+
+	cache := analysis.Cache()
+	if a.scan {
+		cache.Untrusted = ScanAnalysis(cache)
+	}
+	return cache, nil
+}
+
+// Scan performs directory analysis and returns the structured result
+func (a *Analyzer) Scan() (*Analysis, error) {
 	// Parse .gitignore if it exists
 	a.gitignore = NewGitignoreParser(a.rootDir)
 	_ = a.gitignore.Parse() // .gitignore is optional, ignore errors
 
-	// Generate file tree
-	tree, err := a.generateFileTree()
-	if err != nil {
+	// Build file tree
+	a.seen = 0
+	root := &FileNode{Name: filepath.Base(a.rootDir), IsDir: true}
+	if err := a.walkDirectory("", 0, root); err != nil {
 		return nil, fmt.Errorf("failed to generate file tree: %w", err)
 	}
 
-	// Find and read README
-	readme := a.findReadme()
-
 	// Detect config files
 	configs := a.detectConfigFiles()
 
+	analysis := &Analysis{
+		Root:        root,
+		Readme:      a.findReadme(),
+		Configs:     configs,
+		ProjectType: detectProjectType(configs),
+	}
+	if a.seen > a.maxFiles {
+		analysis.StoppedAt = a.maxFiles
+	}
+	return analysis, nil
+}
+
+// Cache converts the analysis to the form stored in the context
+func (an *Analysis) Cache() *AnalysisCache {
+	tree := an.Root.Render()
+	if an.StoppedAt > 0 {
+		tree += fmt.Sprintf("\n[Analysis stopped at %d files (ASK_ANALYZE_MAX_FILES); the tree is incomplete]", an.StoppedAt)
+	}
 	return &AnalysisCache{
 		FileTree:       tree,
-		ReadmeContent:  readme,
-		PrimaryConfigs: configs,
-	}, nil
+		ReadmeContent:  an.Readme,
+		PrimaryConfigs: an.Configs,
+	}
 }
 
-// generateFileTree creates a tree representation of the directory
-func (a *Analyzer) generateFileTree() (string, error) {
+// Render returns an indented text representation of the tree,
+// truncated to keep the prompt small
+func (n *FileNode) Render() string {
 	var builder strings.Builder
-	builder.WriteString(filepath.Base(a.rootDir) + "/\n")
-
-	if err := a.walkDirectory("", 0, &builder); err != nil {
-		return "", err
-	}
+	builder.WriteString(n.Name + "/\n")
+	n.renderChildren(1, &builder)
 
 	tree := builder.String()
 
@@ -93,11 +271,42 @@ func (a *Analyzer) generateFileTree() (string, error) {
 		tree = tree[:maxTreeSize] + "\n\n[File tree truncated - project too large]\n[Tip: Use 'ask' without --analyze for less context]"
 	}
 
-	return tree, nil
+	return tree
 }
 
-// walkDirectory recursively walks the directory structure
-func (a *Analyzer) walkDirectory(relPath string, depth int, builder *strings.Builder) error {
+// renderChildren writes each child at the given indentation level
+func (n *FileNode) renderChildren(level int, builder *strings.Builder) {
+	indent := strings.Repeat("  ", level)
+	for _, child := range n.Children {
+		if child.IsDir {
+			builder.WriteString(fmt.Sprintf("%s%s/\n", indent, displayName(child.Name)))
+			child.renderChildren(level+1, builder)
+		} else if child.Binary {
+			builder.WriteString(fmt.Sprintf("%s%s [binary]\n", indent, displayName(child.Name)))
+		} else {
+			builder.WriteString(fmt.Sprintf("%s%s\n", indent, displayName(child.Name)))
+		}
+	}
+	if n.Omitted > 0 {
+		builder.WriteString(fmt.Sprintf("%s... and %d more\n", indent, n.Omitted))
+	}
+}
+
+// maxNameLen is the longest file name shown in full in the rendered tree
+const maxNameLen = 120
+
+// displayName shortens absurdly long (usually generated) file names so a
+// single entry can't take over the tree budget, without splitting a
+// multi-byte character
+func displayName(name string) string {
+	if len(name) <= maxNameLen {
+		return name
+	}
+	return truncateBytes(name, maxNameLen) + "..."
+}
+
+// walkDirectory recursively adds the directory's entries to node
+func (a *Analyzer) walkDirectory(relPath string, depth int, node *FileNode) error {
 	if depth > a.maxDepth {
 		return nil
 	}
@@ -112,33 +321,77 @@ func (a *Analyzer) walkDirectory(relPath string, depth int, builder *strings.Bui
 		name := entry.Name()
 		entryPath := filepath.Join(relPath, name)
 
-		// Skip hidden files and gitignored paths
-		if strings.HasPrefix(name, ".") && name != ".env.example" {
-			continue
+		// Skip hidden files and gitignored paths unless explicitly included.
+		// An ignored directory is still walked if an include pattern may match
+		// inside it, and kept only if something was.
+		slashPath := filepath.ToSlash(entryPath)
+		included := a.filter.Included(slashPath)
+		partial := false
+		if !included {
+			hidden := strings.HasPrefix(name, ".") && name != ".env.example"
+			if a.filter.Excluded(slashPath) || hidden || a.gitignore.IsIgnored(entryPath) {
+				if !entry.IsDir() || !a.filter.mayIncludeBelow(slashPath) {
+					continue
+				}
+				partial = true
+			}
 		}
 
-		if a.gitignore.IsIgnored(entryPath) {
+		// Past the per-directory cap, only count the rest, without spending
+		// the walk's entry budget on them
+		if len(node.Children) >= a.maxPerDir {
+			node.Omitted++
 			continue
 		}
 
-		// Add indentation
-		indent := strings.Repeat("  ", depth+1)
+		// Stop the whole walk once the entry budget is spent; seen passing
+		// maxFiles records that something was left out
+		a.seen++
+		if a.seen > a.maxFiles {
+			return nil
+		}
+
 		if entry.IsDir() {
-			builder.WriteString(fmt.Sprintf("%s%s/\n", indent, name))
+			child := &FileNode{Name: name, IsDir: true}
 			// Recurse into directory
-			_ = a.walkDirectory(entryPath, depth+1, builder) // Ignore errors in subdirectories
+			_ = a.walkDirectory(entryPath, depth+1, child) // Ignore errors in subdirectories
+			if !partial || len(child.Children) > 0 {
+				node.Children = append(node.Children, child)
+			}
 		} else {
 			// Check file size
 			info, err := entry.Info()
-			if err == nil && info.Size() < a.maxFileSize {
-				builder.WriteString(fmt.Sprintf("%s%s\n", indent, name))
+			if err != nil || info.Size() >= a.maxFileSize {
+				continue
 			}
+			file := &FileNode{Name: name, Size: info.Size()}
+			if a.binaries != BinariesList {
+				if binary, _ := IsBinaryFile(filepath.Join(a.rootDir, entryPath)); binary {
+					if a.binaries == BinariesSkip {
+						continue
+					}
+					file.Binary = true
+				}
+			}
+			node.Children = append(node.Children, file)
 		}
 	}
 
 	return nil
 }
 
+// detectProjectType guesses the project type from its configuration files
+func detectProjectType(configs []string) string {
+	for _, pt := range projectTypes {
+		for _, cfg := range configs {
+			if cfg == pt.file {
+				return pt.projectType
+			}
+		}
+	}
+	return ""
+}
+
 // findReadme looks for and reads a README file
 func (a *Analyzer) findReadme() string {
 	for _, filename := range ReadmeFiles {
@@ -265,17 +518,57 @@ func matchPattern(path, pattern string) bool {
 	return path == pattern || strings.Contains(path, "/"+pattern) || strings.HasPrefix(path, pattern+"/")
 }
 
-// AnalyzeDirectory is a convenience function to analyze the current directory
-func AnalyzeDirectory(store *Store) error {
-	analyzer := NewAnalyzer(store.Directory)
+// AnalyzeDirectory runs analyzer and stores the result as the store's analysis
+func AnalyzeDirectory(store *Store, analyzer *Analyzer) error {
 	cache, err := analyzer.Analyze()
 	if err != nil {
 		return err
 	}
 
+	if len(cache.Untrusted) > 0 {
+		logging.Infof("⚠️  Warning: The project's %s contains instruction-like text; it will be sent as untrusted content\n", strings.Join(cache.Untrusted, " and "))
+	}
+
 	store.AnalysisCache = cache
-	now := time.Now()
+	now := nowFunc()
 	store.LastAnalysisAt = &now
 
 	return nil
 }
+
+// FindAncestorAnalysis returns the context store of the nearest ancestor
+// directory (up to the git root) that has cached analysis, or nil if none.
+// Directories outside a git repository never share analysis.
+// key decrypts encrypted ancestor contexts and may be nil.
+func FindAncestorAnalysis(directory string, key *Key) *Store {
+	gitRoot := findGitRoot(directory)
+	if gitRoot == "" || gitRoot == directory {
+		return nil
+	}
+
+	dir := directory
+	for dir != gitRoot {
+		dir = filepath.Dir(dir)
+		store, err := Load(dir, key)
+		if err == nil && store.AnalysisCache != nil {
+			return store
+		}
+	}
+
+	return nil
+}
+
+// findGitRoot walks up from directory to the nearest directory containing .git
+func findGitRoot(directory string) string {
+	dir := directory
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
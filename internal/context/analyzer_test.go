@@ -1,9 +1,12 @@
 package context
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestAnalyzerFileTree(t *testing.T) {
@@ -92,9 +95,279 @@ dist/
 	}
 }
 
+func TestFindAncestorAnalysis(t *testing.T) {
+	isolateHome(t)
+
+	repoDir := t.TempDir()
+	subDir := filepath.Join(repoDir, "cmd", "foo")
+	_ = os.MkdirAll(filepath.Join(repoDir, ".git"), 0755)
+	_ = os.MkdirAll(subDir, 0755)
+
+	// No ancestor analyzed yet
+	if store := FindAncestorAnalysis(subDir, nil); store != nil {
+		t.Fatalf("Expected no ancestor analysis, got %s", store.Directory)
+	}
+
+	// Analyze the repo root
+	root := NewStore(repoDir)
+	root.AnalysisCache = &AnalysisCache{FileTree: "repo/\n"}
+	if err := root.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	store := FindAncestorAnalysis(subDir, nil)
+	if store == nil {
+		t.Fatal("Expected to find repo root analysis")
+	}
+	if store.Directory != repoDir {
+		t.Errorf("Ancestor directory = %s, want %s", store.Directory, repoDir)
+	}
+
+	// The repo root itself has no ancestor to share from
+	if store := FindAncestorAnalysis(repoDir, nil); store != nil {
+		t.Errorf("Repo root should not share analysis, got %s", store.Directory)
+	}
+
+	// Directories outside a git repository never share
+	if store := FindAncestorAnalysis(t.TempDir(), nil); store != nil {
+		t.Errorf("Expected no sharing outside git repository, got %s", store.Directory)
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
 	}
 	return b
 }
+
+func TestAnalyzerScan(t *testing.T) {
+	tmpDir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module test"), 0644)
+	_ = os.WriteFile(filepath.Join(tmpDir, "package.json"), []byte("{}"), 0644)
+	_ = os.MkdirAll(filepath.Join(tmpDir, "src", "pkg"), 0755)
+	_ = os.WriteFile(filepath.Join(tmpDir, "src", "main.go"), []byte("package main"), 0644)
+	_ = os.MkdirAll(filepath.Join(tmpDir, "node_modules", "lib"), 0755)
+
+	analysis, err := NewAnalyzer(tmpDir).Scan()
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	if analysis.ProjectType != "go" {
+		t.Errorf("ProjectType = %q, want go (go.mod wins over package.json)", analysis.ProjectType)
+	}
+
+	// Root children are sorted by name; node_modules is ignored
+	root := analysis.Root
+	if !root.IsDir || len(root.Children) != 3 {
+		t.Fatalf("Root should have 3 children, got %+v", root.Children)
+	}
+	src := root.Children[2]
+	if src.Name != "src" || !src.IsDir || len(src.Children) != 2 {
+		t.Fatalf("Expected src/ with 2 children, got %+v", src)
+	}
+	if main := src.Children[0]; main.Name != "main.go" || main.IsDir || main.Size != int64(len("package main")) {
+		t.Errorf("main.go node = %+v", main)
+	}
+
+	want := filepath.Base(tmpDir) + "/\n  go.mod\n  package.json\n  src/\n    main.go\n    pkg/\n"
+	if got := root.Render(); got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The cached form uses the same rendering
+	if cache := analysis.Cache(); cache.FileTree != want {
+		t.Errorf("Cache().FileTree = %q, want rendered tree", cache.FileTree)
+	}
+}
+
+func TestAnalyzerMaxFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	for i := range 10 {
+		_ = os.WriteFile(filepath.Join(tmpDir, fmt.Sprintf("f%02d.txt", i)), []byte("x"), 0644)
+	}
+
+	tests := []struct {
+		name      string
+		maxFiles  int
+		wantNodes int
+		stoppedAt int
+	}{
+		{"under the cap", 20, 10, 0},
+		{"exactly the cap", 10, 10, 0},
+		{"over the cap", 4, 4, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analyzer := NewAnalyzer(tmpDir)
+			analyzer.SetMaxFiles(tt.maxFiles)
+			analysis, err := analyzer.Scan()
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if got := len(analysis.Root.Children); got != tt.wantNodes {
+				t.Errorf("got %d nodes, want %d", got, tt.wantNodes)
+			}
+			if analysis.StoppedAt != tt.stoppedAt {
+				t.Errorf("StoppedAt = %d, want %d", analysis.StoppedAt, tt.stoppedAt)
+			}
+			footer := fmt.Sprintf("[Analysis stopped at %d files", tt.maxFiles)
+			if got := strings.Contains(analysis.Cache().FileTree, footer); got != (tt.stoppedAt > 0) {
+				t.Errorf("footer present = %v, want %v:\n%s", got, tt.stoppedAt > 0, analysis.Cache().FileTree)
+			}
+		})
+	}
+}
+
+func TestAnalyzerMaxPerDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(tmpDir, "assets"), 0755)
+	for i := range 10 {
+		_ = os.WriteFile(filepath.Join(tmpDir, "assets", fmt.Sprintf("a%02d.min.js", i)), []byte("x"), 0644)
+	}
+	_ = os.WriteFile(filepath.Join(tmpDir, "main.go"), []byte("package main"), 0644)
+	long := strings.Repeat("x", 200)
+	_ = os.WriteFile(filepath.Join(tmpDir, long), []byte("x"), 0644)
+
+	analyzer := NewAnalyzer(tmpDir)
+	analyzer.SetMaxPerDir(3)
+	analyzer.SetMaxFiles(6) // Enough for the listed entries only
+	analysis, err := analyzer.Scan()
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	if analysis.StoppedAt != 0 {
+		t.Error("entries past the per-directory cap shouldn't spend the walk's budget")
+	}
+	want := filepath.Base(tmpDir) + "/\n  assets/\n    a00.min.js\n    a01.min.js\n    a02.min.js\n    ... and 7 more\n  main.go\n  " + long[:maxNameLen] + "...\n"
+	if got := analysis.Root.Render(); got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestIsBinaryFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"main.go", "package main\n", false},
+		{"notes.txt", strings.Repeat("text ", 500), false},
+		{"app", "\x7fELF\x02\x01\x00\x00", true},
+		{"late-null.dat", strings.Repeat("a", BinarySniffBytes) + "\x00", false},
+		{"logo.PNG", "extension decides", true},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			_ = os.WriteFile(path, []byte(tt.content), 0644)
+			got, err := IsBinaryFile(path)
+			if err != nil {
+				t.Fatalf("IsBinaryFile failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("IsBinaryFile(%s) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzerBinaries(t *testing.T) {
+	tmpDir := t.TempDir()
+	_ = os.WriteFile(filepath.Join(tmpDir, "main.go"), []byte("package main"), 0644)
+	_ = os.WriteFile(filepath.Join(tmpDir, "app"), []byte("\x7fELF\x00"), 0755)
+	root := filepath.Base(tmpDir) + "/\n"
+
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{BinariesList, root + "  app\n  main.go\n"},
+		{BinariesAnnotate, root + "  app [binary]\n  main.go\n"},
+		{BinariesSkip, root + "  main.go\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			analyzer := NewAnalyzer(tmpDir)
+			analyzer.SetBinaries(tt.mode)
+			analysis, err := analyzer.Scan()
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+			if got := analysis.Root.Render(); got != tt.want {
+				t.Errorf("Render() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzerPathFilterPrecedence(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, path := range []string{
+		"go.mod",
+		"src/main.go",
+		"testdata/big.txt",
+		"testdata/keep.go",
+		"vendor/acme/client.go",
+		"vendor/other/lib.go",
+		".github/ci.yml",
+		"gen/out.go",
+	} {
+		_ = os.MkdirAll(filepath.Join(tmpDir, filepath.Dir(path)), 0755)
+		_ = os.WriteFile(filepath.Join(tmpDir, path), []byte("x"), 0644)
+	}
+	_ = os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("gen/\n"), 0644)
+
+	tests := []struct {
+		name   string
+		filter PathFilter
+		want   string
+	}{
+		{"defaults", PathFilter{},
+			"  go.mod\n  src/\n    main.go\n  testdata/\n    big.txt\n    keep.go\n"},
+		{"exclude directory", PathFilter{Exclude: []string{"testdata"}},
+			"  go.mod\n  src/\n    main.go\n"},
+		{"exclude glob", PathFilter{Exclude: []string{"**/*.txt"}},
+			"  go.mod\n  src/\n    main.go\n  testdata/\n    keep.go\n"},
+		{"include overrides common ignores", PathFilter{Include: []string{"vendor/acme"}},
+			"  go.mod\n  src/\n    main.go\n  testdata/\n    big.txt\n    keep.go\n  vendor/\n    acme/\n      client.go\n"},
+		{"include overrides gitignore and hidden", PathFilter{Include: []string{"gen", ".github"}},
+			"  .github/\n    ci.yml\n  gen/\n    out.go\n  go.mod\n  src/\n    main.go\n  testdata/\n    big.txt\n    keep.go\n"},
+		{"include overrides exclude", PathFilter{Include: []string{"testdata/keep.go"}, Exclude: []string{"testdata"}},
+			"  go.mod\n  src/\n    main.go\n  testdata/\n    keep.go\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			analyzer := NewAnalyzer(tmpDir)
+			analyzer.SetFilter(tt.filter)
+			analysis, err := analyzer.Scan()
+			if err != nil {
+				t.Fatalf("Scan failed: %v", err)
+			}
+
+			want := filepath.Base(tmpDir) + "/\n" + tt.want
+			if got := analysis.Root.Render(); got != want {
+				t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDisplayNameKeepsRunes(t *testing.T) {
+	name := "a" + strings.Repeat("é", maxNameLen) // A 2-byte rune straddles the limit
+	got := displayName(name)
+	if !utf8.ValidString(got) || !strings.HasSuffix(got, "...") || len(got) > maxNameLen+len("...") {
+		t.Errorf("displayName() = %q, want a valid name of at most %d bytes plus ...", got, maxNameLen)
+	}
+	if short := "日本語.go"; displayName(short) != short {
+		t.Errorf("displayName(%q) = %q, want it unchanged", short, displayName(short))
+	}
+}
@@ -0,0 +1,240 @@
+package context
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/raitses/ask/internal/logging"
+	"github.com/raitses/ask/internal/prompt"
+)
+
+const (
+	// MaxAttachmentBytes is the total size budget for files attached to one query
+	MaxAttachmentBytes = 50000
+
+	// MaxAttachmentFiles caps how many files a query may attach
+	MaxAttachmentFiles = 20
+
+	// AttachmentReadWorkers is how many attachments are read at once, which
+	// matters most on network filesystems
+	AttachmentReadWorkers = 8
+)
+
+// ExpandAttachments resolves attachment arguments relative to root. Plain
+// paths are kept as given; glob patterns (with ** matching any number of
+// directories) are expanded, skipping hidden and gitignored paths. Results
+// are deduplicated and capped at MaxAttachmentFiles.
+func ExpandAttachments(root string, patterns []string) ([]string, error) {
+	gitignore := NewGitignoreParser(root)
+	_ = gitignore.Parse() // .gitignore is optional, ignore errors
+
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			add(pattern)
+			continue
+		}
+
+		matches, err := globFiles(root, filepath.ToSlash(pattern), gitignore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			logging.Infof("Warning: No files match %s\n", pattern)
+		}
+		for _, match := range matches {
+			add(match)
+		}
+	}
+
+	if len(paths) > MaxAttachmentFiles {
+		logging.Infof("⚠️  Warning: Attaching only the first %d of %d files\n", MaxAttachmentFiles, len(paths))
+		paths = paths[:MaxAttachmentFiles]
+	}
+
+	return paths, nil
+}
+
+// globFiles walks root and returns the relative paths of files matching pattern
+func globFiles(root, pattern string, gitignore *GitignoreParser) ([]string, error) {
+	var matches []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Skip entries we can't read
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		// Skip hidden files and gitignored paths
+		if strings.HasPrefix(d.Name(), ".") || gitignore.IsIgnored(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() && globMatch(pattern, rel) {
+			matches = append(matches, rel)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// globMatch reports whether a slash-separated path matches pattern, where a
+// "**" segment matches zero or more directories
+func globMatch(pattern, path string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// ReadAttachments reads files to attach to a query, resolving relative paths
+// against root. Files are read concurrently by up to AttachmentReadWorkers
+// goroutines, and no new reads start once MaxAttachmentBytes have been read.
+// The result is the same as reading in order: the file that crosses the
+// budget is truncated and the rest are skipped, with a warning for each.
+// Binary files are skipped with a warning too, without using the budget.
+func ReadAttachments(root string, paths []string) ([]prompt.Attachment, error) {
+	// Files with a binary extension are skipped before reading at all
+	var textPaths []string
+	for _, path := range paths {
+		if hasBinaryExtension(path) {
+			warnBinaryAttachment(path)
+			continue
+		}
+		textPaths = append(textPaths, path)
+	}
+
+	contents, read, errs := readFilesBounded(root, textPaths, MaxAttachmentBytes)
+
+	var attachments []prompt.Attachment
+	remaining := MaxAttachmentBytes
+
+	for i, path := range textPaths {
+		if !read[i] || remaining <= 0 {
+			logging.Infof("⚠️  Warning: Skipping %s (attachment budget of %d bytes used up)\n", path, MaxAttachmentBytes)
+			continue
+		}
+		if errs[i] != nil {
+			return nil, fmt.Errorf("failed to read attachment: %w", errs[i])
+		}
+
+		data := contents[i]
+		if looksBinary(data) {
+			warnBinaryAttachment(path)
+			continue
+		}
+		attachment := prompt.Attachment{Path: path, Content: string(data)}
+		if len(data) > remaining {
+			attachment.Content = string(data[:remaining])
+			attachment.Truncated = true
+			logging.Infof("⚠️  Warning: %s truncated to %d bytes (attachment budget is %d bytes)\n", path, remaining, MaxAttachmentBytes)
+		}
+		remaining -= len(attachment.Content)
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+// warnBinaryAttachment reports a skipped binary attachment; binary content
+// is noise to the model and would waste the budget
+func warnBinaryAttachment(path string) {
+	logging.Infof("⚠️  Warning: Skipping %s (binary file; attach images with --image)\n", path)
+}
+
+// readFilesBounded reads up to budget bytes of each file with a pool of
+// workers, returning contents, whether each file was read, and errors by
+// index. Files are scheduled in order and scheduling stops once budget bytes
+// of text have been read, so every unread file comes after files that
+// already fill the budget. Binary content doesn't count toward the budget.
+func readFilesBounded(root string, paths []string, budget int) ([][]byte, []bool, []error) {
+	contents := make([][]byte, len(paths))
+	read := make([]bool, len(paths))
+	errs := make([]error, len(paths))
+
+	var total atomic.Int64
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < min(AttachmentReadWorkers, len(paths)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				contents[i], errs[i] = readFileLimit(root, paths[i], budget)
+				if !looksBinary(contents[i]) {
+					total.Add(int64(len(contents[i])))
+				}
+			}
+		}()
+	}
+
+	for i := range paths {
+		if total.Load() >= int64(budget) {
+			break
+		}
+		read[i] = true
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return contents, read, errs
+}
+
+// readFileLimit reads at most limit bytes of path, resolved against root
+func readFileLimit(root, path string, limit int) ([]byte, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.LimitReader(file, int64(limit)))
+}
@@ -0,0 +1,79 @@
+package context
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/raitses/ask/internal/api"
+	"github.com/raitses/ask/internal/logging"
+	"github.com/raitses/ask/internal/prompt"
+)
+
+// AuditEntry is one turn in the audit log
+type AuditEntry struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Directory        string    `json:"directory"`
+	Model            string    `json:"model"`
+	Query            string    `json:"query"`
+	Response         string    `json:"response"`
+	FinishReason     string    `json:"finish_reason,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+}
+
+// AppendAudit appends entry to the audit log at path as one JSON line.
+// The file is only ever appended to and is synced before returning.
+func AppendAudit(path string, entry AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to flush audit log: %w", err)
+	}
+
+	return nil
+}
+
+// audit records a completed turn when ASK_AUDIT_LOG is set. Failures are
+// reported but don't fail the query.
+func (m *Manager) audit(userQuery string, attachments []prompt.Attachment, completion api.Completion) {
+	if m.config.AuditLog == "" {
+		return
+	}
+
+	query := prompt.AttachmentNote(userQuery, attachments)
+	response := completion.Content
+	if m.config.Redact {
+		query, _ = Redact(query)
+		response, _ = Redact(response)
+	}
+
+	entry := AuditEntry{
+		Timestamp:        nowFunc(),
+		Directory:        m.store.Directory,
+		Model:            m.config.Model,
+		Query:            query,
+		Response:         response,
+		FinishReason:     completion.FinishReason,
+		PromptTokens:     completion.Usage.PromptTokens,
+		CompletionTokens: completion.Usage.CompletionTokens,
+		TotalTokens:      completion.Usage.TotalTokens,
+	}
+	if err := AppendAudit(m.config.AuditLog, entry); err != nil {
+		logging.Infof("Warning: %v\n", err)
+	}
+}
@@ -0,0 +1,187 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/pkg/hash"
+)
+
+// Backend persists contexts by directory and session. It stores them
+// encoded as Save writes them (JSON, compressed and encrypted as
+// configured), so encryption and compression work with any backend.
+type Backend interface {
+	// Read returns a session's encoded context, or an error for which
+	// os.IsNotExist is true if it has none
+	Read(directory, session string) ([]byte, error)
+
+	// Write replaces a session's encoded context; compressed reports
+	// whether data is gzipped
+	Write(directory, session string, data []byte, compressed bool) error
+
+	// List returns the named sessions with a stored context for a
+	// directory, sorted. The default session is not included.
+	List(directory string) ([]string, error)
+
+	// Delete removes a session's context and reports whether there was one
+	Delete(directory, session string) (bool, error)
+}
+
+// backend stores every directory's contexts (except ASK_CONTEXT_FILE)
+var backend Backend = fileBackend{}
+
+// UseStore selects the backend contexts are stored in by its ASK_STORE
+// name, config.StoreFile (or "") or config.StoreSQLite
+func UseStore(name string) error {
+	switch name {
+	case "", config.StoreFile:
+		setBackend(fileBackend{})
+	case config.StoreSQLite:
+		baseDir, err := config.BaseDir()
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(baseDir, config.ContextDatabase)
+		if current, ok := backend.(*sqliteBackend); ok && current.path == path {
+			return nil
+		}
+		b, err := openSQLiteBackend(path)
+		if err != nil {
+			return err
+		}
+		setBackend(b)
+	default:
+		return fmt.Errorf("ASK_STORE must be one of %s, got %q", strings.Join(config.Stores, ", "), name)
+	}
+	return nil
+}
+
+// setBackend replaces backend, closing the database of a replaced SQLite backend
+func setBackend(b Backend) {
+	if old, ok := backend.(*sqliteBackend); ok && Backend(old) != b {
+		old.db.Close()
+	}
+	backend = b
+}
+
+// fileBackend keeps each context in its own file under
+// ~/.config/ask/contexts, named by a hash of the directory and session
+// (.json, or .json.gz when compressed)
+type fileBackend struct{}
+
+// Read returns the context file's contents, compressed or not
+func (fileBackend) Read(directory, session string) ([]byte, error) {
+	path := getContextFilePath(directory, session)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(path + compressedExt)
+	}
+	return data, err
+}
+
+// Write saves the context file and removes the file in the other format,
+// so Read doesn't find an outdated copy
+func (fileBackend) Write(directory, session string, data []byte, compressed bool) error {
+	path := getContextFilePath(directory, session)
+	stale := path + compressedExt
+	if compressed {
+		path, stale = stale, path
+	}
+	return writeContextFile(path, data, stale)
+}
+
+// List finds the session files for directory
+func (fileBackend) List(directory string) ([]string, error) {
+	baseDir, err := config.BaseDir()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := hash.SessionPath(directory, "") + "@"
+	paths, err := filepath.Glob(filepath.Join(baseDir, config.ContextDir, prefix+"*.json*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	sessions := make([]string, 0, len(paths))
+	for _, path := range paths {
+		name := strings.TrimPrefix(filepath.Base(path), prefix)
+		name, ok := strings.CutSuffix(strings.TrimSuffix(name, compressedExt), ".json")
+		if ok && !slices.Contains(sessions, name) {
+			sessions = append(sessions, name)
+		}
+	}
+	sort.Strings(sessions)
+	return sessions, nil
+}
+
+// Delete removes the context file, compressed or not
+func (fileBackend) Delete(directory, session string) (bool, error) {
+	path := getContextFilePath(directory, session)
+
+	found := false
+	for _, p := range []string{path, path + compressedExt} {
+		if err := os.Remove(p); err == nil {
+			found = true
+		} else if !os.IsNotExist(err) {
+			return found, fmt.Errorf("failed to delete context file: %w", err)
+		}
+	}
+	return found, nil
+}
+
+// writeData writes to the temporary file writeContextFile renames into
+// place (replaced in tests to fail partway through)
+var writeData = (*os.File).Write
+
+// writeContextFile writes an encoded context to path, creating its
+// directory, and removes stale unless it is empty. The data is written to a
+// temporary file that is synced and renamed over path, so an interrupted
+// write leaves the previous context intact.
+func writeContextFile(path string, data []byte, stale string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create context directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write context file: %w", err)
+	}
+	_, err = writeData(tmp, data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write context file: %w", err)
+	}
+
+	// Remove the other format only once the new file is in place
+	if stale == "" {
+		return nil
+	}
+	if err := os.Remove(stale); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old context file: %w", err)
+	}
+	return nil
+}
+
+// getContextFilePath returns the path to the context file for a directory's session
+func getContextFilePath(directory, session string) string {
+	baseDir, _ := config.BaseDir()
+	dirHash := hash.SessionPath(directory, session)
+	return filepath.Join(baseDir, config.ContextDir, dirHash+".json")
+}
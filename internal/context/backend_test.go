@@ -0,0 +1,193 @@
+package context
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/raitses/ask/internal/config"
+)
+
+// memoryBackend keeps contexts in a map, standing in for another Backend
+type memoryBackend map[string][]byte
+
+func (b memoryBackend) Read(directory, session string) ([]byte, error) {
+	data, ok := b[directory+"@"+session]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return data, nil
+}
+
+func (b memoryBackend) Write(directory, session string, data []byte, compressed bool) error {
+	b[directory+"@"+session] = data
+	return nil
+}
+
+func (b memoryBackend) List(directory string) ([]string, error) {
+	var sessions []string
+	for name := range b {
+		if session, ok := strings.CutPrefix(name, directory+"@"); ok && session != "" {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Strings(sessions)
+	return sessions, nil
+}
+
+func (b memoryBackend) Delete(directory, session string) (bool, error) {
+	_, ok := b[directory+"@"+session]
+	delete(b, directory+"@"+session)
+	return ok, nil
+}
+
+func TestStoreUsesBackend(t *testing.T) {
+	home := isolateHome(t)
+	memory := memoryBackend{}
+	orig := backend
+	defer func() { backend = orig }()
+	backend = memory
+
+	for _, session := range []string{"", "debug"} {
+		store, err := LoadSession("/test/dir", session, DeriveKey("secret"))
+		if err != nil {
+			t.Fatalf("LoadSession failed: %v", err)
+		}
+		store.AddMessage("user", "Question in "+session)
+		store.Compress = true
+		if err := store.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	// Contexts are encoded as usual but stored only in the backend
+	if data := memory["/test/dir@debug"]; !isEncrypted(data) {
+		t.Error("The backend should receive the encrypted context")
+	}
+	if entries, _ := os.ReadDir(home); len(entries) > 0 {
+		t.Errorf("Nothing should be written to files, found %d entries", len(entries))
+	}
+
+	loaded, err := LoadSession("/test/dir", "debug", DeriveKey("secret"))
+	if err != nil || len(loaded.Messages) != 1 || loaded.Messages[0].Content != "Question in debug" {
+		t.Fatalf("LoadSession() = %+v, %v; want the saved message", loaded, err)
+	}
+	if sessions, _ := ListSessions("/test/dir"); !slices.Equal(sessions, []string{"debug"}) {
+		t.Errorf("ListSessions() = %v, want [debug]", sessions)
+	}
+
+	if err := Delete("/test/dir"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if len(memory) != 0 {
+		t.Errorf("Delete left %d contexts in the backend", len(memory))
+	}
+}
+
+func TestSQLiteBackend(t *testing.T) {
+	home := isolateHome(t)
+	t.Cleanup(func() { setBackend(fileBackend{}) })
+	if err := UseStore(config.StoreSQLite); err != nil {
+		t.Fatalf("UseStore failed: %v", err)
+	}
+
+	for _, session := range []string{"", "debug", "api"} {
+		store, err := LoadSession("/test/dir", session, DeriveKey("secret"))
+		if err != nil {
+			t.Fatalf("LoadSession failed: %v", err)
+		}
+		store.AddMessage("user", "Question in "+session)
+		store.Compress = session == "debug"
+		if err := store.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	other := NewStore("/other/dir")
+	other.Session = "elsewhere"
+	if err := other.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Everything is in the database; no context files are written
+	baseDir := filepath.Join(home, config.GlobalConfigDir)
+	if info, err := os.Stat(filepath.Join(baseDir, config.ContextDatabase)); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Context database should be created with mode 0600: %v, %v", info, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(baseDir, config.ContextDir, "*.json*")); len(files) > 0 {
+		t.Errorf("Context files written with ASK_STORE=sqlite: %v", files)
+	}
+
+	// Reopening the database finds the saved contexts
+	setBackend(fileBackend{})
+	if err := UseStore(config.StoreSQLite); err != nil {
+		t.Fatalf("UseStore failed: %v", err)
+	}
+	loaded, err := LoadSession("/test/dir", "debug", DeriveKey("secret"))
+	if err != nil || len(loaded.Messages) != 1 || loaded.Messages[0].Content != "Question in debug" {
+		t.Fatalf("LoadSession() = %+v, %v; want the saved message", loaded, err)
+	}
+	if sessions, _ := ListSessions("/test/dir"); !slices.Equal(sessions, []string{"api", "debug"}) {
+		t.Errorf("ListSessions() = %v, want [api debug]", sessions)
+	}
+
+	if err := Delete("/test/dir"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if contextExists("/test/dir", "") || contextExists("/test/dir", "debug") {
+		t.Error("Delete left contexts in the database")
+	}
+	if !contextExists("/other/dir", "elsewhere") {
+		t.Error("Delete removed another directory's context")
+	}
+}
+
+func TestUseStoreUnknown(t *testing.T) {
+	if err := UseStore("postgres"); err == nil || !strings.Contains(err.Error(), "ASK_STORE") {
+		t.Errorf("UseStore(\"postgres\") error = %v, want an ASK_STORE error", err)
+	}
+}
+
+func TestWriteContextFileFailsPartway(t *testing.T) {
+	home := isolateHome(t)
+	store := NewStore("/test/dir")
+	store.AddMessage("user", "First question")
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Fail after writing half of the new context
+	orig := writeData
+	defer func() { writeData = orig }()
+	writeData = func(f *os.File, data []byte) (int, error) {
+		n, _ := f.Write(data[:len(data)/2])
+		return n, errors.New("disk full")
+	}
+
+	store.AddMessage("user", "Second question")
+	store.Compress = true
+	if err := store.Save(); err == nil {
+		t.Fatal("Save should fail when the write fails")
+	}
+	writeData = orig
+
+	loaded, err := Load("/test/dir", nil)
+	if err != nil {
+		t.Fatalf("Load failed after the failed save: %v", err)
+	}
+	if len(loaded.Messages) != 1 || loaded.Messages[0].Content != "First question" {
+		t.Errorf("Load() messages = %+v, want the context from before the failed save", loaded.Messages)
+	}
+
+	// Neither the temporary file nor the compressed copy is left behind
+	dir := filepath.Join(home, config.GlobalConfigDir, config.ContextDir)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name() != filepath.Base(getContextFilePath("/test/dir", "")) {
+			t.Errorf("Unexpected file %s left after the failed save", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package context
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BinarySniffBytes is how much of a file is checked for null bytes when its
+// extension doesn't already identify it as binary
+const BinarySniffBytes = 1024
+
+// How analysis treats binary files in the tree (ASK_ANALYZE_BINARIES)
+const (
+	BinariesList     = ""         // List binaries like any other file
+	BinariesAnnotate = "annotate" // List binaries marked with [binary]
+	BinariesSkip     = "skip"     // Leave binaries out of the tree
+)
+
+// binaryExtensions are file types that are never worth reading as text
+var binaryExtensions = map[string]bool{
+	// Images and media
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".bmp": true,
+	".ico": true, ".tiff": true, ".mp3": true, ".mp4": true, ".mov": true, ".wav": true,
+	".ttf": true, ".otf": true, ".woff": true, ".woff2": true, ".pdf": true,
+	// Archives
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".7z": true, ".rar": true, ".tar": true, ".jar": true, ".war": true,
+	// Compiled artifacts
+	".exe": true, ".dll": true, ".so": true, ".dylib": true, ".a": true, ".o": true,
+	".obj": true, ".class": true, ".pyc": true, ".wasm": true, ".bin": true,
+	// Data files
+	".db": true, ".sqlite": true, ".sqlite3": true, ".parquet": true,
+}
+
+// hasBinaryExtension reports whether the file name has a known binary extension
+func hasBinaryExtension(name string) bool {
+	return binaryExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// looksBinary reports whether data contains a null byte in its first
+// BinarySniffBytes bytes, which text files practically never do
+func looksBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), BinarySniffBytes)], 0) >= 0
+}
+
+// IsBinaryFile reports whether the file at path is binary, judged by its
+// extension and then by sniffing its first BinarySniffBytes bytes
+func IsBinaryFile(path string) (bool, error) {
+	if hasBinaryExtension(path) {
+		return true, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	head := make([]byte, BinarySniffBytes)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return looksBinary(head[:n]), nil
+}
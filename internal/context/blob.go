@@ -0,0 +1,110 @@
+package context
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/internal/logging"
+	"github.com/raitses/ask/pkg/hash"
+)
+
+// blobPreviewChars is how much of an offloaded message its reference shows
+const blobPreviewChars = 200
+
+// blobID returns the content-addressed ID of an offloaded message
+func blobID(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// blobDir returns the directory holding a session's blobs, so each context
+// owns its blobs and can remove them with its messages
+func blobDir(directory, session string) string {
+	baseDir, _ := config.BaseDir()
+	return filepath.Join(baseDir, config.BlobsDir, hash.SessionPath(directory, session))
+}
+
+// blobPath returns the sidecar file for one of the store's blobs
+func (s *Store) blobPath(id string) string {
+	return filepath.Join(blobDir(s.Directory, s.Session), id+".txt")
+}
+
+// blobReference is what stays in the active context in place of an
+// offloaded message: its size and the start of its content
+func blobReference(id, content string) string {
+	preview := truncateBytes(content, blobPreviewChars)
+	return fmt.Sprintf("[Large message (%s, %d lines) stored as blob %s; mention %s to include it in full. It starts:\n%s\n...]",
+		formatBytes(int64(len(content))), strings.Count(content, "\n")+1, id, id, preview)
+}
+
+// offload writes content to its sidecar file, encrypted like the context
+// file, and returns the blob's ID
+func (s *Store) offload(content string) (string, error) {
+	id := blobID(content)
+	if err := os.MkdirAll(filepath.Dir(s.blobPath(id)), 0700); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	data := []byte(content)
+	if s.encryptionKey != nil {
+		var err error
+		if data, err = encrypt(data, s.encryptionKey); err != nil {
+			return "", fmt.Errorf("failed to encrypt blob: %w", err)
+		}
+	}
+
+	if err := os.WriteFile(s.blobPath(id), data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if s.blobs == nil {
+		s.blobs = make(map[string]bool)
+	}
+	s.blobs[id] = true
+	return id, nil
+}
+
+// LoadBlob returns the full content of an offloaded message
+func (s *Store) LoadBlob(id string) (string, error) {
+	data, err := os.ReadFile(s.blobPath(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to read blob %s: %w", id, err)
+	}
+
+	if isEncrypted(data) {
+		if s.encryptionKey == nil {
+			return "", fmt.Errorf("blob %s is encrypted; set ASK_ENCRYPTION_KEY to read it", id)
+		}
+		if data, err = decrypt(data, s.encryptionKey); err != nil {
+			return "", err
+		}
+	}
+	return string(data), nil
+}
+
+// removeUnreferencedBlobs deletes the blobs of messages that are no longer in
+// the store, e.g. after pruning or a reset. Called once the store is saved,
+// so the saved context never refers to a missing blob.
+func (s *Store) removeUnreferencedBlobs() {
+	referenced := make(map[string]bool)
+	for _, msg := range s.Messages {
+		if msg.Blob != "" {
+			referenced[msg.Blob] = true
+		}
+	}
+
+	for id := range s.blobs {
+		if referenced[id] {
+			continue
+		}
+		if err := os.Remove(s.blobPath(id)); err != nil && !os.IsNotExist(err) {
+			logging.Infof("Warning: Failed to remove blob %s: %v\n", id, err)
+			continue
+		}
+		delete(s.blobs, id)
+	}
+}
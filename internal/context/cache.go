@@ -0,0 +1,156 @@
+package context
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/raitses/ask/internal/api"
+	"github.com/raitses/ask/internal/config"
+)
+
+// cachedResponse is a stored completion in the response cache
+type cachedResponse struct {
+	CreatedAt    time.Time `json:"created_at"`
+	Model        string    `json:"model"`
+	Content      string    `json:"content"`
+	FinishReason string    `json:"finish_reason,omitempty"`
+}
+
+// responseCacheable reports whether query responses may be cached: the
+// cache must be enabled and sampling deterministic (temperature 0). Tool
+// runs depend on command output and multiple choices exist to differ, so
+// neither is cached.
+func (m *Manager) responseCacheable() bool {
+	cfg := m.config
+	return cfg.ResponseCache && !cfg.Tools && cfg.Choices <= 1 && cfg.Temperature != nil && *cfg.Temperature == 0
+}
+
+// responseCacheKey hashes everything that determines a response: the
+// endpoint, model, sampling parameters, and assembled messages
+func (m *Manager) responseCacheKey(messages []api.ChatMessage) string {
+	data, _ := json.Marshal(struct {
+		APIURL    string
+		Model     string
+		TopP      *float64
+		Seed      *int
+		MaxTokens int
+		Messages  []api.ChatMessage
+	}{m.config.APIURL, m.config.Model, m.config.TopP, m.config.Seed, m.config.MaxTokens, messages})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedCompletion returns the cached response for key if it is younger
+// than the TTL. Expired entries are removed.
+func (m *Manager) cachedCompletion(key string) (api.Completion, bool) {
+	path := responseCachePath(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return api.Completion{}, false
+	}
+
+	if isEncrypted(data) {
+		if m.store.encryptionKey == nil {
+			return api.Completion{}, false
+		}
+		if data, err = decrypt(data, m.store.encryptionKey); err != nil {
+			return api.Completion{}, false
+		}
+	}
+
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return api.Completion{}, false
+	}
+
+	if nowFunc().Sub(cached.CreatedAt) > m.responseCacheTTL() {
+		_ = os.Remove(path)
+		return api.Completion{}, false
+	}
+
+	return api.Completion{Content: cached.Content, FinishReason: cached.FinishReason}, true
+}
+
+// cacheCompletion stores a response under key, redacted and encrypted like
+// the context file
+func (m *Manager) cacheCompletion(key string, completion api.Completion) error {
+	content := completion.Content
+	if m.config.Redact {
+		content, _ = Redact(content)
+	}
+
+	data, err := json.Marshal(cachedResponse{
+		CreatedAt:    nowFunc(),
+		Model:        m.config.Model,
+		Content:      content,
+		FinishReason: completion.FinishReason,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cached response: %w", err)
+	}
+
+	if m.store.encryptionKey != nil {
+		if data, err = encrypt(data, m.store.encryptionKey); err != nil {
+			return fmt.Errorf("failed to encrypt cached response: %w", err)
+		}
+	}
+
+	path := responseCachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create response cache directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	return nil
+}
+
+// responseCacheTTL returns the configured TTL or the default
+func (m *Manager) responseCacheTTL() time.Duration {
+	if m.config.ResponseCacheTTL > 0 {
+		return m.config.ResponseCacheTTL
+	}
+	return config.DefaultResponseCacheTTL
+}
+
+// ClearResponseCache deletes every cached response and returns how many
+// were removed
+func ClearResponseCache() (int, error) {
+	baseDir, err := config.BaseDir()
+	if err != nil {
+		return 0, err
+	}
+
+	dir := filepath.Join(baseDir, config.ResponseCacheDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to read response cache: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+			return removed, fmt.Errorf("failed to delete cached response: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// responseCachePath returns the cache file for a key
+func responseCachePath(key string) string {
+	baseDir, _ := config.BaseDir()
+	return filepath.Join(baseDir, config.ResponseCacheDir, key+".json")
+}
@@ -0,0 +1,100 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/raitses/ask/internal/api"
+	"github.com/raitses/ask/internal/config"
+)
+
+func TestQueryResponseCache(t *testing.T) {
+	zero, warm := 0.0, 0.7
+
+	tests := []struct {
+		name         string
+		cache        bool
+		temperature  *float64
+		wantRequests int
+	}{
+		{"temperature 0 served from cache", true, &zero, 1},
+		{"cache disabled", false, &zero, 2},
+		{"non-zero temperature not cached", true, &warm, 2},
+		{"unset temperature not cached", true, nil, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateHome(t)
+			dir := t.TempDir()
+			transport := &stubTransport{replies: []string{"The answer", "A different answer"}}
+			cfg := &config.Config{
+				APIURL:        "https://api.example.com/v1/chat",
+				APIKey:        "test",
+				Model:         "gpt-4o",
+				ResponseCache: tt.cache,
+				Temperature:   tt.temperature,
+			}
+			client := api.NewClientWithTransport(cfg, transport)
+
+			// The same question against the same (fresh) history twice
+			var responses []string
+			for i := 0; i < 2; i++ {
+				manager := &Manager{store: NewStore(dir), config: cfg, client: client}
+				response, err := manager.Query("How do I run tests?")
+				if err != nil {
+					t.Fatalf("Query failed: %v", err)
+				}
+				manager.Wait()
+				responses = append(responses, response)
+			}
+
+			if len(transport.requests) != tt.wantRequests {
+				t.Errorf("Made %d requests, want %d", len(transport.requests), tt.wantRequests)
+			}
+			if tt.wantRequests == 1 && responses[1] != "The answer" {
+				t.Errorf("Cached response = %q, want %q", responses[1], "The answer")
+			}
+		})
+	}
+}
+
+func TestResponseCacheTTLAndClear(t *testing.T) {
+	isolateHome(t)
+	cfg := &config.Config{Model: "gpt-4o", ResponseCacheTTL: time.Hour}
+	manager := &Manager{store: NewStore("/test/dir"), config: cfg}
+
+	// Cache one entry two hours before the other, past the TTL
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	freezeTime(t, now.Add(-2*time.Hour))
+	if err := manager.cacheCompletion("stale", api.Completion{Content: "Stale"}); err != nil {
+		t.Fatalf("cacheCompletion failed: %v", err)
+	}
+	freezeTime(t, now)
+	if err := manager.cacheCompletion("fresh", api.Completion{Content: "Fresh"}); err != nil {
+		t.Fatalf("cacheCompletion failed: %v", err)
+	}
+
+	if completion, ok := manager.cachedCompletion("fresh"); !ok || completion.Content != "Fresh" {
+		t.Errorf("cachedCompletion(fresh) = %q, %v; want a hit", completion.Content, ok)
+	}
+	if _, ok := manager.cachedCompletion("stale"); ok {
+		t.Error("Expired entry should be a miss")
+	}
+	if _, err := os.Stat(responseCachePath("stale")); !os.IsNotExist(err) {
+		t.Error("Expired entry should be removed")
+	}
+
+	removed, err := ClearResponseCache()
+	if err != nil {
+		t.Fatalf("ClearResponseCache failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Removed %d entries, want 1", removed)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(responseCachePath("fresh"))); len(entries) != 0 {
+		t.Errorf("Cache should be empty, has %d entries", len(entries))
+	}
+}
@@ -0,0 +1,7 @@
+package context
+
+import "time"
+
+// nowFunc returns the current time. The store, pruner, and caches read the
+// clock through it so tests can freeze time.
+var nowFunc = time.Now
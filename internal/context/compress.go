@@ -0,0 +1,48 @@
+package context
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// compressedExt is appended to the file name of compressed context files
+const compressedExt = ".gz"
+
+// gzipHeader is the magic number every gzip stream starts with, so
+// compressed data is recognized whatever the file is called
+var gzipHeader = []byte{0x1f, 0x8b}
+
+// isCompressed checks if data starts with the gzip header
+func isCompressed(data []byte) bool {
+	return bytes.HasPrefix(data, gzipHeader)
+}
+
+// compress gzips data
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress reverses compress
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("compressed context file is corrupt: %w", err)
+	}
+	defer r.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("compressed context file is corrupt: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package context
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompressedStoreRoundTrip(t *testing.T) {
+	isolateHome(t)
+	path := getContextFilePath("/test/dir", "")
+
+	// Existing plaintext file
+	store := NewStore("/test/dir")
+	store.AddMessage("user", strings.Repeat("a long and repetitive question ", 100))
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	plainSize, _ := store.FileSize()
+
+	// Opting in rewrites it compressed under .json.gz
+	loaded, err := Load("/test/dir", nil)
+	if err != nil {
+		t.Fatalf("Load of plaintext file failed: %v", err)
+	}
+	loaded.Compress = true
+	if err := loaded.Save(); err != nil {
+		t.Fatalf("Compressed Save failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("The plaintext file should be removed once compressed")
+	}
+	data, err := os.ReadFile(path + compressedExt)
+	if err != nil || !isCompressed(data) {
+		t.Fatalf("Expected a gzip file at %s (err: %v)", path+compressedExt, err)
+	}
+
+	disk, uncompressed := loaded.FileSize()
+	if disk >= plainSize || uncompressed <= disk {
+		t.Errorf("FileSize() = %d, %d; want under the plaintext %d on disk", disk, uncompressed, plainSize)
+	}
+	if size := formatFileSize(disk, uncompressed); !strings.Contains(size, "smaller") {
+		t.Errorf("formatFileSize = %q, want the savings", size)
+	}
+
+	// Compressed files load, with or without an encryption key
+	for _, key := range []*Key{nil, DeriveKey("secret")} {
+		reloaded, err := Load("/test/dir", key)
+		if err != nil {
+			t.Fatalf("Load of compressed file failed: %v", err)
+		}
+		if len(reloaded.Messages) != 1 || !strings.HasPrefix(reloaded.Messages[0].Content, "a long") {
+			t.Errorf("Decompressed messages = %+v", reloaded.Messages)
+		}
+	}
+
+	// Compression and encryption combine
+	encrypted, _ := Load("/test/dir", DeriveKey("secret"))
+	encrypted.Compress = true
+	if err := encrypted.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if reloaded, err := Load("/test/dir", DeriveKey("secret")); err != nil || len(reloaded.Messages) != 1 {
+		t.Errorf("Load of compressed, encrypted file = %v, %v", reloaded, err)
+	}
+
+	// Forgetting removes the compressed file
+	if err := Delete("/test/dir"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := os.Stat(path + compressedExt); !os.IsNotExist(err) {
+		t.Error("Delete should remove the compressed file")
+	}
+}
@@ -0,0 +1,147 @@
+package context
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedHeader marks files encrypted with AES-GCM under a key derived
+// with scrypt from the passphrase and a random salt stored after the header
+var encryptedHeader = []byte("ASKENC2\n")
+
+// legacyEncryptedHeader marks files encrypted under the unsalted SHA-256 of
+// the passphrase. They still decrypt, and are rewritten with
+// encryptedHeader on their next save.
+var legacyEncryptedHeader = []byte("ASKENC1\n")
+
+// saltSize is the length of the random salt stored in each encrypted file
+const saltSize = 16
+
+// scrypt cost parameters, the recommended values for interactive use
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Key is an ASK_ENCRYPTION_KEY passphrase. Each file is encrypted under its
+// own key, derived from the passphrase and the file's salt.
+type Key struct {
+	passphrase []byte
+
+	mu      sync.Mutex
+	derived map[string][]byte // By salt, so rereading a file doesn't rerun scrypt
+}
+
+// DeriveKey returns the Key for an ASK_ENCRYPTION_KEY passphrase, or nil if
+// the passphrase is empty
+func DeriveKey(passphrase string) *Key {
+	if passphrase == "" {
+		return nil
+	}
+	return &Key{passphrase: []byte(passphrase), derived: make(map[string][]byte)}
+}
+
+// fileKey returns the 256-bit AES key for a file with salt
+func (k *Key) fileKey(salt []byte) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.derived[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(k.passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	k.derived[string(salt)] = key
+	return key, nil
+}
+
+// legacyKey returns the key files with legacyEncryptedHeader were encrypted under
+func (k *Key) legacyKey() []byte {
+	sum := sha256.Sum256(k.passphrase)
+	return sum[:]
+}
+
+// isEncrypted checks if data starts with an encrypted file header
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedHeader) || bytes.HasPrefix(data, legacyEncryptedHeader)
+}
+
+// encrypt seals data with AES-GCM: header + salt + nonce + ciphertext
+func encrypt(data []byte, key *Key) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	fileKey, err := key.fileKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(encryptedHeader)+len(salt)+len(nonce)+len(data)+gcm.Overhead())
+	out = append(out, encryptedHeader...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+// decrypt opens data produced by encrypt, or by the legacy unsalted format
+func decrypt(data []byte, key *Key) ([]byte, error) {
+	var fileKey []byte
+	if rest, ok := bytes.CutPrefix(data, legacyEncryptedHeader); ok {
+		data, fileKey = rest, key.legacyKey()
+	} else {
+		data = bytes.TrimPrefix(data, encryptedHeader)
+		if len(data) < saltSize {
+			return nil, fmt.Errorf("encrypted context file is corrupt")
+		}
+		var err error
+		if fileKey, err = key.fileKey(data[:saltSize]); err != nil {
+			return nil, err
+		}
+		data = data[saltSize:]
+	}
+
+	gcm, err := newGCM(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted context file is corrupt")
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt context file (wrong ASK_ENCRYPTION_KEY?)")
+	}
+
+	return plaintext, nil
+}
+
+// newGCM creates an AES-GCM cipher for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,140 @@
+package context
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedStoreRoundTrip(t *testing.T) {
+	isolateHome(t)
+	key := DeriveKey("correct horse battery staple")
+
+	store, err := Load("/test/dir", key)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	store.AddMessage("user", "proprietary discussion")
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// File on disk should be encrypted
+	data, err := os.ReadFile(getContextFilePath("/test/dir", ""))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !isEncrypted(data) || strings.Contains(string(data), "proprietary") {
+		t.Error("Context file should be encrypted on disk")
+	}
+
+	// Correct key decrypts
+	loaded, err := Load("/test/dir", key)
+	if err != nil {
+		t.Fatalf("Load with correct key failed: %v", err)
+	}
+	if len(loaded.Messages) != 1 || loaded.Messages[0].Content != "proprietary discussion" {
+		t.Errorf("Decrypted messages = %+v", loaded.Messages)
+	}
+
+	// Wrong key fails clearly
+	if _, err := Load("/test/dir", DeriveKey("wrong")); err == nil || !strings.Contains(err.Error(), "wrong ASK_ENCRYPTION_KEY") {
+		t.Errorf("Load with wrong key error = %v, want decryption failure", err)
+	}
+
+	// Missing key fails clearly
+	if _, err := Load("/test/dir", nil); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("Load without key error = %v, want encrypted file error", err)
+	}
+}
+
+func TestPlaintextStoreLoadsWithKey(t *testing.T) {
+	isolateHome(t)
+
+	// Existing plaintext file
+	store := NewStore("/test/dir")
+	store.AddMessage("user", "hello")
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := Load("/test/dir", DeriveKey("secret"))
+	if err != nil {
+		t.Fatalf("Loading plaintext file with key failed: %v", err)
+	}
+	if len(loaded.Messages) != 1 {
+		t.Errorf("Got %d messages, want 1", len(loaded.Messages))
+	}
+}
+
+func TestEncryptSaltsEachFile(t *testing.T) {
+	key := DeriveKey("secret")
+	first, err := encrypt([]byte("same data"), key)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+	second, err := encrypt([]byte("same data"), key)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+
+	if !bytes.HasPrefix(first, encryptedHeader) {
+		t.Fatalf("Encrypted data should start with %q", encryptedHeader)
+	}
+	salt := func(data []byte) []byte { return data[len(encryptedHeader) : len(encryptedHeader)+saltSize] }
+	if bytes.Equal(salt(first), salt(second)) {
+		t.Error("Each file should get its own random salt")
+	}
+
+	// A fresh Key (a new run) derives the same file key from the salt
+	for _, data := range [][]byte{first, second} {
+		if plain, err := decrypt(data, DeriveKey("secret")); err != nil || string(plain) != "same data" {
+			t.Errorf("decrypt() = %q, %v; want the original data", plain, err)
+		}
+	}
+}
+
+func TestDecryptLegacyFiles(t *testing.T) {
+	isolateHome(t)
+	key := DeriveKey("secret")
+
+	// Seal a context the way files were written before salts
+	data := []byte(`{"version":"1","directory":"/test/dir","messages":[{"role":"user","content":"old secret"}]}`)
+	gcm, err := newGCM(key.legacyKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, _ = rand.Read(nonce)
+	legacy := gcm.Seal(append(append([]byte{}, legacyEncryptedHeader...), nonce...), nonce, data, nil)
+
+	path := getContextFilePath("/test/dir", "")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := Load("/test/dir", key)
+	if err != nil {
+		t.Fatalf("Load of legacy encrypted file failed: %v", err)
+	}
+	if len(store.Messages) != 1 || store.Messages[0].Content != "old secret" {
+		t.Errorf("Decrypted messages = %+v", store.Messages)
+	}
+	if _, err := Load("/test/dir", DeriveKey("wrong")); err == nil {
+		t.Error("Legacy file should not open with the wrong key")
+	}
+
+	// Saving upgrades it to the salted format
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if saved, _ := os.ReadFile(path); !bytes.HasPrefix(saved, encryptedHeader) {
+		t.Error("A saved context should use the salted format")
+	}
+}
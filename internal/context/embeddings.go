@@ -0,0 +1,176 @@
+package context
+
+import (
+	"fmt"
+	"math"
+)
+
+// Embedder computes an embedding vector for each text. *api.Client
+// implements it with the endpoint configured by ASK_EMBEDDINGS_URL.
+type Embedder interface {
+	Embed(texts []string) ([][]float64, error)
+}
+
+// MaxEmbedChars caps how much of each exchange is embedded
+const MaxEmbedChars = 4000
+
+// exchange is a run of prunable messages: a user message and the replies
+// that follow it
+type exchange struct {
+	indices []int
+	text    string
+}
+
+// SetEmbedder makes the pruner choose what to keep by embedding similarity
+// instead of asking the chat model
+func (p *Pruner) SetEmbedder(embedder Embedder) {
+	p.embedder = embedder
+}
+
+// pruneWithEmbeddings groups the prunable exchanges into as many clusters as
+// fit the target and keeps the most recent exchange of each, so every topic
+// stays represented by its latest state. Preserved messages are kept as usual.
+func (p *Pruner) pruneWithEmbeddings() error {
+	exchanges, preserved := p.prunableExchanges()
+	keep := (p.limits.TargetMessages - preserved) / 2
+	if keep >= len(exchanges) {
+		return nil
+	}
+
+	var toRemove []int
+	if keep > 0 {
+		texts := make([]string, len(exchanges))
+		for i, ex := range exchanges {
+			texts[i] = ex.text
+		}
+		vectors, err := p.embedder.Embed(texts)
+		if err != nil {
+			return fmt.Errorf("embedding request failed: %w", err)
+		}
+		if len(vectors) != len(exchanges) {
+			return fmt.Errorf("got %d embeddings for %d exchanges", len(vectors), len(exchanges))
+		}
+
+		kept := make(map[int]bool, keep)
+		for _, i := range clusterRepresentatives(vectors, keep) {
+			kept[i] = true
+		}
+		for i, ex := range exchanges {
+			if !kept[i] {
+				toRemove = append(toRemove, ex.indices...)
+			}
+		}
+	} else {
+		for _, ex := range exchanges {
+			toRemove = append(toRemove, ex.indices...)
+		}
+	}
+
+	if removed := p.removeMessagesByIndices(toRemove); removed > 0 {
+		p.store.Metadata.PruneCount++
+		p.store.Metadata.TotalMessages = len(p.store.Messages)
+		p.store.Metadata.TotalTokensEstimate = p.store.EstimateTokens()
+	}
+	return nil
+}
+
+// prunableExchanges groups messages that may be removed into exchanges,
+// oldest first, and counts the messages that must be kept. An exchange with
+// any preserved message is kept whole.
+func (p *Pruner) prunableExchanges() ([]exchange, int) {
+	var groups [][]int
+	for i, msg := range p.store.Messages {
+		if msg.Role == "user" || len(groups) == 0 {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], i)
+	}
+
+	var exchanges []exchange
+	preserved := 0
+	for _, group := range groups {
+		protected := false
+		for _, i := range group {
+			if p.ShouldPreserve(p.store.Messages[i], i) {
+				protected = true
+				break
+			}
+		}
+		if protected {
+			preserved += len(group)
+			continue
+		}
+
+		var text string
+		for _, i := range group {
+			text += p.store.Messages[i].Content + "\n"
+		}
+		if len(text) > MaxEmbedChars {
+			text = text[:MaxEmbedChars]
+		}
+		exchanges = append(exchanges, exchange{indices: group, text: text})
+	}
+	return exchanges, preserved
+}
+
+// clusterRepresentatives splits vectors (oldest first) into k clusters
+// around mutually distant seeds and returns the newest member of each
+func clusterRepresentatives(vectors [][]float64, k int) []int {
+	if k >= len(vectors) {
+		seeds := make([]int, len(vectors))
+		for i := range seeds {
+			seeds[i] = i
+		}
+		return seeds
+	}
+
+	// Farthest-point seeding from the newest exchange: each seed is the
+	// exchange least similar to every seed so far
+	seeds := []int{len(vectors) - 1}
+	nearest := make([]float64, len(vectors)) // Distance to the closest seed
+	for i := range vectors {
+		nearest[i] = cosineDistance(vectors[i], vectors[seeds[0]])
+	}
+	for len(seeds) < k {
+		next := 0
+		for i := range vectors {
+			if nearest[i] > nearest[next] {
+				next = i
+			}
+		}
+		seeds = append(seeds, next)
+		for i := range vectors {
+			nearest[i] = math.Min(nearest[i], cosineDistance(vectors[i], vectors[next]))
+		}
+	}
+
+	// Assign each exchange to its closest seed and keep the newest per cluster
+	newest := make([]int, k)
+	for c, seed := range seeds {
+		newest[c] = seed
+	}
+	for i := range vectors {
+		closest := 0
+		for c, seed := range seeds {
+			if cosineDistance(vectors[i], vectors[seed]) < cosineDistance(vectors[i], vectors[seeds[closest]]) {
+				closest = c
+			}
+		}
+		newest[closest] = max(newest[closest], i)
+	}
+	return newest
+}
+
+// cosineDistance returns 1 minus the cosine similarity of a and b
+func cosineDistance(a, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range min(len(a), len(b)) {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+}
@@ -0,0 +1,68 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raitses/ask/internal/api"
+)
+
+const (
+	// MaxImageBytes is the largest image that may be attached
+	MaxImageBytes = 20 << 20
+
+	// MaxImageFiles caps how many images a query may attach
+	MaxImageFiles = 10
+)
+
+// imageMediaTypes maps supported image extensions to their media types
+var imageMediaTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// ReadImages reads images to attach to a query, resolving relative paths
+// against root, and returns them as content parts. Each must exist, be a
+// supported format (PNG, JPEG, GIF, WebP), and be at most MaxImageBytes.
+func ReadImages(root string, paths []string) ([]api.ContentPart, error) {
+	if len(paths) > MaxImageFiles {
+		return nil, fmt.Errorf("too many images (%d); at most %d can be attached", len(paths), MaxImageFiles)
+	}
+
+	parts := make([]api.ContentPart, 0, len(paths))
+	for _, path := range paths {
+		mediaType, ok := imageMediaTypes[strings.ToLower(filepath.Ext(path))]
+		if !ok {
+			return nil, fmt.Errorf("unsupported image %s (use PNG, JPEG, GIF, or WebP)", path)
+		}
+
+		full := path
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(root, path)
+		}
+
+		info, err := os.Stat(full)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("image %s is not a file", path)
+		}
+		if info.Size() > MaxImageBytes {
+			return nil, fmt.Errorf("image %s is %d bytes; the limit is %d", path, info.Size(), MaxImageBytes)
+		}
+
+		data, err := os.ReadFile(full)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		parts = append(parts, api.ImagePart(mediaType, data))
+	}
+
+	return parts, nil
+}
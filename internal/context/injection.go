@@ -0,0 +1,50 @@
+package context
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/raitses/ask/internal/prompt"
+)
+
+// injectionPatterns match phrases commonly used to smuggle instructions to
+// a model through content it is only meant to read
+var injectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override)\s+(?:all\s+|any\s+)?(?:the\s+|your\s+)?(?:previous|prior|above|earlier|preceding)\s+(?:instructions|prompts?|messages|rules|context)`),
+	regexp.MustCompile(`(?i)\bdisregard\s+(?:everything|all)\s+(?:above|before)`),
+	regexp.MustCompile(`(?i)\byou\s+are\s+now\s+(?:a|an|in)\b`),
+	regexp.MustCompile(`(?i)\bnew\s+instructions\s*:`),
+	regexp.MustCompile(`(?i)\b(?:reveal|print|show|repeat)\s+(?:your|the)\s+system\s+prompt`),
+	regexp.MustCompile(`(?i)\bdo\s+not\s+(?:tell|inform|warn)\s+the\s+user`),
+	regexp.MustCompile(`(?i)</?\s*(?:system|instructions?)\s*>`),
+}
+
+// DetectInjection returns the instruction-like phrases found in content,
+// without duplicates, or nil if it looks like ordinary project text
+func DetectInjection(content string) []string {
+	var found []string
+	seen := make(map[string]bool)
+	for _, pattern := range injectionPatterns {
+		for _, match := range pattern.FindAllString(content, -1) {
+			match = strings.TrimSpace(match)
+			if key := strings.ToLower(match); !seen[key] {
+				seen[key] = true
+				found = append(found, match)
+			}
+		}
+	}
+	return found
+}
+
+// ScanAnalysis returns the analysis sources (prompt.SourceReadme,
+// prompt.SourceFileTree) containing instruction-like text
+func ScanAnalysis(cache *AnalysisCache) []string {
+	var flagged []string
+	if len(DetectInjection(cache.ReadmeContent)) > 0 {
+		flagged = append(flagged, prompt.SourceReadme)
+	}
+	if len(DetectInjection(cache.FileTree)) > 0 {
+		flagged = append(flagged, prompt.SourceFileTree)
+	}
+	return flagged
+}
@@ -1,8 +1,10 @@
 package context
 
 import (
+	"fmt"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 func TestMessageSizeLimits(t *testing.T) {
@@ -33,6 +35,73 @@ func TestMessageSizeLimits(t *testing.T) {
 	t.Logf("Huge message truncated from %d to %d chars", len(hugeContent), len(msg.Content))
 }
 
+func TestAddMessageRoles(t *testing.T) {
+	tests := []struct {
+		role     string
+		wantRole string
+		wantErr  bool
+	}{
+		{"user", "user", false},
+		{"Assistant", "assistant", false},
+		{" SYSTEM ", "system", false},
+		{"tool", "tool", false},
+		{"developer", "developer", false},
+		{"assistent", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		store := NewStore("/test/dir")
+		err := store.AddMessage(tt.role, "Hello")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("AddMessage(%q) should fail", tt.role)
+			}
+			if len(store.Messages) != 0 {
+				t.Errorf("AddMessage(%q) stored a message with an invalid role", tt.role)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("AddMessage(%q) failed: %v", tt.role, err)
+			continue
+		}
+		if got := store.Messages[0].Role; got != tt.wantRole {
+			t.Errorf("AddMessage(%q) stored role %q, want %q", tt.role, got, tt.wantRole)
+		}
+	}
+}
+
+func TestMessageTruncationKeepsHeadAndTail(t *testing.T) {
+	store := NewStore("/test/dir")
+	store.MaxMessageLength = 100
+
+	content := "Traceback start\n" + strings.Repeat("frame\n", 100) + "ValueError: the real problem"
+	store.AddMessage("user", content)
+
+	msg := store.Messages[0].Content
+	if !strings.HasPrefix(msg, "Traceback start") {
+		t.Errorf("Head should be kept: %q", msg)
+	}
+	if !strings.HasSuffix(msg, "ValueError: the real problem") {
+		t.Errorf("Tail should be kept: %q", msg)
+	}
+	if !strings.Contains(msg, "[Content truncated") {
+		t.Error("Truncation notice not found in message")
+	}
+
+	kept := len(msg) - len(fmt.Sprintf("\n\n[Content truncated - %d characters elided from the middle]\n\n", len(content)-100))
+	if kept != 100 {
+		t.Errorf("Kept %d characters of content, want 100", kept)
+	}
+
+	// Multi-byte characters are never split
+	store.AddMessage("user", strings.Repeat("é", 200))
+	if !utf8.ValidString(store.Messages[1].Content) {
+		t.Error("Truncation produced invalid UTF-8")
+	}
+}
+
 func TestAnalyzerFileSizeLimits(t *testing.T) {
 	tmpDir := t.TempDir()
 
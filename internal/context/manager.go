@@ -1,60 +1,615 @@
 package context
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/raitses/ask/internal/api"
 	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/internal/logging"
 	"github.com/raitses/ask/internal/prompt"
 )
 
 // Manager handles context operations
 type Manager struct {
-	store  *Store
-	config *config.Config
-	client *api.Client
+	store   *Store
+	config  *config.Config
+	client  *api.Client
+	confirm func(question string) bool
+	approve func(command string) bool  // Approves each command run through the tool
+	choose  func(choices []string) int // Picks one of several response choices
+	guard   func() (release func())    // Wraps saves that mustn't be cut short
+	pruning sync.WaitGroup             // Background pruning from the previous turn
+	persona string                     // Selected persona text, if any
+
+	examples          []prompt.Message // Project's few-shot examples (.ask/examples.json)
+	projectTools      []ProjectTool    // Project's tools offered with --tools (.ask/tools.json)
+	ephemeralAnalysis *AnalysisCache   // One-off analysis used instead of the stored one, never saved
+	usageFooter       string           // Context fill level after the last query
+	fingerprint       string           // Provider's system_fingerprint for the last answer
+	latency           time.Duration    // Time spent waiting on the provider for the last answer
 }
 
-// NewManager creates a new context manager for the current directory
+// NewManager creates a new context manager for cfg.Dir, or the current
+// directory if it is empty
 func NewManager(cfg *config.Config) (*Manager, error) {
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	return NewManagerWithClient(cfg, api.NewClient(cfg))
+}
+
+// NewManagerWithClient creates a context manager like NewManager that sends
+// requests through a pre-built client
+func NewManagerWithClient(cfg *config.Config, client *api.Client) (*Manager, error) {
+	dir := cfg.Dir
+	if dir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get current directory: %w", err)
+		}
+		dir = cwd
+	} else if info, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("invalid directory: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("invalid directory: %s is not a directory", dir)
 	}
 
-	absPath, err := filepath.Abs(cwd)
+	absPath, err := filepath.Abs(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
-	store, err := Load(absPath)
+	if err := NewPreservationRules(cfg).Validate(DefaultPruningLimits()); err != nil {
+		return nil, err
+	}
+
+	if err := UseStore(cfg.Store); err != nil {
+		return nil, err
+	}
+	store, err := loadStore(cfg, absPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load context: %w", err)
+		return nil, err
+	}
+	if store.Stale(cfg.ContextTTL, nowFunc()) {
+		if cfg.KeepStale {
+			logging.Infof("Keeping context last updated %s (older than ASK_CONTEXT_TTL)\n", store.UpdatedAt.Format("2006-01-02"))
+		} else {
+			// Months-old context tends to mislead more than it helps
+			logging.Infof("⚠️  Context was last updated %s, older than ASK_CONTEXT_TTL; starting fresh (use --keep-stale to keep it)\n", store.UpdatedAt.Format("2006-01-02"))
+			store.Reset()
+		}
 	}
+	store.Redact = cfg.Redact
+	store.MaxMessageLength = cfg.MaxMessageLength
+	store.OffloadLarge = cfg.OffloadLarge
+	store.Compress = cfg.Compress
 
-	client := api.NewClient(cfg)
+	var persona string
+	if cfg.Persona != "" {
+		personas, err := LoadPersonas()
+		if err != nil {
+			return nil, err
+		}
+		text, ok := personas[cfg.Persona]
+		if !ok {
+			return nil, fmt.Errorf("unknown persona %q (see ask --list-personas)", cfg.Persona)
+		}
+		persona = text
+	}
+
+	examples, err := prompt.LoadExamples(filepath.Join(absPath, config.ExamplesFile))
+	if err != nil {
+		return nil, err
+	}
+
+	// Only read when tools are on, so a broken file can't break plain queries
+	var projectTools []ProjectTool
+	if cfg.Tools {
+		if projectTools, err = LoadProjectTools(filepath.Join(absPath, config.ToolsFile)); err != nil {
+			return nil, err
+		}
+	}
 
 	return &Manager{
-		store:  store,
-		config: cfg,
-		client: client,
+		store:        store,
+		config:       cfg,
+		client:       client,
+		persona:      persona,
+		examples:     examples,
+		projectTools: projectTools,
 	}, nil
 }
 
+// LoadPersonas returns the built-in personas merged with custom ones
+// from ~/.config/ask/personas/*.md
+func LoadPersonas() (map[string]string, error) {
+	baseDir, err := config.BaseDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return prompt.LoadPersonas(filepath.Join(baseDir, config.PersonasDir))
+}
+
+// LoadTemplates returns the query templates in ~/.config/ask/templates/*.txt
+func LoadTemplates() (map[string]string, error) {
+	baseDir, err := config.BaseDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return prompt.LoadTemplates(filepath.Join(baseDir, config.TemplatesDir))
+}
+
+// SetConfirm sets the callback used to ask the user before sending large
+// prompts. Without one, prompts over the ASK_CONFIRM_TOKENS threshold are refused.
+func (m *Manager) SetConfirm(confirm func(question string) bool) {
+	m.confirm = confirm
+}
+
+// SetApproveCommand sets the callback that approves each shell command the
+// model asks to run when tools are enabled. Without one, every command is declined.
+func (m *Manager) SetApproveCommand(approve func(command string) bool) {
+	m.approve = approve
+}
+
+// SetChooseResponse sets the callback that picks one of several response
+// choices (ASK_N). It returns the index of the chosen response. Without one,
+// the first choice is used.
+func (m *Manager) SetChooseResponse(choose func(choices []string) int) {
+	m.choose = choose
+}
+
+// SetSaveGuard sets a function called before saving a query's answer or
+// pruned history; the release function it returns is called once the save
+// is done. Callers can use it to hold back Ctrl-C so an answer already paid
+// for isn't lost. Without one, saves aren't guarded.
+func (m *Manager) SetSaveGuard(guard func() (release func())) {
+	m.guard = guard
+}
+
+// saveGuarded saves the store inside the save guard, if one is set
+func (m *Manager) saveGuarded() error {
+	if m.guard != nil {
+		release := m.guard()
+		defer release()
+	}
+	return m.store.Save()
+}
+
 // Query sends a query to the LLM with conversation context
 func (m *Manager) Query(userQuery string) (string, error) {
+	return m.QueryWithFiles(userQuery, nil)
+}
+
+// QueryWithFiles sends a query with the given files attached. The file
+// contents are sent with this query only; the stored message just lists them.
+// Glob patterns in paths are expanded relative to the context directory.
+func (m *Manager) QueryWithFiles(userQuery string, paths []string) (string, error) {
+	return m.QueryWithImages(userQuery, paths, nil)
+}
+
+// QueryWithImages sends a query like QueryWithFiles with images attached as
+// well, for models that accept image input. Like files, the images are sent
+// with this query only.
+func (m *Manager) QueryWithImages(userQuery string, paths, imagePaths []string) (string, error) {
+	if len(imagePaths) > 0 && !api.SupportsVision(m.config.Model) {
+		return "", fmt.Errorf("model %s doesn't accept images; choose a vision model with ASK_MODEL", m.config.Model)
+	}
+
+	paths, err := ExpandAttachments(m.store.Directory, paths)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) > 0 {
+		logging.Infof("Attaching %d file(s): %s\n", len(paths), strings.Join(paths, ", "))
+	}
+
+	attachments, err := ReadAttachments(m.store.Directory, paths)
+	if err != nil {
+		return "", err
+	}
+
+	images, err := ReadImages(m.store.Directory, imagePaths)
+	if err != nil {
+		return "", err
+	}
+
+	results, err := m.search(userQuery)
+	if err != nil {
+		return "", err
+	}
+
+	// Let background pruning from the previous turn finish first
+	m.Wait()
+
+	// Answer an immediate repeat from the stored exchange instead of storing it twice.
+	// Attachments, search results, and tool runs can change between asks, so those always go out.
+	if m.config.Dedup && len(attachments) == 0 && len(images) == 0 && !m.config.Tools && !m.config.Web {
+		if answer, ok := m.store.RepeatedAnswer(userQuery); ok {
+			logging.Infof("Same question as last time; returning the previous answer (ASK_DEDUP)\n")
+			m.usageFooter = formatUsageFooter(m.store.EstimateTokens(), DefaultPruningLimits().MaxTokens)
+			return answer, nil
+		}
+	}
+
+	// Fail fast on a dead local endpoint before anything is stored
+	if err := m.client.Probe(); err != nil {
+		return "", err
+	}
+
 	// Check if we need emergency pruning BEFORE adding messages
 	if err := m.checkEmergencyPrune(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Emergency pruning failed: %v\n", err)
+		logging.Infof("Warning: Emergency pruning failed: %v\n", err)
 	}
 
 	// Add user message to context
-	m.store.AddMessage("user", userQuery)
+	if err := m.store.AddMessage("user", prompt.ImageNote(prompt.AttachmentNote(userQuery, attachments), imagePaths)); err != nil {
+		return "", err
+	}
+
+	// Decide whether this question gets the analysis
+	withAnalysis := m.includeAnalysis(userQuery)
+
+	// Build messages for API with Claude prompt caching if applicable
+	messages := m.queryMessages(userQuery, withAnalysis, attachments, images, results)
+
+	// Warn before the provider rejects a request near the model's limit
+	m.checkContextWindow(messages)
+
+	// Guard against accidentally sending a huge prompt
+	if err := m.confirmPromptSize(messages); err != nil {
+		m.store.removeLastMessage()
+		return "", err
+	}
+
+	// Get response from API while showing a spinner, running any approved
+	// tool calls along the way
+	start := time.Now()
+	completion, messages, err := m.completeQuery(messages)
+
+	// If the provider rejects the request as too long, drop progressively more
+	// context and retry a few times before giving up
+	stage := 0
+	for attempt := 1; errors.Is(err, api.ErrContextLengthExceeded) && attempt <= MaxContextRetries; attempt++ {
+		var dropped string
+		stage, dropped = m.shrinkForContextRetry(stage)
+		if dropped == "" {
+			break // Nothing left to drop
+		}
+		logging.Infof("⚠️  Request exceeded the model's context window; %s and retrying (%d/%d)\n",
+			dropped, attempt, MaxContextRetries)
+
+		completion, messages, err = m.completeQuery(m.queryMessages(userQuery, withAnalysis, attachments, images, results))
+	}
+	if errors.Is(err, api.ErrContextLengthExceeded) {
+		return "", fmt.Errorf("%w (even after pruning; shorten the query or attach fewer files)", err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("API request failed: %w", err)
+	}
+
+	// Stitch together length-truncated answers if enabled
+	if completion.Truncated() && m.config.AutoContinue {
+		completion = m.continueCompletion(messages, completion)
+	}
+	response := completion.Content
+	m.fingerprint = completion.SystemFingerprint
+	m.latency = time.Since(start)
+
+	// Record the turn in the audit log before anything can prune it
+	m.audit(userQuery, attachments, completion)
+
+	// Add assistant response to context
+	if err := m.store.AddMessage("assistant", response); err != nil {
+		return "", err
+	}
+	m.store.Messages[len(m.store.Messages)-1].FinishReason = completion.FinishReason
+
+	// Flag answers cut off by the output token limit (the stored copy stays clean)
+	if completion.Truncated() {
+		logging.Infof("⚠️  Warning: Response truncated (model hit its output token limit)\n")
+		response += "\n\n" + TruncationNotice
+	}
+
+	// Check if we're way over limits after adding response
+	if err := m.checkEmergencyPrune(); err != nil {
+		logging.Infof("Warning: Emergency pruning failed: %v\n", err)
+	}
+
+	// Save context before normal pruning so the answer is never blocked on it.
+	// The answer was already paid for, so a failed save doesn't discard it.
+	if err := m.saveGuarded(); err != nil {
+		logging.Infof("⚠️  Warning: Failed to save context (this exchange won't be remembered): %v\n", err)
+	}
+
+	// Measure what pruning measures: the stored history against the hard limit
+	m.usageFooter = formatUsageFooter(m.store.EstimateTokens(), DefaultPruningLimits().MaxTokens)
+	if cache := formatCacheUsage(completion.Usage, m.client.UncachedPromptTokens(completion.Usage)); cache != "" {
+		m.usageFooter = strings.TrimSuffix(m.usageFooter, ")") + "; " + cache + ")"
+	}
+
+	// Normal pruning may call the API, so run it in the background.
+	// Wait() must be called before the store is used again.
+	m.pruning.Add(1)
+	go func() {
+		defer m.pruning.Done()
+		m.pruneAndSave()
+	}()
+
+	return response, nil
+}
+
+// UsageFooter returns how full the context was after the last query, e.g.
+// "(context: 18k/25k tokens)", or "" if no query has been made. Once the
+// estimate reaches the limit, older messages start being pruned. When Claude's
+// prompt cache was used, the split between cached and fresh prompt tokens is
+// added, e.g. "(context: 18k/25k tokens; prompt cache: 4k read, 1k fresh)".
+func (m *Manager) UsageFooter() string {
+	return m.usageFooter
+}
+
+// SystemFingerprint returns the system_fingerprint the provider reported for
+// the last answer, or "" if it reported none. A change between runs with the
+// same seed means the backend changed and output may differ.
+func (m *Manager) SystemFingerprint() string {
+	return m.fingerprint
+}
+
+// Latency returns the wall-clock time spent waiting on the provider for the
+// last answer, including any retries and continuations, or 0 if no query has
+// been answered
+func (m *Manager) Latency() time.Duration {
+	return m.latency
+}
+
+// formatUsageFooter formats a token count against a limit for UsageFooter
+func formatUsageFooter(tokens, limit int) string {
+	return fmt.Sprintf("(context: %s/%s tokens)", formatTokenCount(tokens), formatTokenCount(limit))
+}
+
+// formatCacheUsage reports how many prompt tokens were served from the
+// prompt cache versus processed fresh (written to the cache or uncached), or
+// "" if the provider reported no cache activity. How uncached tokens are
+// counted depends on the provider (see api.Client.UncachedPromptTokens).
+func formatCacheUsage(usage api.Usage, uncached int) string {
+	read := usage.CacheReadInputTokens
+	if read == 0 && usage.CacheCreationInputTokens == 0 {
+		return ""
+	}
+	fresh := usage.CacheCreationInputTokens + uncached
+	return fmt.Sprintf("prompt cache: %s read, %s fresh", formatTokenCount(read), formatTokenCount(fresh))
+}
+
+// formatFileSize describes a context file's size, with the savings when it
+// is compressed, e.g. "12.0 KB (compressed from 84.1 KB, 86% smaller)"
+func formatFileSize(disk, uncompressed int64) string {
+	size := formatBytes(disk)
+	if uncompressed > disk {
+		saved := 100 * (uncompressed - disk) / uncompressed
+		size += fmt.Sprintf(" (compressed from %s, %d%% smaller)", formatBytes(uncompressed), saved)
+	}
+	return size
+}
+
+// formatBytes formats a byte count, e.g. "512 B" or "84.1 KB"
+func formatBytes(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+}
+
+// formatTokenCount abbreviates counts of 1000 or more to the nearest thousand
+func formatTokenCount(n int) string {
+	if n < 1000 {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("%dk", (n+500)/1000)
+}
+
+// MaxContextRetries is how many times a request the provider rejected as too
+// long is retried, each time with less context
+const MaxContextRetries = 3
+
+// Stages of shrinkForContextRetry, from least to most context dropped
+const (
+	retryPruneHard      = iota // Prune the oldest messages down to the pruning target
+	retryPruneAll              // Drop everything but pinned messages and the last exchanges
+	retryShrinkAnalysis        // Halve the project analysis
+	retryClearAnalysis         // Drop the project analysis entirely
+	retryStages
+)
+
+// shrinkForContextRetry drops more context after the provider rejected a
+// request as too long, starting at stage and skipping stages that would
+// drop nothing. It returns the stage to continue from on the next retry and
+// a description of what was dropped, or "" once nothing more can go.
+func (m *Manager) shrinkForContextRetry(stage int) (int, string) {
+	pruner := NewPruner(m.store, m.client, NewPreservationRules(m.config))
+
+	for ; stage < retryStages; stage++ {
+		before := len(m.store.Messages)
+		analysisTokens := m.estimateAnalysisCacheTokens()
+
+		switch stage {
+		case retryPruneHard:
+			_ = pruner.pruneHard()
+		case retryPruneAll:
+			pruner.limits.TargetMessages = 0
+			_ = pruner.pruneHard()
+		case retryShrinkAnalysis:
+			if analysisTokens > 0 && m.shrinkAnalysis(analysisTokens/2) {
+				return stage + 1, fmt.Sprintf("shrank the project analysis from ~%s to ~%s tokens",
+					formatTokenCount(analysisTokens), formatTokenCount(m.estimateAnalysisCacheTokens()))
+			}
+		case retryClearAnalysis:
+			if m.ownAnalysis() != nil {
+				m.clearAnalysis()
+				return stage + 1, fmt.Sprintf("cleared the project analysis (~%s tokens)", formatTokenCount(analysisTokens))
+			}
+		}
+
+		if removed := before - len(m.store.Messages); removed > 0 {
+			return stage + 1, fmt.Sprintf("removed %d older message(s) (~%s tokens left)",
+				removed, formatTokenCount(m.store.EstimateTokens()))
+		}
+	}
+
+	return stage, ""
+}
+
+// expandBlobs swaps offloaded messages' references in messages for their full
+// content: the question being asked, and any earlier one the query mentions
+// by blob ID. The stored context keeps only the references.
+func (m *Manager) expandBlobs(messages []api.ChatMessage, query string) {
+	for i, msg := range m.store.Messages {
+		if msg.Blob == "" || (i < len(m.store.Messages)-1 && !strings.Contains(query, msg.Blob)) {
+			continue
+		}
+
+		content, err := m.store.LoadBlob(msg.Blob)
+		if err != nil {
+			logging.Infof("⚠️  Warning: %v; sending only its reference\n", err)
+			continue
+		}
+		for j := range messages {
+			if messages[j].Content == msg.Content {
+				messages[j].Content = content
+			}
+		}
+	}
+}
+
+// queryMessages builds the request for the just-added user message,
+// sending attachment contents and images in place of the stored note and
+// any search results in a message just before it
+func (m *Manager) queryMessages(userQuery string, withAnalysis bool, attachments []prompt.Attachment, images []api.ContentPart, results string) []api.ChatMessage {
+	messages := m.buildMessages(withAnalysis)
+	m.expandBlobs(messages, userQuery)
+	last := &messages[len(messages)-1]
+	if len(attachments) > 0 || len(images) > 0 {
+		last.Content = prompt.QueryWithAttachments(userQuery, attachments)
+	}
+	if len(images) > 0 {
+		last.Parts = append([]api.ContentPart{api.TextPart(last.Content)}, images...)
+	}
+	if results != "" {
+		search := api.ChatMessage{Role: "user", Content: prompt.SearchResults(results)}
+		messages = slices.Insert(messages, len(messages)-1, search)
+	}
+	return messages
+}
+
+// search runs ASK_SEARCH_CMD for the query when web search is enabled,
+// returning its results ("" when disabled or nothing was found)
+func (m *Manager) search(userQuery string) (string, error) {
+	if !m.config.Web {
+		return "", nil
+	}
+	if m.config.SearchCmd == "" {
+		return "", ErrNoSearchCmd
+	}
+
+	logging.Infof("Searching with ASK_SEARCH_CMD...\n")
+	results, err := Search(m.store.Directory, m.config.SearchCmd, userQuery)
+	if err != nil {
+		return "", err
+	}
+	if results == "" {
+		logging.Infof("Search returned no results; asking without them\n")
+	}
+	return results, nil
+}
+
+// completeQuery sends the request, running approved tool calls if enabled.
+// It returns the messages including any tool exchange. Deterministic
+// requests are answered from the response cache when possible.
+func (m *Manager) completeQuery(messages []api.ChatMessage) (api.Completion, []api.ChatMessage, error) {
+	if m.config.Tools {
+		return m.completeWithTools(messages)
+	}
+
+	if !m.responseCacheable() {
+		completion, err := m.completeWith(func() (api.Completion, error) {
+			return m.client.CompleteChoices(messages, m.config.Choices)
+		})
+		return m.pickChoice(completion), messages, err
+	}
+
+	key := m.responseCacheKey(messages)
+	if completion, ok := m.cachedCompletion(key); ok {
+		logging.Infof("Using cached response (--no-cache to bypass)\n")
+		return completion, messages, nil
+	}
+
+	completion, err := m.complete(messages)
+	if err == nil {
+		if err := m.cacheCompletion(key, completion); err != nil {
+			logging.Infof("Warning: Failed to cache response: %v\n", err)
+		}
+	}
+	return completion, messages, err
+}
+
+// pickChoice narrows a multi-choice completion to the one the user picks,
+// or the first if no chooser is set. Usage still covers every choice.
+func (m *Manager) pickChoice(completion api.Completion) api.Completion {
+	if len(completion.Choices) < 2 {
+		return completion
+	}
+
+	index := 0
+	if m.choose != nil {
+		contents := make([]string, len(completion.Choices))
+		for i, choice := range completion.Choices {
+			contents[i] = choice.Content
+		}
+		if i := m.choose(contents); i >= 0 && i < len(contents) {
+			index = i
+		}
+	}
+
+	chosen := completion.Choices[index]
+	chosen.Usage = completion.Usage
+	chosen.SystemFingerprint = completion.SystemFingerprint
+	return chosen
+}
 
+// Wait blocks until background pruning from the last query has finished
+func (m *Manager) Wait() {
+	m.pruning.Wait()
+}
+
+// pruneAndSave runs normal pruning and persists the result if anything changed
+func (m *Manager) pruneAndSave() {
+	pruned, err := m.checkAndPrune()
+	if err != nil {
+		// Log warning but don't fail the query
+		logging.Infof("Warning: Context pruning failed: %v\n", err)
+		return
+	}
+
+	if pruned {
+		if err := m.saveGuarded(); err != nil {
+			logging.Infof("Warning: Failed to save pruned context: %v\n", err)
+		}
+	}
+}
+
+// buildMessages converts the stored conversation into API messages, with the
+// analysis if withAnalysis is set and there is one
+func (m *Manager) buildMessages(withAnalysis bool) []api.ChatMessage {
 	// Convert store messages to prompt messages
 	promptMessages := make([]prompt.Message, len(m.store.Messages))
 	for i, msg := range m.store.Messages {
@@ -66,17 +621,254 @@ func (m *Manager) Query(userQuery string) (string, error) {
 
 	// Convert analysis cache if present
 	var analysis *prompt.AnalysisCache
-	if m.store.AnalysisCache != nil {
+	if cache := m.analysisCache(); cache != nil && withAnalysis {
 		analysis = &prompt.AnalysisCache{
-			FileTree:       m.store.AnalysisCache.FileTree,
-			ReadmeContent:  m.store.AnalysisCache.ReadmeContent,
-			PrimaryConfigs: m.store.AnalysisCache.PrimaryConfigs,
+			FileTree:       cache.FileTree,
+			ReadmeContent:  cache.ReadmeContent,
+			PrimaryConfigs: cache.PrimaryConfigs,
+			Untrusted:      cache.Untrusted,
 		}
 	}
 
-	// Build messages for API with Claude prompt caching if applicable
-	useClaudeCache := m.client.IsClaudeAPI()
-	messages := prompt.BuildMessages(m.store.Directory, m.config.OS, promptMessages, analysis, useClaudeCache)
+	opts := prompt.BuildOptions{
+		Directory: m.store.Directory,
+		OS:        prompt.Platform(m.config.OS, m.config.Distro, m.config.Shell),
+		Messages:  promptMessages,
+		Examples:  m.examples,
+		Analysis:  analysis,
+	}
+	opts.Persona = m.persona
+	opts.InstructionRole = api.RoleSystem
+	if m.config.RawPrompt {
+		opts.Mode = prompt.ModeRaw
+	}
+	switch {
+	case m.config.Format == config.FormatShell:
+		opts.Format = prompt.FormatShell
+	case m.config.Markdown || m.config.Format == config.FormatMarkdown:
+		opts.Format = prompt.FormatMarkdown
+	}
+
+	// Without a client (offline estimates) use the plain system role
+	if m.client != nil {
+		opts.InstructionRole = m.client.InstructionRole()
+		opts.UseClaudeCache = m.client.IsClaudeAPI()
+	}
+	return prompt.BuildMessages(opts)
+}
+
+// RequestTokens estimates the tokens of the full request the next query
+// would send: the freshly built system prompt, analysis, persona, and history
+func (m *Manager) RequestTokens() int {
+	return EstimateRequestTokens(m.buildMessages(true))
+}
+
+// includeAnalysis reports whether a query should be sent with the analysis
+// under --no-analysis and ASK_ANALYSIS_MODE, noting under --verbose when auto
+// mode leaves it out
+func (m *Manager) includeAnalysis(query string) bool {
+	if m.config.NoAnalysis {
+		return false
+	}
+
+	switch m.config.AnalysisMode {
+	case config.AnalysisNever:
+		return false
+	case config.AnalysisAuto:
+		if MentionsCodebase(query) {
+			return true
+		}
+		if m.config.Verbose && m.analysisCache() != nil {
+			logging.Infof("Leaving the analysis out of this question (ASK_ANALYSIS_MODE=auto)\n")
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// analysisCache returns this directory's analysis, falling back to the
+// nearest analyzed ancestor's when analysis sharing is enabled
+func (m *Manager) analysisCache() *AnalysisCache {
+	if m.ephemeralAnalysis != nil {
+		return m.ephemeralAnalysis
+	}
+	if m.store.AnalysisCache != nil || !m.config.ShareAnalysis {
+		return m.store.AnalysisCache
+	}
+
+	// Follow the stored parent reference first
+	if m.store.AnalysisParent != "" {
+		if parent, err := Load(m.store.AnalysisParent, m.store.encryptionKey); err == nil && parent.AnalysisCache != nil {
+			return parent.AnalysisCache
+		}
+	}
+
+	parent := FindAncestorAnalysis(m.store.Directory, m.store.encryptionKey)
+	if parent == nil {
+		m.store.AnalysisParent = ""
+		return nil
+	}
+
+	m.store.AnalysisParent = parent.Directory
+	return parent.AnalysisCache
+}
+
+// contextWindowWarnRatio is the share of a model's context window at which
+// requests trigger a warning
+const contextWindowWarnRatio = 0.8
+
+// checkContextWindow warns when the request is close to the model's known
+// context window. Unknown models are skipped.
+func (m *Manager) checkContextWindow(messages []api.ChatMessage) {
+	window := api.ContextWindow(m.config.Model)
+	if window == 0 {
+		return
+	}
+
+	tokens := EstimateRequestTokens(messages)
+	if float64(tokens) < float64(window)*contextWindowWarnRatio {
+		return
+	}
+
+	logging.Infof("⚠️  Warning: Request is ~%d tokens, close to %s's %d-token context window\n",
+		tokens, m.config.Model, window)
+	if m.analysisCache() != nil {
+		logging.Infof("   The directory analysis takes up part of it: clear it with 'ask --reset' or use --analyze --ephemeral,\n")
+		logging.Infof("   or switch to a model with a larger context (ASK_MODEL)\n")
+	} else {
+		logging.Infof("   Consider 'ask --summarize --save' or switching to a model with a larger context (ASK_MODEL)\n")
+	}
+}
+
+// confirmPromptSize asks for confirmation when the assembled prompt is
+// estimated to exceed the configured token threshold
+func (m *Manager) confirmPromptSize(messages []api.ChatMessage) error {
+	if m.config.ConfirmTokens <= 0 {
+		return nil
+	}
+
+	tokens := EstimateRequestTokens(messages)
+	if tokens <= m.config.ConfirmTokens {
+		return nil
+	}
+
+	question := fmt.Sprintf("This query is estimated at %d prompt tokens (ASK_CONFIRM_TOKENS=%d). Send anyway?",
+		tokens, m.config.ConfirmTokens)
+	if m.confirm == nil || !m.confirm(question) {
+		return fmt.Errorf("query cancelled: estimated %d prompt tokens exceeds ASK_CONFIRM_TOKENS (%d)",
+			tokens, m.config.ConfirmTokens)
+	}
+
+	return nil
+}
+
+// EstimateRequestTokens estimates the tokens of assembled API messages
+// using the same ~3.5 chars per token heuristic as the store
+func EstimateRequestTokens(messages []api.ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += int(float64(len(msg.Content)) / 3.5)
+		for _, call := range msg.ToolCalls {
+			total += int(float64(len(call.Function.Arguments)) / 3.5)
+		}
+		total += 4 // Message structure overhead
+	}
+	return total
+}
+
+// TruncationNotice is appended to responses cut off by the output token limit
+const TruncationNotice = "[Response truncated: the model hit its output token limit. Run ask --continue to get the rest, or use --complete to continue automatically.]"
+
+// MaxContinuations bounds how many follow-ups are sent for one truncated answer
+const MaxContinuations = 3
+
+// ContinuePrompt asks the model to resume a truncated answer
+const ContinuePrompt = "Your previous answer was cut off. Continue exactly where you left off, without repeating anything."
+
+// LastAnswerTruncated reports whether the last stored answer was cut off by
+// the output token limit, so asking ContinuePrompt would get the rest
+func (m *Manager) LastAnswerTruncated() bool {
+	m.Wait()
+
+	n := len(m.store.Messages)
+	return n > 0 && m.store.Messages[n-1].Role == "assistant" && m.store.Messages[n-1].FinishReason == api.FinishReasonLength
+}
+
+// continueCompletion requests continuations of a truncated answer and joins
+// them into one response. It stops when the answer is complete, after
+// MaxContinuations follow-ups, or when the prompt would exceed the token limit.
+func (m *Manager) continueCompletion(messages []api.ChatMessage, completion api.Completion) api.Completion {
+	maxTokens := DefaultPruningLimits().MaxTokens
+
+	for i := 0; i < MaxContinuations && completion.Truncated(); i++ {
+		followUp := make([]api.ChatMessage, len(messages), len(messages)+2)
+		copy(followUp, messages)
+		followUp = append(followUp,
+			api.ChatMessage{Role: "assistant", Content: completion.Content},
+			api.ChatMessage{Role: "user", Content: ContinuePrompt},
+		)
+
+		if tokens := EstimateRequestTokens(followUp); tokens > maxTokens {
+			logging.Infof("Warning: Not continuing truncated response (%d tokens would exceed limit of %d)\n",
+				tokens, maxTokens)
+			break
+		}
+
+		logging.Infof("Response truncated, requesting continuation (%d/%d)...\n", i+1, MaxContinuations)
+		next, err := m.complete(followUp)
+		if err != nil {
+			logging.Infof("Warning: Continuation failed: %v\n", err)
+			break
+		}
+
+		completion = api.Completion{
+			Content:      joinContinuation(completion.Content, next.Content),
+			FinishReason: next.FinishReason,
+			Usage:        completion.Usage.Add(next.Usage),
+
+			SystemFingerprint: next.SystemFingerprint,
+		}
+	}
+
+	return completion
+}
+
+// joinContinuation appends next to prev, dropping any text the model
+// repeated from the end of prev at the start of next
+func joinContinuation(prev, next string) string {
+	const minOverlap = 8
+	const maxOverlap = 500
+
+	limit := len(next)
+	if len(prev) < limit {
+		limit = len(prev)
+	}
+	if limit > maxOverlap {
+		limit = maxOverlap
+	}
+
+	for n := limit; n >= minOverlap; n-- {
+		if strings.HasSuffix(prev, next[:n]) {
+			return prev + next[n:]
+		}
+	}
+
+	return prev + next
+}
+
+// complete sends messages to the API while showing a spinner
+func (m *Manager) complete(messages []api.ChatMessage) (api.Completion, error) {
+	return m.completeWith(func() (api.Completion, error) {
+		return m.client.Complete(messages)
+	})
+}
+
+// completeWith runs an API request while showing a spinner (unless quiet)
+func (m *Manager) completeWith(request func() (api.Completion, error)) (api.Completion, error) {
+	if logging.Quiet() {
+		return request()
+	}
 
 	// Start spinner while waiting for API response
 	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
@@ -86,40 +878,90 @@ func (m *Manager) Query(userQuery string) (string, error) {
 	s.Start()
 
 	// Get response from API (blocking call)
-	response, err := m.client.ChatCompletion(messages)
+	completion, err := request()
 
 	// Stop spinner regardless of success or error
 	s.Stop()
 
+	return completion, err
+}
+
+// Summarize asks the model for a structured summary of the conversation.
+// If save is true, the history is replaced by the summary as a seed message.
+func (m *Manager) Summarize(save bool) (string, error) {
+	m.Wait()
+
+	if len(m.store.Messages) == 0 {
+		return "", fmt.Errorf("no conversation to summarize")
+	}
+
+	messages := append(m.buildMessages(true), api.ChatMessage{
+		Role:    "user",
+		Content: prompt.SummaryRequest(),
+	})
+
+	completion, err := m.complete(messages)
 	if err != nil {
 		return "", fmt.Errorf("API request failed: %w", err)
 	}
+	summary := completion.Content
 
-	// Add assistant response to context
-	m.store.AddMessage("assistant", response)
+	if save {
+		m.store.SeedWithSummary(summary)
+		if err := m.store.Save(); err != nil {
+			return "", fmt.Errorf("failed to save context: %w", err)
+		}
+	}
 
-	// Check if we're way over limits after adding response
-	if err := m.checkEmergencyPrune(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Emergency pruning failed: %v\n", err)
+	return summary, nil
+}
+
+// Replay re-sends the last question with the conversation before it, e.g. to
+// compare another model's answer. The stored context is left alone unless
+// save is true, which replaces the question's stored answer with the new one.
+func (m *Manager) Replay(save bool) (string, error) {
+	m.Wait()
+
+	last := -1
+	for i, msg := range m.store.Messages {
+		if msg.Role == "user" {
+			last = i
+		}
+	}
+	if last < 0 {
+		return "", fmt.Errorf("no question to replay")
 	}
 
-	// Check if normal pruning is needed
-	if err := m.checkAndPrune(); err != nil {
-		// Log warning but don't fail the query
-		fmt.Fprintf(os.Stderr, "Warning: Context pruning failed: %v\n", err)
+	// Build the request from the history up to the question, as it was asked
+	history := m.store.Messages
+	m.store.Messages = history[:last+1]
+	messages := m.buildMessages(true)
+	m.expandBlobs(messages, "")
+	m.store.Messages = history
+
+	completion, err := m.complete(messages)
+	if err != nil {
+		return "", fmt.Errorf("API request failed: %w", err)
 	}
+	m.fingerprint = completion.SystemFingerprint
 
-	// Save context
-	if err := m.store.Save(); err != nil {
-		return "", fmt.Errorf("failed to save context: %w", err)
+	if save {
+		m.store.Messages = history[:last+1]
+		if err := m.store.AddMessage("assistant", completion.Content); err != nil {
+			return "", err
+		}
+		if err := m.store.Save(); err != nil {
+			return "", fmt.Errorf("failed to save context: %w", err)
+		}
 	}
 
-	return response, nil
+	return completion.Content, nil
 }
 
-// checkEmergencyPrune performs aggressive pruning if we're way over limits
+// checkEmergencyPrune performs aggressive pruning if the fully assembled
+// request (not just the stored messages) is way over limits
 func (m *Manager) checkEmergencyPrune() error {
-	tokens := m.store.EstimateTokens()
+	tokens := m.RequestTokens()
 	messages := len(m.store.Messages)
 
 	// Emergency thresholds (150% of hard limits)
@@ -127,85 +969,157 @@ func (m *Manager) checkEmergencyPrune() error {
 	emergencyMessages := 150  // 1.5 * 100
 
 	if tokens > emergencyTokens || messages > emergencyMessages {
-		fmt.Fprintf(os.Stderr, "⚠️  Emergency pruning: context way over limits (%d tokens, %d messages)\n",
+		logging.Infof("⚠️  Emergency pruning: context way over limits (%d tokens, %d messages)\n",
 			tokens, messages)
 
 		// Check if the problem is the analysis cache
-		if m.store.AnalysisCache != nil {
+		if m.ownAnalysis() != nil {
 			analysisTokens := m.estimateAnalysisCacheTokens()
 
-			// If analysis cache is > 50% of the tokens, it's the problem
+			// If analysis cache is > 50% of the tokens, it's the problem.
+			// Shrink it to what fits under the hard limit alongside the
+			// messages, and clear it only if not even a summary fits.
 			if analysisTokens > tokens/2 {
-				fmt.Fprintf(os.Stderr, "⚠️  Analysis cache is the issue (%d of %d tokens) - clearing it\n",
-					analysisTokens, tokens)
+				budget := DefaultPruningLimits().MaxTokens - (tokens - analysisTokens)
+				if m.shrinkAnalysis(budget) {
+					logging.Infof("⚠️  Analysis cache is the issue (%d of %d tokens) - shrinking it to fit %d tokens\n",
+						analysisTokens, tokens, budget)
+				} else {
+					logging.Infof("⚠️  Analysis cache is the issue (%d of %d tokens) - clearing it\n",
+						analysisTokens, tokens)
+					m.clearAnalysis()
+				}
 
-				// Clear the analysis cache entirely
-				m.store.AnalysisCache = nil
-				m.store.LastAnalysisAt = nil
-
-				fmt.Fprintf(os.Stderr, "Analysis cache cleared. Tokens reduced from %d to %d\n",
-					tokens, m.store.EstimateTokens())
-
-				// Re-check tokens after clearing analysis
-				tokens = m.store.EstimateTokens()
+				// Re-check tokens after shrinking the analysis
+				reduced := m.RequestTokens()
+				logging.Infof("Tokens reduced from %d to %d\n", tokens, reduced)
+				tokens = reduced
 			}
 		}
 
 		// If still over limits, prune messages
 		if tokens > emergencyTokens || messages > emergencyMessages {
-			pruner := NewPruner(m.store, m.client)
+			pruner := NewPruner(m.store, m.client, NewPreservationRules(m.config))
 			if err := pruner.pruneHard(); err != nil {
 				return err
 			}
 
-			fmt.Fprintf(os.Stderr, "Emergency pruning complete: %d messages remain (%d tokens)\n",
-				len(m.store.Messages), m.store.EstimateTokens())
+			logging.Infof("Emergency pruning complete: %d messages remain (%d tokens)\n",
+				len(m.store.Messages), m.RequestTokens())
 		}
 	}
 
 	return nil
 }
 
-// estimateAnalysisCacheTokens estimates tokens used by analysis cache
+// MinAnalysisTokens is the smallest analysis emergency pruning keeps; with a
+// smaller budget the analysis is cleared instead of shrunk
+const MinAnalysisTokens = 500
+
+// analysisShrunkNote ends a file tree that emergency pruning cut short
+const analysisShrunkNote = "[File tree shortened to fit the context budget; run --analyze to refresh]\n"
+
+// ownAnalysis returns the analysis this directory's requests carry that
+// pruning may shrink: the ephemeral one if set, otherwise the stored one.
+// An ancestor's shared analysis is left alone.
+func (m *Manager) ownAnalysis() *AnalysisCache {
+	if m.ephemeralAnalysis != nil {
+		return m.ephemeralAnalysis
+	}
+	return m.store.AnalysisCache
+}
+
+// clearAnalysis drops both the ephemeral and the stored analysis, so
+// clearing one doesn't bring the other into the request
+func (m *Manager) clearAnalysis() {
+	m.ephemeralAnalysis = nil
+	m.store.AnalysisCache = nil
+	m.store.LastAnalysisAt = nil
+}
+
+// shrinkAnalysis shrinks ownAnalysis to about budget tokens, dropping
+// the README first and then cutting the file tree at a line, so a summary of
+// the structure survives. It reports false, changing nothing, if budget is
+// below MinAnalysisTokens.
+func (m *Manager) shrinkAnalysis(budget int) bool {
+	cache := m.ownAnalysis()
+	if cache == nil || budget < MinAnalysisTokens {
+		return false
+	}
+	if m.estimateAnalysisCacheTokens() <= budget {
+		return true
+	}
+
+	cache.ReadmeContent = ""
+	if m.estimateAnalysisCacheTokens() <= budget {
+		return true
+	}
+
+	// Cut the tree to the remaining budget, using the estimate's 3.5 chars per token
+	treeChars := float64(budget-len(cache.PrimaryConfigs)*2)*3.5 - float64(len(analysisShrunkNote))
+	keep := min(int(treeChars), len(cache.FileTree))
+	cut := strings.LastIndex(cache.FileTree[:max(keep, 0)], "\n") + 1
+	cache.FileTree = cache.FileTree[:cut] + analysisShrunkNote
+	return true
+}
+
+// estimateAnalysisCacheTokens estimates tokens used by ownAnalysis
 func (m *Manager) estimateAnalysisCacheTokens() int {
-	if m.store.AnalysisCache == nil {
+	cache := m.ownAnalysis()
+	if cache == nil {
 		return 0
 	}
 
 	tokens := 0
 	// File tree tokens
-	tokens += int(float64(len(m.store.AnalysisCache.FileTree)) / 3.5)
+	tokens += int(float64(len(cache.FileTree)) / 3.5)
 	// README tokens
-	tokens += int(float64(len(m.store.AnalysisCache.ReadmeContent)) / 3.5)
+	tokens += int(float64(len(cache.ReadmeContent)) / 3.5)
 	// Config list overhead
-	tokens += len(m.store.AnalysisCache.PrimaryConfigs) * 2
+	tokens += len(cache.PrimaryConfigs) * 2
 
 	return tokens
 }
 
-// checkAndPrune checks if pruning is needed and performs it
-func (m *Manager) checkAndPrune() error {
-	pruner := NewPruner(m.store, m.client)
+// newPruner returns a pruner for the store that selects messages with client
+// (nil for oldest-first pruning), using embeddings and explanations as configured
+func (m *Manager) newPruner(client *api.Client) *Pruner {
+	pruner := NewPruner(m.store, client, NewPreservationRules(m.config))
+	if m.config.EmbeddingsURL != "" && client != nil {
+		pruner.SetEmbedder(client)
+	}
+	pruner.SetModel(m.config.PruningModel())
+	pruner.SetVerbose(m.config.Verbose)
+	pruner.SetExplain(m.config.ExplainPrune)
+	return pruner
+}
+
+// checkAndPrune checks if pruning is needed and performs it.
+// Returns true if the context was pruned.
+func (m *Manager) checkAndPrune() (bool, error) {
+	pruner := m.newPruner(m.client)
 
 	shouldPrune, reason := pruner.ShouldPrune()
 	if !shouldPrune {
-		return nil
+		return false, nil
 	}
 
-	fmt.Fprintf(os.Stderr, "Context pruning triggered: %s\n", reason)
+	logging.Infof("Context pruning triggered: %s\n", reason)
 
 	if err := pruner.Prune(); err != nil {
-		return fmt.Errorf("pruning failed: %w", err)
+		return false, fmt.Errorf("pruning failed: %w", err)
 	}
 
-	fmt.Fprintf(os.Stderr, "Context pruned: %d messages remain (%d tokens estimated)\n",
+	logging.Infof("Context pruned: %d messages remain (%d tokens estimated)\n",
 		len(m.store.Messages), m.store.EstimateTokens())
 
-	return nil
+	return true, nil
 }
 
 // Reset clears the conversation context
 func (m *Manager) Reset() error {
+	m.Wait()
+
 	m.store.Reset()
 	if err := m.store.Save(); err != nil {
 		return fmt.Errorf("failed to save reset context: %w", err)
@@ -213,9 +1127,158 @@ func (m *Manager) Reset() error {
 	return nil
 }
 
+// Trim removes messages older than cutoff, keeping pinned messages and the
+// most recent exchanges, and saves. Returns how many messages were removed.
+func (m *Manager) Trim(cutoff time.Time) (int, error) {
+	m.Wait()
+
+	pruner := NewPruner(m.store, m.client, NewPreservationRules(m.config))
+	removed := pruner.TrimBefore(cutoff)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if err := m.store.Save(); err != nil {
+		return 0, fmt.Errorf("failed to save trimmed context: %w", err)
+	}
+	return removed, nil
+}
+
+// WarmCache sends a minimal request carrying just the cacheable system
+// prompt (instructions plus analysis) so Claude caches it and the next
+// query reads it from the cache. Returns the usage the provider reported.
+func (m *Manager) WarmCache() (api.Usage, error) {
+	if m.client == nil || !m.client.IsClaudeAPI() {
+		return api.Usage{}, fmt.Errorf("prompt cache warming only works with the Claude API")
+	}
+
+	m.Wait()
+
+	messages := m.buildMessages(true)
+	if len(messages) == 0 || messages[0].CacheControl == nil {
+		return api.Usage{}, fmt.Errorf("no cacheable system prompt to warm")
+	}
+	priming := []api.ChatMessage{messages[0], {Role: "user", Content: "Reply with OK."}}
+
+	completion, err := m.complete(priming)
+	if err != nil {
+		return api.Usage{}, fmt.Errorf("cache warming request failed: %w", err)
+	}
+	return completion.Usage, nil
+}
+
+// PruneResult describes the context before and after an explicit prune
+type PruneResult struct {
+	MessagesBefore int
+	MessagesAfter  int
+	TokensBefore   int
+	TokensAfter    int
+}
+
+// Prune prunes the context on demand and saves it. The model selects what
+// to drop when an API key is configured; otherwise the oldest unprotected
+// messages are removed.
+func (m *Manager) Prune() (PruneResult, error) {
+	m.Wait()
+
+	result := PruneResult{
+		MessagesBefore: len(m.store.Messages),
+		TokensBefore:   m.store.EstimateTokens(),
+	}
+
+	client := m.client
+	if m.config.APIKey == "" {
+		client = nil
+	}
+	if err := m.newPruner(client).PruneNow(); err != nil {
+		return result, fmt.Errorf("pruning failed: %w", err)
+	}
+
+	result.MessagesAfter = len(m.store.Messages)
+	result.TokensAfter = m.store.EstimateTokens()
+	if result.MessagesAfter == result.MessagesBefore {
+		return result, nil
+	}
+
+	if err := m.store.Save(); err != nil {
+		return result, fmt.Errorf("failed to save pruned context: %w", err)
+	}
+	return result, nil
+}
+
+// AnalyzeEphemeral performs directory analysis for this manager's queries
+// only. The result is never written to the stored analysis cache.
+func (m *Manager) AnalyzeEphemeral() error {
+	cache, err := m.newAnalyzer().Analyze()
+	if err != nil {
+		return fmt.Errorf("analysis failed: %w", err)
+	}
+
+	m.ephemeralAnalysis = cache
+	return nil
+}
+
+// loadStore loads the context for directory: the explicit ASK_CONTEXT_FILE
+// if set, otherwise the current session's file under ~/.config/ask/contexts
+func loadStore(cfg *config.Config, directory string) (*Store, error) {
+	key := DeriveKey(cfg.EncryptionKey)
+	if cfg.ContextFile != "" {
+		store, err := LoadFile(cfg.ContextFile, directory, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load context: %w", err)
+		}
+		return store, nil
+	}
+
+	// A context directory that can't be written would turn every answer into
+	// a save error after the request was paid for, so detect it up front and
+	// keep this session in memory instead
+	writeErr := CheckWritable()
+
+	session := cfg.Session
+	if session == "" {
+		session = CurrentSession(directory)
+	}
+	session, err := NormalizeSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := LoadSession(directory, session, key)
+	if err != nil {
+		// Likewise fall back if the file can't even be read (but not if it is
+		// corrupt or encrypted, which a fresh session would hide)
+		var pathErr *fs.PathError
+		if writeErr == nil || !errors.As(err, &pathErr) {
+			return nil, fmt.Errorf("failed to load context: %w", err)
+		}
+		store = NewStore(directory)
+		store.Session = session
+	}
+	if writeErr != nil {
+		logging.Infof("⚠️  Warning: %v; this session won't be saved\n", writeErr)
+		store.InMemory = true
+	}
+	return store, nil
+}
+
+// newAnalyzer returns an analyzer for the context directory with the
+// configured path filter, entry limits, and binary file handling
+func (m *Manager) newAnalyzer() *Analyzer {
+	analyzer := NewAnalyzer(m.store.Directory)
+	analyzer.SetFilter(PathFilter{Include: m.config.AnalyzeInclude, Exclude: m.config.AnalyzeExclude})
+	analyzer.SetMaxFiles(m.config.AnalyzeMaxFiles)
+	analyzer.SetMaxPerDir(m.config.AnalyzeMaxPerDir)
+	analyzer.SetBinaries(m.config.AnalyzeBinaries)
+	analyzer.SetScanInjection(m.config.ScanInjection)
+	return analyzer
+}
+
 // Analyze performs directory analysis and caches the results
 func (m *Manager) Analyze() error {
-	if err := AnalyzeDirectory(m.store); err != nil {
+	m.Wait()
+
+	if err := AnalyzeDirectory(m.store, m.newAnalyzer()); err != nil {
 		return fmt.Errorf("analysis failed: %w", err)
 	}
 
@@ -226,24 +1289,130 @@ func (m *Manager) Analyze() error {
 	return nil
 }
 
+// ContextInfo holds statistics about the current context
+type ContextInfo struct {
+	Directory          string     `json:"directory"`
+	Session            string     `json:"session,omitempty"` // Empty for the default session
+	Messages           int        `json:"messages"`
+	Tokens             int        `json:"tokens"`
+	RequestTokens      int        `json:"request_tokens"` // Including system prompt and analysis
+	PruneCount         int        `json:"prune_count"`
+	LastAnalysis       *time.Time `json:"last_analysis"`
+	SharedAnalysisFrom string     `json:"shared_analysis_from,omitempty"`
+	FirstMessage       *time.Time `json:"first_message"`
+	LastMessage        *time.Time `json:"last_message"`
+	LastUpdated        time.Time  `json:"last_updated"`
+	FileBytes          int64      `json:"file_bytes"`         // Size of the context file on disk
+	UncompressedBytes  int64      `json:"uncompressed_bytes"` // Size as plain JSON
+	ShouldPrune        bool       `json:"should_prune"`
+	PruneReason        string     `json:"prune_reason,omitempty"`
+}
+
+// Info returns statistics about the current context
+func (m *Manager) Info() ContextInfo {
+	m.Wait()
+
+	info := ContextInfo{
+		Directory:     m.store.Directory,
+		Session:       m.store.Session,
+		Messages:      m.store.Metadata.TotalMessages,
+		Tokens:        m.store.Metadata.TotalTokensEstimate,
+		RequestTokens: m.RequestTokens(),
+		PruneCount:    m.store.Metadata.PruneCount,
+		LastAnalysis:  m.store.LastAnalysisAt,
+		LastUpdated:   m.store.UpdatedAt,
+	}
+	info.FileBytes, info.UncompressedBytes = m.store.FileSize()
+
+	if m.store.LastAnalysisAt == nil && m.analysisCache() != nil {
+		info.SharedAnalysisFrom = m.store.AnalysisParent
+	}
+
+	if len(m.store.Messages) > 0 {
+		first := m.store.Messages[0].Timestamp
+		last := m.store.Messages[len(m.store.Messages)-1].Timestamp
+		info.FirstMessage = &first
+		info.LastMessage = &last
+	}
+
+	pruner := NewPruner(m.store, m.client, NewPreservationRules(m.config))
+	info.ShouldPrune, info.PruneReason = pruner.ShouldPrune()
+
+	return info
+}
+
 // GetInfo returns information about the current context
 func (m *Manager) GetInfo() string {
-	info := fmt.Sprintf("Context for %s\n", m.store.Directory)
-	info += fmt.Sprintf("Messages: %d\n", m.store.Metadata.TotalMessages)
-	info += fmt.Sprintf("Estimated tokens: %d\n", m.store.Metadata.TotalTokensEstimate)
-	info += fmt.Sprintf("Prune count: %d\n", m.store.Metadata.PruneCount)
+	return m.Info().String()
+}
+
+// String formats the statistics for display
+func (i ContextInfo) String() string {
+	const timeFormat = "2006-01-02 15:04:05"
 
-	if m.store.LastAnalysisAt != nil {
-		info += fmt.Sprintf("Last analysis: %s\n", m.store.LastAnalysisAt.Format("2006-01-02 15:04:05"))
+	info := fmt.Sprintf("Context for %s\n", i.Directory)
+	if i.Session != "" {
+		info += fmt.Sprintf("Session: %s\n", i.Session)
 	}
+	info += fmt.Sprintf("Messages: %d\n", i.Messages)
+	info += fmt.Sprintf("Estimated tokens: %d\n", i.Tokens)
+	info += fmt.Sprintf("Estimated request tokens: %d (with system prompt and analysis)\n", i.RequestTokens)
+	info += fmt.Sprintf("Prune count: %d\n", i.PruneCount)
 
-	info += fmt.Sprintf("Last updated: %s\n", m.store.UpdatedAt.Format("2006-01-02 15:04:05"))
+	if i.LastAnalysis != nil {
+		info += fmt.Sprintf("Last analysis: %s\n", i.LastAnalysis.Format(timeFormat))
+	} else if i.SharedAnalysisFrom != "" {
+		info += fmt.Sprintf("Shared analysis from: %s\n", i.SharedAnalysisFrom)
+	}
+
+	if i.FirstMessage != nil {
+		info += fmt.Sprintf("First message: %s\n", i.FirstMessage.Format(timeFormat))
+		info += fmt.Sprintf("Last message: %s\n", i.LastMessage.Format(timeFormat))
+	}
+
+	info += fmt.Sprintf("Last updated: %s\n", i.LastUpdated.Format(timeFormat))
+	if i.FileBytes > 0 {
+		info += fmt.Sprintf("File size: %s\n", formatFileSize(i.FileBytes, i.UncompressedBytes))
+	}
 
 	// Show pruning status
-	pruner := NewPruner(m.store, m.client)
-	if shouldPrune, reason := pruner.ShouldPrune(); shouldPrune {
-		info += fmt.Sprintf("\n⚠️  Pruning will be triggered soon: %s\n", reason)
+	if i.ShouldPrune {
+		info += fmt.Sprintf("\n⚠️  Pruning will be triggered soon: %s\n", i.PruneReason)
 	}
 
 	return info
 }
+
+// GetHistory returns the conversation with role, timestamp, and content for
+// each message. Indices match those used by pruning. Unless full is set,
+// content is collapsed to a single line of at most previewLen characters.
+func (m *Manager) GetHistory(previewLen int, full bool) string {
+	m.Wait()
+
+	if len(m.store.Messages) == 0 {
+		return fmt.Sprintf("No messages in context for %s\n", m.store.Directory)
+	}
+
+	var history strings.Builder
+	for i, msg := range m.store.Messages {
+		history.WriteString(fmt.Sprintf("[%d] %s (%s)\n", i, msg.Role, msg.Timestamp.Format("2006-01-02 15:04:05")))
+
+		content := msg.Content
+		if !full {
+			content = previewContent(content, previewLen)
+		}
+		history.WriteString(content + "\n\n")
+	}
+
+	return history.String()
+}
+
+// previewContent collapses content to a single line truncated to maxLen characters
+func previewContent(content string, maxLen int) string {
+	preview := strings.Join(strings.Fields(content), " ")
+	runes := []rune(preview)
+	if maxLen > 0 && len(runes) > maxLen {
+		return string(runes[:maxLen]) + "..."
+	}
+	return preview
+}
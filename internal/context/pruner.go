@@ -7,8 +7,67 @@ import (
 	"time"
 
 	"github.com/raitses/ask/internal/api"
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/internal/logging"
 )
 
+// DefaultPreserveKeywords are the keywords that protect a message from pruning
+// unless replaced via ASK_PRESERVE_KEYWORDS_REPLACE
+var DefaultPreserveKeywords = []string{"analysis", "file tree", "README", "structure", "architecture"}
+
+// PreservationRules defines which messages are protected from pruning
+type PreservationRules struct {
+	Keywords           []string // Case-insensitive keywords that preserve a message
+	PreserveCodeBlocks bool     // Preserve messages containing triple-backtick code blocks
+	KeepRecent         int      // Most recent messages always kept (0 uses the default)
+}
+
+// recentMessages returns how many of the most recent messages are always kept
+func (r PreservationRules) recentMessages() int {
+	if r.KeepRecent == 0 {
+		return config.DefaultKeepRecent * 2
+	}
+	return r.KeepRecent
+}
+
+// Validate checks that the recent messages to keep fit within the pruning
+// target, since pruning could never get below them otherwise
+func (r PreservationRules) Validate(limits PruningLimits) error {
+	if keep := r.recentMessages(); keep < 0 || keep > limits.TargetMessages {
+		return fmt.Errorf("ASK_KEEP_RECENT must be between 1 and %d exchanges (the pruning target), got %d",
+			limits.TargetMessages/2, keep/2)
+	}
+	return nil
+}
+
+// DefaultPreservationRules returns the built-in preservation rules
+func DefaultPreservationRules() PreservationRules {
+	return PreservationRules{
+		Keywords:           DefaultPreserveKeywords,
+		PreserveCodeBlocks: config.DefaultPreserveCodeBlocks,
+	}
+}
+
+// NewPreservationRules builds preservation rules from the configuration
+func NewPreservationRules(cfg *config.Config) PreservationRules {
+	rules := DefaultPreservationRules()
+	if cfg == nil {
+		return rules
+	}
+
+	if cfg.ReplacePreserveKeywords {
+		rules.Keywords = cfg.PreserveKeywords
+	} else if len(cfg.PreserveKeywords) > 0 {
+		keywords := make([]string, 0, len(DefaultPreserveKeywords)+len(cfg.PreserveKeywords))
+		keywords = append(keywords, DefaultPreserveKeywords...)
+		rules.Keywords = append(keywords, cfg.PreserveKeywords...)
+	}
+	rules.PreserveCodeBlocks = cfg.PreserveCodeBlocks
+	rules.KeepRecent = cfg.KeepRecent * 2 // Exchanges to messages
+
+	return rules
+}
+
 // PruningLimits defines the thresholds for context pruning
 type PruningLimits struct {
 	// Hard limits (automatic pruning)
@@ -40,20 +99,51 @@ func DefaultPruningLimits() PruningLimits {
 
 // Pruner handles context pruning operations
 type Pruner struct {
-	store  *Store
-	client *api.Client
-	limits PruningLimits
+	store    *Store
+	client   *api.Client
+	embedder Embedder // Optional; replaces AI-driven selection (see SetEmbedder)
+	limits   PruningLimits
+	rules    PreservationRules
+	model    string // Model for AI-driven selection ("" for the configured one; see SetModel)
+	verbose  bool   // Log the model's choices (see SetVerbose)
+	explain  bool   // Also ask the model for a reason per choice (see SetExplain)
 }
 
 // NewPruner creates a new context pruner
-func NewPruner(store *Store, client *api.Client) *Pruner {
+func NewPruner(store *Store, client *api.Client, rules PreservationRules) *Pruner {
 	return &Pruner{
 		store:  store,
 		client: client,
 		limits: DefaultPruningLimits(),
+		rules:  rules,
 	}
 }
 
+// SetVerbose makes AI-driven pruning log the messages the model chose to
+// remove, with a preview of each
+func (p *Pruner) SetVerbose(verbose bool) {
+	p.verbose = verbose
+}
+
+// SetModel makes AI-driven pruning ask model instead of the client's
+// configured model, e.g. a cheaper one (ASK_PRUNE_MODEL)
+func (p *Pruner) SetModel(model string) {
+	p.model = model
+}
+
+// SetExplain makes AI-driven pruning also ask the model for a one-line reason
+// per removed message, logged with the choices
+func (p *Pruner) SetExplain(explain bool) {
+	p.explain = explain
+}
+
+// pruningChoice is a message the model chose to remove, with its reason when
+// explanations were requested
+type pruningChoice struct {
+	Index  int    `json:"index"`
+	Reason string `json:"reason,omitempty"`
+}
+
 // ShouldPrune checks if pruning is needed based on current context
 func (p *Pruner) ShouldPrune() (bool, string) {
 	// Check hard limits first
@@ -69,7 +159,7 @@ func (p *Pruner) ShouldPrune() (bool, string) {
 	// Check age of oldest message
 	if len(p.store.Messages) > 0 {
 		oldest := p.store.Messages[0].Timestamp
-		age := time.Since(oldest)
+		age := nowFunc().Sub(oldest)
 		if age > time.Duration(p.limits.MaxAgeDays)*24*time.Hour {
 			return true, fmt.Sprintf("hard limit: age (%.0f days >= %d days)", age.Hours()/24, p.limits.MaxAgeDays)
 		}
@@ -94,6 +184,30 @@ func (p *Pruner) Prune() error {
 		return nil // No pruning needed
 	}
 
+	return p.prune(reason)
+}
+
+// PruneNow prunes even if no limit has been reached, e.g. when the user asks
+// to tidy the context. Hard pruning still stops at the target size.
+func (p *Pruner) PruneNow() error {
+	_, reason := p.ShouldPrune()
+	if reason == "" {
+		reason = "requested by user"
+	}
+	return p.prune(reason)
+}
+
+// prune removes messages, preferring embedding-based or AI-driven selection
+func (p *Pruner) prune(reason string) error {
+	// Embeddings are cheaper than a chat request, so they take its place when configured
+	if p.embedder != nil && p.canUseAIPruning() {
+		if err := p.pruneWithEmbeddings(); err != nil {
+			logging.Infof("Warning: Embedding-based pruning failed, pruning oldest messages instead: %v\n", err)
+			return p.pruneHard()
+		}
+		return nil
+	}
+
 	// Check if we can use AI-driven pruning
 	if p.client != nil && p.canUseAIPruning() {
 		if err := p.pruneWithAI(reason); err != nil {
@@ -130,26 +244,38 @@ func (p *Pruner) pruneWithAI(reason string) error {
 
 	messages := []api.ChatMessage{
 		{
-			Role:    "system",
+			Role:    p.client.InstructionRole(),
 			Content: prompt,
 		},
 	}
 
 	// Get AI's pruning suggestions
-	response, err := p.client.ChatCompletion(messages)
+	response, err := p.complete(messages)
 	if err != nil {
 		return fmt.Errorf("AI pruning request failed: %w", err)
 	}
 
 	// Parse the response (expecting JSON array of indices)
-	indices, err := p.parsePruningResponse(response)
+	choices, err := p.parsePruningResponse(response)
 	if err != nil {
-		return fmt.Errorf("failed to parse pruning response: %w", err)
+		// Give the model a single chance to repair its output
+		choices, err = p.repairPruningResponse(messages, response)
+		if err != nil {
+			return fmt.Errorf("failed to parse pruning response: %w", err)
+		}
+	}
+
+	if p.verbose || p.explain {
+		p.explainChoices(reason, choices)
+	}
+
+	indices := make([]int, len(choices))
+	for i, choice := range choices {
+		indices[i] = choice.Index
 	}
 
 	// Apply the pruning
-	if len(indices) > 0 {
-		p.removeMessagesByIndices(indices)
+	if removed := p.removeMessagesByIndices(indices); removed > 0 {
 		p.store.Metadata.PruneCount++
 		p.store.Metadata.TotalMessages = len(p.store.Messages)
 		p.store.Metadata.TotalTokensEstimate = p.store.EstimateTokens()
@@ -158,6 +284,76 @@ func (p *Pruner) pruneWithAI(reason string) error {
 	return nil
 }
 
+// complete sends a pruning request to the pruning model
+func (p *Pruner) complete(messages []api.ChatMessage) (string, error) {
+	if p.model == "" {
+		return p.client.ChatCompletion(messages)
+	}
+	completion, err := p.client.CompleteWithModel(messages, p.model)
+	if err != nil {
+		return "", err
+	}
+	return completion.Content, nil
+}
+
+// repairPruningResponse re-prompts the model once with its malformed reply
+// and asks for only the JSON array. It never retries more than once.
+func (p *Pruner) repairPruningResponse(messages []api.ChatMessage, badResponse string) ([]pruningChoice, error) {
+	repair := append(messages,
+		api.ChatMessage{
+			Role:    "assistant",
+			Content: badResponse,
+		},
+		api.ChatMessage{
+			Role:    "user",
+			Content: "Your previous reply was not valid JSON. Return ONLY the JSON array of message indices to remove, e.g. [0, 1, 4, 5].",
+		},
+	)
+
+	response, err := p.complete(repair)
+	if err != nil {
+		return nil, fmt.Errorf("AI pruning repair request failed: %w", err)
+	}
+
+	return p.parsePruningResponse(response)
+}
+
+// explainChoices logs each message the model chose to remove with a preview
+// and the model's reason, noting the ones that will be kept anyway
+func (p *Pruner) explainChoices(reason string, choices []pruningChoice) {
+	var explanation strings.Builder
+	explanation.WriteString(fmt.Sprintf("Pruning (%s): the model chose %d message(s) to remove\n", reason, len(choices)))
+	for _, choice := range choices {
+		line := "(out of range, ignored)"
+		if choice.Index >= 0 && choice.Index < len(p.store.Messages) {
+			msg := p.store.Messages[choice.Index]
+			line = fmt.Sprintf("%s: %s", msg.Role, previewContent(msg.Content, 60))
+			if p.ShouldPreserve(msg, choice.Index) {
+				line += " (protected, kept)"
+			}
+		}
+		if choice.Reason != "" {
+			line += " - " + choice.Reason
+		}
+		explanation.WriteString(fmt.Sprintf("  [%d] %s\n", choice.Index, line))
+	}
+	logging.Infof("%s", explanation.String())
+}
+
+// pruningResponseFormat tells the model how to answer the pruning prompt
+func (p *Pruner) pruningResponseFormat() string {
+	if p.explain {
+		return `- Return ONLY a JSON array with one object per message to remove, giving its index and a one-line reason
+
+Example response format:
+[{"index": 0, "reason": "one-off question about ls flags, resolved"}, {"index": 1, "reason": "answer to the ls question"}]`
+	}
+	return `- Return ONLY a JSON array of message indices to remove
+
+Example response format:
+[0, 1, 4, 5, 8, 9]`
+}
+
 // buildPruningPrompt creates the prompt for AI-driven pruning
 func (p *Pruner) buildPruningPrompt(reason string) string {
 	tokens := p.store.EstimateTokens()
@@ -167,8 +363,8 @@ func (p *Pruner) buildPruningPrompt(reason string) string {
 	summary.WriteString("CONVERSATION MESSAGES:\n\n")
 
 	for i, msg := range p.store.Messages {
-		// Skip system messages in the list
-		if msg.Role == "system" {
+		// Skip system/developer messages in the list
+		if api.IsInstructionRole(msg.Role) {
 			continue
 		}
 
@@ -200,13 +396,8 @@ Your task: Analyze the conversation and identify exchanges (user question + assi
 4. Redundant or repetitive
 
 IMPORTANT RULES:
-- Always preserve the last 4 messages (most recent 2 exchanges)
-- Preserve messages containing code examples (with triple backticks)
-- Preserve messages that reference project structure or analysis results
-- Return ONLY a JSON array of message indices to remove
-
-Example response format:
-[0, 1, 4, 5, 8, 9]
+- Always preserve the last %d messages (most recent %d exchanges)
+%s%s
 
 Respond with ONLY the JSON array, no other text.`,
 		reason,
@@ -214,11 +405,28 @@ Respond with ONLY the JSON array, no other text.`,
 		tokens,
 		p.limits.TargetTokens,
 		p.limits.TargetMessages,
-		summary.String())
+		summary.String(),
+		p.rules.recentMessages(),
+		p.rules.recentMessages()/2,
+		p.preservationPromptRules(),
+		p.pruningResponseFormat())
 }
 
-// parsePruningResponse extracts message indices from AI response
-func (p *Pruner) parsePruningResponse(response string) ([]int, error) {
+// preservationPromptRules describes the configured preservation rules for the pruning prompt
+func (p *Pruner) preservationPromptRules() string {
+	var rules strings.Builder
+	if p.rules.PreserveCodeBlocks {
+		rules.WriteString("- Preserve messages containing code examples (with triple backticks)\n")
+	}
+	if len(p.rules.Keywords) > 0 {
+		rules.WriteString(fmt.Sprintf("- Preserve messages that mention any of: %s\n", strings.Join(p.rules.Keywords, ", ")))
+	}
+	return rules.String()
+}
+
+// parsePruningResponse extracts the messages to remove from the AI response,
+// either a plain array of indices or objects with an index and reason
+func (p *Pruner) parsePruningResponse(response string) ([]pruningChoice, error) {
 	// Clean up response (remove markdown code blocks if present)
 	response = strings.TrimSpace(response)
 	response = strings.TrimPrefix(response, "```json")
@@ -227,23 +435,47 @@ func (p *Pruner) parsePruningResponse(response string) ([]int, error) {
 	response = strings.TrimSpace(response)
 
 	var indices []int
-	if err := json.Unmarshal([]byte(response), &indices); err != nil {
+	if err := json.Unmarshal([]byte(response), &indices); err == nil {
+		choices := make([]pruningChoice, len(indices))
+		for i, idx := range indices {
+			choices[i] = pruningChoice{Index: idx}
+		}
+		return choices, nil
+	}
+
+	var choices []pruningChoice
+	if err := json.Unmarshal([]byte(response), &choices); err != nil {
 		return nil, fmt.Errorf("failed to parse JSON array: %w", err)
 	}
 
-	return indices, nil
+	return choices, nil
 }
 
-// removeMessagesByIndices removes messages at the specified indices
-func (p *Pruner) removeMessagesByIndices(indices []int) {
-	// Create a set of indices to remove for O(1) lookup
+// removeMessagesByIndices removes messages at the specified indices, skipping
+// duplicates, out-of-range indices, and messages that must be preserved.
+// Returns the number of messages removed.
+func (p *Pruner) removeMessagesByIndices(indices []int) int {
+	// Create a set of valid indices to remove for O(1) lookup
 	toRemove := make(map[int]bool)
+	rejected := 0
 	for _, idx := range indices {
+		if toRemove[idx] {
+			continue // Duplicate
+		}
+		if idx < 0 || idx >= len(p.store.Messages) || p.ShouldPreserve(p.store.Messages[idx], idx) {
+			rejected++
+			continue
+		}
 		toRemove[idx] = true
 	}
 
+	if rejected > 0 {
+		logging.Infof("Warning: Rejected %d of %d pruning suggestions (out of range or protected)\n",
+			rejected, len(indices))
+	}
+
 	// Build new message list excluding removed indices
-	newMessages := make([]Message, 0, len(p.store.Messages)-len(indices))
+	newMessages := make([]Message, 0, len(p.store.Messages)-len(toRemove))
 	for i, msg := range p.store.Messages {
 		if !toRemove[i] {
 			newMessages = append(newMessages, msg)
@@ -251,6 +483,7 @@ func (p *Pruner) removeMessagesByIndices(indices []int) {
 	}
 
 	p.store.Messages = newMessages
+	return len(toRemove)
 }
 
 // pruneHard performs simple hard pruning by removing oldest messages
@@ -262,27 +495,21 @@ func (p *Pruner) pruneHard() error {
 	// Calculate how many to remove
 	toRemove := len(p.store.Messages) - p.limits.TargetMessages
 
-	// Apply preservation rules: keep last 4 messages minimum
-	if toRemove >= len(p.store.Messages)-4 {
-		toRemove = len(p.store.Messages) - 4
+	// Remove the oldest messages that aren't protected, wherever they sit in history
+	preserved := make([]Message, 0, p.limits.TargetMessages)
+	removed := 0
+	for i, msg := range p.store.Messages {
+		if removed < toRemove && !p.isPinned(msg, i) {
+			removed++
+			continue
+		}
+		preserved = append(preserved, msg)
 	}
 
-	if toRemove <= 0 {
+	if removed == 0 {
 		return nil
 	}
 
-	// Remove oldest messages while preserving system messages and recent exchanges
-	preserved := make([]Message, 0, p.limits.TargetMessages)
-
-	// Skip old system messages
-	startIdx := 0
-	for startIdx < len(p.store.Messages) && p.store.Messages[startIdx].Role == "system" {
-		startIdx++
-	}
-
-	// Keep messages after removing 'toRemove' count
-	preserved = append(preserved, p.store.Messages[startIdx+toRemove:]...)
-
 	p.store.Messages = preserved
 	p.store.Metadata.PruneCount++
 	p.store.Metadata.TotalMessages = len(p.store.Messages)
@@ -291,23 +518,50 @@ func (p *Pruner) pruneHard() error {
 	return nil
 }
 
+// TrimBefore removes messages older than cutoff, keeping pinned messages
+// (instruction messages, pinned, and the most recent). Returns how many were removed.
+func (p *Pruner) TrimBefore(cutoff time.Time) int {
+	preserved := make([]Message, 0, len(p.store.Messages))
+	for i, msg := range p.store.Messages {
+		if msg.Timestamp.Before(cutoff) && !p.isPinned(msg, i) {
+			continue
+		}
+		preserved = append(preserved, msg)
+	}
+
+	removed := len(p.store.Messages) - len(preserved)
+	if removed > 0 {
+		p.store.Messages = preserved
+		p.store.Metadata.TotalMessages = len(p.store.Messages)
+		p.store.Metadata.TotalTokensEstimate = p.store.EstimateTokens()
+	}
+
+	return removed
+}
+
+// isPinned checks if a message must survive hard pruning: system/developer messages,
+// explicitly pinned messages, and the most recent messages (ASK_KEEP_RECENT
+// exchanges) are always kept
+func (p *Pruner) isPinned(msg Message, index int) bool {
+	return api.IsInstructionRole(msg.Role) || msg.Pinned || index >= len(p.store.Messages)-p.rules.recentMessages()
+}
+
 // ShouldPreserve checks if a message should be preserved during pruning
 func (p *Pruner) ShouldPreserve(msg Message, index int) bool {
-	// Preserve recent messages (last 4)
-	if index >= len(p.store.Messages)-4 {
+	// Preserve system, pinned, and recent messages
+	if p.isPinned(msg, index) {
 		return true
 	}
 
 	// Preserve messages with code blocks
-	if strings.Contains(msg.Content, "```") {
+	if p.rules.PreserveCodeBlocks && strings.Contains(msg.Content, "```") {
 		return true
 	}
 
-	// Preserve messages that mention analysis or project structure
-	keywords := []string{"analysis", "file tree", "README", "structure", "architecture"}
+	// Preserve messages that mention any configured keyword
 	content := strings.ToLower(msg.Content)
-	for _, keyword := range keywords {
-		if strings.Contains(content, keyword) {
+	for _, keyword := range p.rules.Keywords {
+		if strings.Contains(content, strings.ToLower(keyword)) {
 			return true
 		}
 	}
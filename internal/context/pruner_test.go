@@ -1,9 +1,20 @@
 package context
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/raitses/ask/internal/api"
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/internal/logging"
 )
 
 func TestPrunerShouldPrune(t *testing.T) {
@@ -50,7 +61,7 @@ func TestPrunerShouldPrune(t *testing.T) {
 				store.AddMessage(role, "test message "+string(rune(i)))
 			}
 
-			pruner := NewPruner(store, nil)
+			pruner := NewPruner(store, nil, DefaultPreservationRules())
 			shouldPrune, reason := pruner.ShouldPrune()
 
 			if shouldPrune != tt.shouldPrune {
@@ -76,7 +87,7 @@ func TestPrunerHardPrune(t *testing.T) {
 		store.AddMessage(role, "Message "+string(rune('A'+i)))
 	}
 
-	pruner := NewPruner(store, nil)
+	pruner := NewPruner(store, nil, DefaultPreservationRules())
 	limits := DefaultPruningLimits()
 
 	if err := pruner.pruneHard(); err != nil {
@@ -101,6 +112,49 @@ func TestPrunerHardPrune(t *testing.T) {
 	t.Logf("Pruned from 50 to %d messages", len(store.Messages))
 }
 
+func TestPrunerHardPruneKeepsSystemMessages(t *testing.T) {
+	store := NewStore("/test/dir")
+
+	// Add 40 messages with a system summary and a pinned message mid-history
+	for i := 0; i < 40; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		store.AddMessage(role, "Message")
+	}
+	store.Messages[10].Role = "system"
+	store.Messages[10].Content = "Summary of earlier conversation"
+	store.Messages[12].Pinned = true
+
+	pruner := NewPruner(store, nil, DefaultPreservationRules())
+	limits := DefaultPruningLimits()
+
+	if err := pruner.pruneHard(); err != nil {
+		t.Fatalf("pruneHard() failed: %v", err)
+	}
+
+	if len(store.Messages) != limits.TargetMessages {
+		t.Errorf("After pruning: got %d messages, want %d", len(store.Messages), limits.TargetMessages)
+	}
+
+	foundSystem, foundPinned := false, false
+	for _, msg := range store.Messages {
+		if msg.Role == "system" && msg.Content == "Summary of earlier conversation" {
+			foundSystem = true
+		}
+		if msg.Pinned {
+			foundPinned = true
+		}
+	}
+	if !foundSystem {
+		t.Error("Mid-history system message should have been preserved")
+	}
+	if !foundPinned {
+		t.Error("Pinned message should have been preserved")
+	}
+}
+
 func TestPrunerPreservation(t *testing.T) {
 	store := NewStore("/test/dir")
 
@@ -116,7 +170,7 @@ func TestPrunerPreservation(t *testing.T) {
 	store.AddMessage("user", "Recent question 2")
 	store.AddMessage("assistant", "Recent answer 2")
 
-	pruner := NewPruner(store, nil)
+	pruner := NewPruner(store, nil, DefaultPreservationRules())
 
 	tests := []struct {
 		index    int
@@ -150,26 +204,60 @@ func TestPrunerPreservation(t *testing.T) {
 	}
 }
 
+func TestPrunerCustomPreserveKeywords(t *testing.T) {
+	store := NewStore("/test/dir")
+
+	store.AddMessage("user", "Wie funktioniert die Datenbank?")
+	store.AddMessage("assistant", "Die Datenbank nutzt PostgreSQL")
+	store.AddMessage("user", "Here's code:\n```go\nfunc main() {}\n```")
+	store.AddMessage("assistant", "The architecture is layered")
+	for i := 0; i < 4; i++ {
+		store.AddMessage("user", "Recent message")
+	}
+
+	tests := []struct {
+		name  string
+		cfg   *config.Config
+		index int
+		want  bool
+	}{
+		{"custom keyword augments defaults", &config.Config{PreserveKeywords: []string{"Datenbank"}, PreserveCodeBlocks: true}, 1, true},
+		{"defaults still apply when augmenting", &config.Config{PreserveKeywords: []string{"Datenbank"}, PreserveCodeBlocks: true}, 3, true},
+		{"replace drops default keywords", &config.Config{PreserveKeywords: []string{"Datenbank"}, ReplacePreserveKeywords: true, PreserveCodeBlocks: true}, 3, false},
+		{"code block detection disabled", &config.Config{PreserveCodeBlocks: false}, 2, false},
+		{"code block detection enabled", &config.Config{PreserveCodeBlocks: true}, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pruner := NewPruner(store, nil, NewPreservationRules(tt.cfg))
+			if got := pruner.ShouldPreserve(store.Messages[tt.index], tt.index); got != tt.want {
+				t.Errorf("ShouldPreserve(%d) = %v, want %v", tt.index, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestPrunerRemoveByIndices(t *testing.T) {
 	store := NewStore("/test/dir")
 
-	// Add 10 messages
-	for i := 0; i < 10; i++ {
+	// Add 14 messages (the last 4 are protected)
+	for i := 0; i < 14; i++ {
 		store.AddMessage("user", string(rune('A'+i)))
 	}
 
-	pruner := NewPruner(store, nil)
+	pruner := NewPruner(store, nil, DefaultPreservationRules())
 
 	// Remove indices 0, 2, 4, 6, 8 (every other message)
 	pruner.removeMessagesByIndices([]int{0, 2, 4, 6, 8})
 
-	// Should have 5 messages remaining
-	if len(store.Messages) != 5 {
-		t.Errorf("After removal: got %d messages, want 5", len(store.Messages))
+	// Should have 9 messages remaining
+	if len(store.Messages) != 9 {
+		t.Errorf("After removal: got %d messages, want 9", len(store.Messages))
 	}
 
 	// Check remaining messages are correct
-	expected := []string{"B", "D", "F", "H", "J"}
+	expected := []string{"B", "D", "F", "H", "J", "K", "L", "M", "N"}
 	for i, msg := range store.Messages {
 		if msg.Content != expected[i] {
 			t.Errorf("Message %d: got %q, want %q", i, msg.Content, expected[i])
@@ -177,32 +265,87 @@ func TestPrunerRemoveByIndices(t *testing.T) {
 	}
 }
 
-func TestPrunerAgeLimit(t *testing.T) {
-	store := NewStore("/test/dir")
+func TestPrunerRemoveByIndicesMalformed(t *testing.T) {
+	tests := []struct {
+		name        string
+		indices     []int
+		wantRemoved int
+		wantFirst   string
+	}{
+		{"out of range", []int{-1, 10, 100}, 0, "A"},
+		{"duplicates", []int{0, 0, 0, 1}, 2, "C"},
+		{"protected recent messages", []int{6, 7, 8, 9}, 0, "A"},
+		{"protected code block", []int{5}, 0, "A"},
+		{"mixed valid and invalid", []int{0, -5, 0, 9, 3}, 2, "B"},
+	}
 
-	// Add an old message
-	oldMsg := Message{
-		Role:      "user",
-		Content:   "Old message",
-		Timestamp: time.Now().Add(-35 * 24 * time.Hour), // 35 days ago
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore("/test/dir")
+			for i := 0; i < 10; i++ {
+				content := string(rune('A' + i))
+				if i == 5 {
+					content = "```sh\nmake test\n```"
+				}
+				store.AddMessage("user", content)
+			}
+
+			pruner := NewPruner(store, nil, DefaultPreservationRules())
+			removed := pruner.removeMessagesByIndices(tt.indices)
+
+			if removed != tt.wantRemoved {
+				t.Errorf("removeMessagesByIndices() removed %d, want %d", removed, tt.wantRemoved)
+			}
+			if len(store.Messages) != 10-tt.wantRemoved {
+				t.Errorf("After removal: got %d messages, want %d", len(store.Messages), 10-tt.wantRemoved)
+			}
+			if store.Messages[0].Content != tt.wantFirst {
+				t.Errorf("First message = %q, want %q", store.Messages[0].Content, tt.wantFirst)
+			}
+		})
 	}
-	store.Messages = append(store.Messages, oldMsg)
+}
 
-	pruner := NewPruner(store, nil)
-	shouldPrune, reason := pruner.ShouldPrune()
+func TestPrunerAgeLimit(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	freezeTime(t, now)
 
-	if !shouldPrune {
-		t.Error("Should prune due to age limit")
+	tests := []struct {
+		name string
+		age  time.Duration
+		want bool
+	}{
+		{"recent", 29 * 24 * time.Hour, false},
+		{"exactly at the limit", 30 * 24 * time.Hour, false},
+		{"just past the limit", 30*24*time.Hour + time.Second, true},
+		{"35 days", 35 * 24 * time.Hour, true},
 	}
 
-	if !strings.Contains(reason, "age") {
-		t.Errorf("Reason should mention age, got: %s", reason)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore("/test/dir")
+			store.Messages = append(store.Messages, Message{
+				Role:      "user",
+				Content:   "Old message",
+				Timestamp: now.Add(-tt.age),
+			})
+
+			pruner := NewPruner(store, nil, DefaultPreservationRules())
+			shouldPrune, reason := pruner.ShouldPrune()
+
+			if shouldPrune != tt.want {
+				t.Fatalf("ShouldPrune() = %v (%s), want %v", shouldPrune, reason, tt.want)
+			}
+			if shouldPrune && !strings.Contains(reason, "age") {
+				t.Errorf("Reason should mention age, got: %s", reason)
+			}
+		})
 	}
 }
 
 func TestPrunerParsePruningResponse(t *testing.T) {
 	store := NewStore("/test/dir")
-	pruner := NewPruner(store, nil)
+	pruner := NewPruner(store, nil, DefaultPreservationRules())
 
 	tests := []struct {
 		name     string
@@ -234,6 +377,12 @@ func TestPrunerParsePruningResponse(t *testing.T) {
 			want:     []int{},
 			wantErr:  false,
 		},
+		{
+			name:     "Objects with reasons",
+			response: `[{"index": 3, "reason": "resolved"}, {"index": 4, "reason": "answer to 3"}]`,
+			want:     []int{3, 4},
+			wantErr:  false,
+		},
 		{
 			name:     "Invalid JSON",
 			response: "not json",
@@ -258,8 +407,8 @@ func TestPrunerParsePruningResponse(t *testing.T) {
 				}
 
 				for i, v := range got {
-					if v != tt.want[i] {
-						t.Errorf("parsePruningResponse()[%d] = %d, want %d", i, v, tt.want[i])
+					if v.Index != tt.want[i] {
+						t.Errorf("parsePruningResponse()[%d] = %d, want %d", i, v.Index, tt.want[i])
 					}
 				}
 			}
@@ -307,3 +456,296 @@ func TestTokenEstimation(t *testing.T) {
 
 	t.Logf("Estimated tokens with analysis: %d", tokensWithAnalysis)
 }
+
+func TestPrunerRepairsMalformedResponse(t *testing.T) {
+	tests := []struct {
+		name         string
+		replies      []string
+		wantErr      bool
+		wantRequests int
+		wantMessages int
+	}{
+		{"valid on first try", []string{"[0, 1]"}, false, 1, 18},
+		{"repaired on retry", []string{"Sure! Remove 0 and 1.", "[0, 1]"}, false, 2, 18},
+		{"still malformed after retry", []string{"not json", "still not json", "[0, 1]"}, true, 2, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reply := tt.replies[min(requests, len(tt.replies)-1)]
+				requests++
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"choices": []map[string]interface{}{
+						{"message": map[string]string{"role": "assistant", "content": reply}},
+					},
+				})
+			}))
+			defer server.Close()
+
+			store := NewStore("/test/dir")
+			for i := 0; i < 20; i++ {
+				store.AddMessage("user", "Message")
+			}
+
+			client := api.NewClient(&config.Config{APIURL: server.URL, APIKey: "test"})
+			pruner := NewPruner(store, client, DefaultPreservationRules())
+
+			err := pruner.pruneWithAI("test")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pruneWithAI() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("Made %d requests, want %d", requests, tt.wantRequests)
+			}
+			if len(store.Messages) != tt.wantMessages {
+				t.Errorf("After pruning: got %d messages, want %d", len(store.Messages), tt.wantMessages)
+			}
+		})
+	}
+}
+
+func TestPrunerModel(t *testing.T) {
+	var models []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		models = append(models, req.Model)
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"[0]"}}]}`))
+	}))
+	defer server.Close()
+
+	store := NewStore("/test/dir")
+	for i := 0; i < 20; i++ {
+		store.AddMessage("user", fmt.Sprintf("Message %d", i))
+	}
+
+	client := api.NewClient(&config.Config{APIURL: server.URL, APIKey: "test", Model: "gpt-4.5-preview"})
+	pruner := NewPruner(store, client, DefaultPreservationRules())
+	if err := pruner.pruneWithAI("soft limit"); err != nil {
+		t.Fatalf("pruneWithAI() failed: %v", err)
+	}
+
+	pruner.SetModel("gpt-4o-mini")
+	if err := pruner.pruneWithAI("soft limit"); err != nil {
+		t.Fatalf("pruneWithAI() failed: %v", err)
+	}
+
+	if want := []string{"gpt-4.5-preview", "gpt-4o-mini"}; !slices.Equal(models, want) {
+		t.Errorf("Pruning requests went to %v, want %v", models, want)
+	}
+}
+
+func TestPrunerExplain(t *testing.T) {
+	var prompt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[0].Content
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{"message": map[string]string{"role": "assistant", "content": `[{"index": 0, "reason": "one-off greeting"}, {"index": 19, "reason": "too recent"}]`}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	var logged bytes.Buffer
+	logging.SetOutput(&logged)
+	defer logging.SetOutput(os.Stderr)
+
+	store := NewStore("/test/dir")
+	for i := 0; i < 20; i++ {
+		store.AddMessage("user", fmt.Sprintf("Message %d", i))
+	}
+
+	client := api.NewClient(&config.Config{APIURL: server.URL, APIKey: "test"})
+	pruner := NewPruner(store, client, DefaultPreservationRules())
+	pruner.SetExplain(true)
+
+	if err := pruner.pruneWithAI("soft limit"); err != nil {
+		t.Fatalf("pruneWithAI() failed: %v", err)
+	}
+
+	if !strings.Contains(prompt, `"reason"`) {
+		t.Errorf("Prompt should ask for a reason per message:\n%s", prompt)
+	}
+	for _, want := range []string{
+		"Pruning (soft limit): the model chose 2 message(s) to remove",
+		"[0] user: Message 0 - one-off greeting",
+		"[19] user: Message 19 (protected, kept) - too recent",
+	} {
+		if !strings.Contains(logged.String(), want) {
+			t.Errorf("Log missing %q:\n%s", want, logged.String())
+		}
+	}
+	if len(store.Messages) != 19 {
+		t.Errorf("After pruning: got %d messages, want 19", len(store.Messages))
+	}
+}
+
+func TestPrunerTrimBefore(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	freezeTime(t, now)
+
+	store := NewStore("/test/dir")
+	ages := []time.Duration{72 * time.Hour, 72 * time.Hour, 60 * time.Hour, 36 * time.Hour, time.Hour, 72 * time.Hour, 72 * time.Hour, 72 * time.Hour, 72 * time.Hour}
+	for i, age := range ages {
+		store.AddMessage("user", fmt.Sprintf("Message %d", i))
+		store.Messages[i].Timestamp = now.Add(-age)
+	}
+	store.Messages[1].Pinned = true
+
+	pruner := NewPruner(store, nil, DefaultPreservationRules())
+	removed := pruner.TrimBefore(now.Add(-48 * time.Hour))
+
+	// 0 and 2 are old; 1 is pinned; 3 and 4 are recent; the last 4 are always kept
+	if removed != 2 {
+		t.Errorf("TrimBefore removed %d messages, want 2", removed)
+	}
+
+	var remaining []string
+	for _, msg := range store.Messages {
+		remaining = append(remaining, msg.Content)
+	}
+	want := "Message 1,Message 3,Message 4,Message 5,Message 6,Message 7,Message 8"
+	if strings.Join(remaining, ",") != want {
+		t.Errorf("Remaining = %v, want %s", remaining, want)
+	}
+	if store.Metadata.TotalMessages != 7 {
+		t.Errorf("TotalMessages = %d, want 7", store.Metadata.TotalMessages)
+	}
+}
+
+// embedFunc adapts a function to the Embedder interface
+type embedFunc func(texts []string) ([][]float64, error)
+
+func (f embedFunc) Embed(texts []string) ([][]float64, error) {
+	return f(texts)
+}
+
+func TestPrunerEmbeddings(t *testing.T) {
+	// 15 exchanges cycling through three topics; the embedding is the topic
+	topics := []string{"docker", "postgres", "testing"}
+	newStore := func() *Store {
+		store := NewStore("/test/dir")
+		for i := 0; i < 15; i++ {
+			topic := topics[i%len(topics)]
+			store.AddMessage("user", fmt.Sprintf("Question %d about %s", i, topic))
+			store.AddMessage("assistant", fmt.Sprintf("Answer %d about %s", i, topic))
+		}
+		return store
+	}
+	embedder := embedFunc(func(texts []string) ([][]float64, error) {
+		vectors := make([][]float64, len(texts))
+		for i, text := range texts {
+			vectors[i] = make([]float64, len(topics))
+			for j, topic := range topics {
+				if strings.Contains(text, topic) {
+					vectors[i][j] = 1
+				}
+			}
+		}
+		return vectors, nil
+	})
+
+	t.Run("keeps the newest exchange per topic", func(t *testing.T) {
+		store := newStore()
+		pruner := NewPruner(store, nil, PreservationRules{})
+		pruner.limits.TargetMessages = 10 // The last 2 exchanges plus 3 more
+		pruner.SetEmbedder(embedder)
+
+		if err := pruner.PruneNow(); err != nil {
+			t.Fatalf("PruneNow failed: %v", err)
+		}
+
+		var kept []string
+		for _, msg := range store.Messages {
+			if msg.Role == "user" {
+				kept = append(kept, strings.TrimPrefix(msg.Content, "Question "))
+			}
+		}
+		want := []string{"10 about postgres", "11 about testing", "12 about docker", "13 about postgres", "14 about testing"}
+		if strings.Join(kept, ", ") != strings.Join(want, ", ") {
+			t.Errorf("Kept %v, want %v", kept, want)
+		}
+	})
+
+	t.Run("falls back to hard pruning when embedding fails", func(t *testing.T) {
+		store := newStore()
+		pruner := NewPruner(store, nil, PreservationRules{})
+		pruner.SetEmbedder(embedFunc(func([]string) ([][]float64, error) {
+			return nil, fmt.Errorf("endpoint down")
+		}))
+
+		if err := pruner.PruneNow(); err != nil {
+			t.Fatalf("PruneNow failed: %v", err)
+		}
+		if len(store.Messages) != pruner.limits.TargetMessages || store.Messages[0].Content != "Question 3 about docker" {
+			t.Errorf("Expected the oldest messages removed, got %d messages starting with %q", len(store.Messages), store.Messages[0].Content)
+		}
+	})
+}
+
+func TestClusterRepresentatives(t *testing.T) {
+	vectors := [][]float64{{1, 0}, {0, 1}, {1, 0.1}, {0.1, 1}, {1, 0}}
+	got := clusterRepresentatives(vectors, 2)
+	if len(got) != 2 || !(got[0] == 4 && got[1] == 3) {
+		t.Errorf("clusterRepresentatives = %v, want [4 3]", got)
+	}
+	if got := clusterRepresentatives(vectors, 9); len(got) != len(vectors) {
+		t.Errorf("Asking for more clusters than vectors should keep all, got %v", got)
+	}
+}
+
+func TestPrunerKeepRecent(t *testing.T) {
+	tests := []struct {
+		name       string
+		keepRecent int // Exchanges, as in ASK_KEEP_RECENT
+		want       int
+	}{
+		{"default", 0, 4},
+		{"10 exchanges", 10, 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore("/test/dir")
+			for i := 0; i < 50; i++ {
+				role := "user"
+				if i%2 == 1 {
+					role = "assistant"
+				}
+				store.AddMessage(role, fmt.Sprintf("message %d", i))
+			}
+
+			pruner := NewPruner(store, nil, NewPreservationRules(&config.Config{KeepRecent: tt.keepRecent}))
+			pruner.limits.TargetMessages = 0 // Remove everything that isn't kept
+			if err := pruner.pruneHard(); err != nil {
+				t.Fatalf("pruneHard failed: %v", err)
+			}
+
+			if len(store.Messages) != tt.want {
+				t.Fatalf("Expected %d messages kept, got %d", tt.want, len(store.Messages))
+			}
+			if first := store.Messages[0].Content; first != fmt.Sprintf("message %d", 50-tt.want) {
+				t.Errorf("Expected the most recent messages kept, first is %q", first)
+			}
+		})
+	}
+}
+
+func TestPreservationRulesValidate(t *testing.T) {
+	limits := DefaultPruningLimits()
+	for _, keep := range []int{0, 1, 10, limits.TargetMessages / 2} {
+		if err := NewPreservationRules(&config.Config{KeepRecent: keep}).Validate(limits); err != nil {
+			t.Errorf("KeepRecent %d: unexpected error: %v", keep, err)
+		}
+	}
+	for _, keep := range []int{-1, limits.TargetMessages/2 + 1} {
+		if err := NewPreservationRules(&config.Config{KeepRecent: keep}).Validate(limits); err == nil {
+			t.Errorf("KeepRecent %d: expected an error", keep)
+		}
+	}
+}
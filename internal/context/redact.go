@@ -0,0 +1,50 @@
+package context
+
+import "regexp"
+
+// RedactedPlaceholder replaces secrets detected in message content
+const RedactedPlaceholder = "[REDACTED]"
+
+// secretPattern matches a secret. If keepPrefix is set, the first capture
+// group (e.g. "Bearer " or "API_KEY=") is kept and only the value is replaced.
+type secretPattern struct {
+	re         *regexp.Regexp
+	keepPrefix bool
+}
+
+// secretPatterns are the common secret shapes removed before persistence
+var secretPatterns = []secretPattern{
+	// Private key blocks (RSA, EC, OpenSSH, ...)
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), false},
+	// AWS access key IDs
+	{regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), false},
+	// OpenAI / Anthropic style keys (sk-..., sk-proj-..., sk-ant-...)
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_\-]{20,}`), false},
+	// GitHub tokens
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`), false},
+	// Bearer tokens in headers
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]{8,}=*`), true},
+	// Assignments in .env files and shell exports to a name ending in a
+	// secret word, e.g. GITHUB_TOKEN= but not ASK_MAX_TOKENS=
+	{regexp.MustCompile(`(?m)^(\s*(?:export\s+)?(?:[A-Z0-9_]*_)?(?:API_KEY|SECRET|TOKEN|PASSWORD)=)\S+`), true},
+}
+
+// Redact replaces common secret patterns in content with [REDACTED] and
+// returns the redacted content along with the number of secrets replaced
+func Redact(content string) (string, int) {
+	count := 0
+	for _, pattern := range secretPatterns {
+		content = pattern.re.ReplaceAllStringFunc(content, func(match string) string {
+			if match == RedactedPlaceholder {
+				return match
+			}
+			count++
+			if pattern.keepPrefix {
+				prefix := pattern.re.FindStringSubmatch(match)[1]
+				return prefix + RedactedPlaceholder
+			}
+			return RedactedPlaceholder
+		})
+	}
+	return content, count
+}
@@ -0,0 +1,30 @@
+package context
+
+import (
+	"regexp"
+	"strings"
+)
+
+// codebaseCues are phrases that suggest a query is about the project at hand
+var codebaseCues = []string{
+	"this project", "this repo", "the repo", "codebase", "the code", "this code", "our code",
+	"this file", "this function", "this package", "this module", "this directory", "this folder",
+	"how does", "where is", "where are", "where do", "in here", "readme",
+}
+
+// filePattern matches file names and paths like main.go, ./cmd, or src/app
+var filePattern = regexp.MustCompile(`\b[\w-]{2,}\.[a-z][a-z0-9]{0,4}\b|\w/\w|\./\w`)
+
+// MentionsCodebase reports whether query likely concerns the analyzed
+// codebase: it names a file or path, or uses a phrase like "this project".
+// It errs toward true, since leaving analysis out of a codebase question
+// costs more than sending it with a general one.
+func MentionsCodebase(query string) bool {
+	lower := strings.ToLower(query)
+	for _, cue := range codebaseCues {
+		if strings.Contains(lower, cue) {
+			return true
+		}
+	}
+	return filePattern.MatchString(lower)
+}
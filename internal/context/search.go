@@ -0,0 +1,51 @@
+package context
+
+import (
+	"bytes"
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SearchTimeout bounds how long the ASK_SEARCH_CMD command may run
+const SearchTimeout = 30 * time.Second
+
+// MaxSearchOutput caps the search results sent to the model
+const MaxSearchOutput = 20000
+
+// ErrNoSearchCmd is returned when a web search is requested without ASK_SEARCH_CMD
+var ErrNoSearchCmd = errors.New("--web needs a search command; set ASK_SEARCH_CMD (e.g. 'ddgr --json --num 5')")
+
+// Search runs command in dir with query as its final argument (and in
+// $ASK_QUERY) and returns its trimmed stdout, truncated to MaxSearchOutput
+// bytes without splitting a character. The command's stderr is passed through.
+func Search(dir, command, query string) (string, error) {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), SearchTimeout)
+	defer cancel()
+
+	// "$@" passes the query as one argument without any shell quoting issues
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command+` "$@"`, "ask", query)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "ASK_QUERY="+query)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	if ctx.Err() == stdcontext.DeadlineExceeded {
+		return "", fmt.Errorf("search command timed out after %s", SearchTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("search command failed: %w", err)
+	}
+
+	results := strings.TrimSpace(stdout.String())
+	if len(results) > MaxSearchOutput {
+		results = truncateBytes(results, MaxSearchOutput) + "\n[results truncated]"
+	}
+	return results, nil
+}
@@ -0,0 +1,82 @@
+package context
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/pkg/hash"
+)
+
+// DefaultSession names the session every directory starts in. It is stored
+// as "" so existing contexts keep their file names.
+const DefaultSession = "default"
+
+// sessionNamePattern limits session names to characters safe in file names
+var sessionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NormalizeSession validates a session name, mapping DefaultSession and ""
+// to the default session ("")
+func NormalizeSession(name string) (string, error) {
+	if name == "" || name == DefaultSession {
+		return "", nil
+	}
+	if !sessionNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid session name %q (use letters, digits, - and _)", name)
+	}
+	return name, nil
+}
+
+// CurrentSession returns the session last selected with SwitchSession for a
+// directory, or "" for the default session
+func CurrentSession(directory string) string {
+	data, err := os.ReadFile(currentSessionPath(directory))
+	if err != nil {
+		return ""
+	}
+	session, err := NormalizeSession(strings.TrimSpace(string(data)))
+	if err != nil {
+		return ""
+	}
+	return session
+}
+
+// SwitchSession makes session the one used for a directory when no session
+// is given explicitly
+func SwitchSession(directory, session string) error {
+	session, err := NormalizeSession(session)
+	if err != nil {
+		return err
+	}
+
+	path := currentSessionPath(directory)
+	if session == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to switch session: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create context directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(session+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to switch session: %w", err)
+	}
+	return nil
+}
+
+// ListSessions returns the named sessions with a stored context for a
+// directory, sorted. The default session is not included.
+func ListSessions(directory string) ([]string, error) {
+	return backend.List(directory)
+}
+
+// currentSessionPath returns the file recording a directory's current session
+func currentSessionPath(directory string) string {
+	baseDir, _ := config.BaseDir()
+	return filepath.Join(baseDir, config.ContextDir, hash.DirectoryPath(directory)+".session")
+}
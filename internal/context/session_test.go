@@ -0,0 +1,183 @@
+package context
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/raitses/ask/internal/config"
+)
+
+func TestSessionsAreSeparate(t *testing.T) {
+	isolateHome(t)
+	project := t.TempDir()
+
+	for _, session := range []string{"", "debug", "feature"} {
+		store, err := LoadSession(project, session, nil)
+		if err != nil {
+			t.Fatalf("LoadSession(%q) failed: %v", session, err)
+		}
+		store.AddMessage("user", "Question in session "+session)
+		if err := store.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	debug, err := LoadSession(project, "debug", nil)
+	if err != nil {
+		t.Fatalf("LoadSession failed: %v", err)
+	}
+	if len(debug.Messages) != 1 || debug.Messages[0].Content != "Question in session debug" {
+		t.Errorf("Session debug should only hold its own message, got %+v", debug.Messages)
+	}
+
+	sessions, err := ListSessions(project)
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if want := []string{"debug", "feature"}; !slices.Equal(sessions, want) {
+		t.Errorf("ListSessions() = %v, want %v", sessions, want)
+	}
+}
+
+func TestDeleteRemovesSessions(t *testing.T) {
+	isolateHome(t)
+	project := t.TempDir()
+	other := t.TempDir()
+
+	for _, session := range []string{"", "debug", "feature"} {
+		store, err := LoadSession(project, session, nil)
+		if err != nil {
+			t.Fatalf("LoadSession(%q) failed: %v", session, err)
+		}
+		store.AddMessage("user", "Question")
+		store.Compress = session == "feature"
+		if err := store.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if err := SwitchSession(project, "debug"); err != nil {
+		t.Fatalf("SwitchSession failed: %v", err)
+	}
+	kept, _ := LoadSession(other, "debug", nil)
+	kept.AddMessage("user", "Other project")
+	if err := kept.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if err := Delete(project); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	for _, session := range []string{"", "debug", "feature"} {
+		if contextExists(project, session) {
+			t.Errorf("Session %q should be deleted", session)
+		}
+	}
+	if sessions, _ := ListSessions(project); len(sessions) != 0 {
+		t.Errorf("ListSessions() = %v after Delete, want none", sessions)
+	}
+	if got := CurrentSession(project); got != "" {
+		t.Errorf("CurrentSession() = %q after Delete, want the default session", got)
+	}
+	if !contextExists(other, "debug") {
+		t.Error("Another directory's sessions should be kept")
+	}
+	if err := Delete(project); err == nil {
+		t.Error("Deleting a directory with no context should fail")
+	}
+}
+
+func TestSwitchSession(t *testing.T) {
+	isolateHome(t)
+	project := t.TempDir()
+	cfg := &config.Config{APIURL: "https://api.example.com/v1/chat", Dir: project}
+
+	if got := CurrentSession(project); got != "" {
+		t.Errorf("CurrentSession() = %q, want the default session", got)
+	}
+
+	if err := SwitchSession(project, "debug"); err != nil {
+		t.Fatalf("SwitchSession failed: %v", err)
+	}
+	manager, err := NewManagerWithClient(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewManagerWithClient failed: %v", err)
+	}
+	if manager.store.Session != "debug" {
+		t.Errorf("Manager uses session %q, want the current session debug", manager.store.Session)
+	}
+
+	// An explicit session wins over the current one
+	cfg.Session = "feature"
+	if manager, err = NewManagerWithClient(cfg, nil); err != nil {
+		t.Fatalf("NewManagerWithClient failed: %v", err)
+	}
+	if manager.store.Session != "feature" {
+		t.Errorf("Manager uses session %q, want feature", manager.store.Session)
+	}
+
+	if err := SwitchSession(project, DefaultSession); err != nil {
+		t.Fatalf("SwitchSession failed: %v", err)
+	}
+	if got := CurrentSession(project); got != "" {
+		t.Errorf("CurrentSession() = %q after switching back, want the default session", got)
+	}
+
+	for _, name := range []string{"../escape", "has space", "a/b"} {
+		if err := SwitchSession(project, name); err == nil {
+			t.Errorf("SwitchSession(%q) should fail", name)
+		}
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	isolateHome(t)
+	key := DeriveKey("secret")
+	oldPath, newPath := "/src/old-name", "/src/new-name"
+
+	for _, session := range []string{"", "debug"} {
+		store, err := LoadSession(oldPath, session, key)
+		if err != nil {
+			t.Fatalf("LoadSession(%q) failed: %v", session, err)
+		}
+		store.Compress = session == "debug"
+		store.AddMessage("user", "Question in session "+session)
+		if err := store.Save(); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if err := SwitchSession(oldPath, "debug"); err != nil {
+		t.Fatalf("SwitchSession failed: %v", err)
+	}
+
+	moved, err := Relocate(oldPath, newPath, key)
+	if err != nil {
+		t.Fatalf("Relocate failed: %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("Relocate moved %d contexts, want 2", moved)
+	}
+
+	for _, session := range []string{"", "debug"} {
+		if contextExists(oldPath, session) {
+			t.Errorf("Session %q should be removed from the old path", session)
+		}
+		store, err := LoadSession(newPath, session, key)
+		if err != nil {
+			t.Fatalf("LoadSession(%q) at the new path failed: %v", session, err)
+		}
+		if store.Directory != newPath || len(store.Messages) != 1 || store.Messages[0].Content != "Question in session "+session {
+			t.Errorf("Session %q wasn't carried over: %+v", session, store)
+		}
+	}
+	if CurrentSession(newPath) != "debug" || CurrentSession(oldPath) != "" {
+		t.Error("The current session should move to the new path")
+	}
+
+	// Nothing left to move, and an existing context is never overwritten
+	if _, err := Relocate(oldPath, newPath, key); err == nil {
+		t.Error("Expected an error relocating a path without a context")
+	}
+	if _, err := Relocate(newPath, newPath, key); err == nil {
+		t.Error("Expected an error relocating onto an existing context")
+	}
+}
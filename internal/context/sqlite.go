@@ -0,0 +1,119 @@
+package context
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/raitses/ask/pkg/hash"
+
+	_ "modernc.org/sqlite" // Pure-Go driver, so release builds stay cgo-free
+)
+
+// sqliteSchema creates the table holding every context, keyed like the
+// context files by a hash of the directory and the session ("" for the
+// default session)
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS contexts (
+	directory TEXT NOT NULL,
+	session   TEXT NOT NULL,
+	data      BLOB NOT NULL,
+	PRIMARY KEY (directory, session)
+)`
+
+// sqliteBackend keeps every context in one SQLite database, so listing a
+// directory's sessions is an index lookup rather than a scan of the
+// contexts directory
+type sqliteBackend struct {
+	path string
+	db   *sql.DB
+}
+
+// openSQLiteBackend opens the database at path, creating it if needed
+func openSQLiteBackend(path string) (*sqliteBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create context directory: %w", err)
+	}
+
+	// Wait for another ask process's write rather than failing as busy
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open context database: %w", err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open context database: %w", err)
+	}
+	// Contexts are private, like the files they replace
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open context database: %w", err)
+	}
+
+	return &sqliteBackend{path: path, db: db}, nil
+}
+
+// Read returns the session's row
+func (b *sqliteBackend) Read(directory, session string) ([]byte, error) {
+	var data []byte
+	err := b.db.QueryRow("SELECT data FROM contexts WHERE directory = ? AND session = ?",
+		hash.DirectoryPath(directory), session).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context: %w", err)
+	}
+	return data, nil
+}
+
+// Write inserts or replaces the session's row in one statement, so a
+// failed write leaves the previous context intact. Whether data is
+// compressed is detected again when it is read.
+func (b *sqliteBackend) Write(directory, session string, data []byte, compressed bool) error {
+	_, err := b.db.Exec(`INSERT INTO contexts (directory, session, data) VALUES (?, ?, ?)
+		ON CONFLICT (directory, session) DO UPDATE SET data = excluded.data`,
+		hash.DirectoryPath(directory), session, data)
+	if err != nil {
+		return fmt.Errorf("failed to write context: %w", err)
+	}
+	return nil
+}
+
+// List queries the directory's named sessions without reading any context
+func (b *sqliteBackend) List(directory string) ([]string, error) {
+	rows, err := b.db.Query("SELECT session FROM contexts WHERE directory = ? AND session != '' ORDER BY session",
+		hash.DirectoryPath(directory))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []string
+	for rows.Next() {
+		var session string
+		if err := rows.Scan(&session); err != nil {
+			return nil, fmt.Errorf("failed to list sessions: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Delete removes the session's row
+func (b *sqliteBackend) Delete(directory, session string) (bool, error) {
+	result, err := b.db.Exec("DELETE FROM contexts WHERE directory = ? AND session = ?",
+		hash.DirectoryPath(directory), session)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete context: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete context: %w", err)
+	}
+	return n > 0, nil
+}
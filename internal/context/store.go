@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/raitses/ask/internal/config"
-	"github.com/raitses/ask/pkg/hash"
+	"github.com/raitses/ask/internal/logging"
 )
 
 // Message represents a single message in the conversation
@@ -16,6 +19,10 @@ type Message struct {
 	Role      string    `json:"role"`      // system, user, assistant
 	Content   string    `json:"content"`
 	Timestamp time.Time `json:"timestamp"`
+	Pinned    bool      `json:"pinned,omitempty"` // Never removed by pruning
+	Blob      string    `json:"blob,omitempty"`   // ID of the full content when offloaded (see LoadBlob)
+
+	FinishReason string `json:"finish_reason,omitempty"` // Why the model stopped (assistant messages)
 }
 
 // AnalysisCache holds cached directory analysis results
@@ -23,6 +30,7 @@ type AnalysisCache struct {
 	FileTree       string   `json:"file_tree"`
 	ReadmeContent  string   `json:"readme_content,omitempty"`
 	PrimaryConfigs []string `json:"primary_configs"`
+	Untrusted      []string `json:"untrusted,omitempty"` // Sources with instruction-like text (see ScanAnalysis)
 }
 
 // Metadata holds statistics about the conversation
@@ -36,17 +44,47 @@ type Metadata struct {
 type Store struct {
 	Version        string         `json:"version"`
 	Directory      string         `json:"directory"`
+	Session        string         `json:"session,omitempty"` // Named session; empty for the default
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 	LastAnalysisAt *time.Time     `json:"last_analysis_at,omitempty"`
 	AnalysisCache  *AnalysisCache `json:"analysis_cache,omitempty"`
+	AnalysisParent string         `json:"analysis_parent,omitempty"` // Ancestor directory whose analysis is shared
 	Messages       []Message      `json:"messages"`
 	Metadata       Metadata       `json:"metadata"`
+
+	// Redact replaces detected secrets in new messages before they are stored
+	Redact bool `json:"-"`
+
+	// MaxMessageLength overrides the default MaxMessageLength when positive
+	MaxMessageLength int `json:"-"`
+
+	// OffloadLarge stores new messages longer than this many characters in a
+	// blob file, keeping only a reference in the context, when positive
+	OffloadLarge int `json:"-"`
+
+	// InMemory makes Save a no-op, for sessions whose context directory
+	// isn't writable
+	InMemory bool `json:"-"`
+
+	// Compress gzips the file on Save (written as .json.gz)
+	Compress bool `json:"-"`
+
+	// Path is an explicit context file (ASK_CONTEXT_FILE) used instead of
+	// the per-directory file under ~/.config/ask/contexts
+	Path string `json:"-"`
+
+	encryptionKey *Key            // Encrypts the file on Save when set
+	blobs         map[string]bool // Blobs referenced since loading, removed on Save once unreferenced
+
+	// Sizes of the file last loaded or saved, on disk and as plain JSON
+	fileSize int64
+	jsonSize int64
 }
 
 // NewStore creates a new context store for the given directory
 func NewStore(directory string) *Store {
-	now := time.Now()
+	now := nowFunc()
 	return &Store{
 		Version:   "1",
 		Directory: directory,
@@ -61,21 +99,30 @@ func NewStore(directory string) *Store {
 	}
 }
 
-// Load reads the context store from disk
-func Load(directory string) (*Store, error) {
-	path := getContextFilePath(directory)
+// Load reads the context store from disk. If key is set, encrypted files are
+// decrypted and the store is encrypted on Save; plaintext files still load.
+// Compressed (.json.gz) files are decompressed.
+func Load(directory string, key *Key) (*Store, error) {
+	return LoadSession(directory, "", key)
+}
 
-	data, err := os.ReadFile(path)
+// LoadSession reads the context store of a named session like Load.
+// The default session is "".
+func LoadSession(directory, session string, key *Key) (*Store, error) {
+	data, err := backend.Read(directory, session)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return NewStore(directory), nil
+			store := NewStore(directory)
+			store.Session = session
+			store.encryptionKey = key
+			return store, nil
 		}
 		return nil, fmt.Errorf("failed to read context file: %w", err)
 	}
 
-	var store Store
-	if err := json.Unmarshal(data, &store); err != nil {
-		return nil, fmt.Errorf("failed to parse context file: %w", err)
+	store, err := decodeStore(data, key)
+	if err != nil {
+		return nil, err
 	}
 
 	// Verify directory matches
@@ -83,38 +130,260 @@ func Load(directory string) (*Store, error) {
 		return nil, fmt.Errorf("context file directory mismatch: expected %s, got %s", directory, store.Directory)
 	}
 
+	return store, nil
+}
+
+// LoadFile reads the context store from an explicit path (ASK_CONTEXT_FILE)
+// like Load, and Save writes it back there. The file isn't tied to a
+// directory: a store saved from another checkout (e.g. by an earlier CI job)
+// is adopted for directory. A missing file starts an empty store.
+func LoadFile(path, directory string, key *Key) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			store := NewStore(directory)
+			store.Path = path
+			store.encryptionKey = key
+			return store, nil
+		}
+		return nil, fmt.Errorf("failed to read context file: %w", err)
+	}
+
+	store, err := decodeStore(data, key)
+	if err != nil {
+		return nil, err
+	}
+	store.Directory = directory
+	store.Path = path
+	return store, nil
+}
+
+// decodeStore parses a context file's contents, decrypting and decompressing
+// them as needed
+func decodeStore(data []byte, key *Key) (*Store, error) {
+	fileSize := int64(len(data))
+
+	var err error
+	if isEncrypted(data) {
+		if key == nil {
+			return nil, fmt.Errorf("context file is encrypted; set ASK_ENCRYPTION_KEY to read it")
+		}
+		if data, err = decrypt(data, key); err != nil {
+			return nil, err
+		}
+	}
+	compressed := isCompressed(data)
+	if compressed {
+		if data, err = decompress(data); err != nil {
+			return nil, err
+		}
+	}
+
+	store := Store{Compress: compressed, encryptionKey: key, fileSize: fileSize, jsonSize: int64(len(data))}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("failed to parse context file: %w", err)
+	}
+	store.blobs = make(map[string]bool)
+	for _, msg := range store.Messages {
+		if msg.Blob != "" {
+			store.blobs[msg.Blob] = true
+		}
+	}
 	return &store, nil
 }
 
-// Save writes the context store to disk
+// Save writes the context store to its backend, or to Path if set
 func (s *Store) Save() error {
-	s.UpdatedAt = time.Now()
+	s.UpdatedAt = nowFunc()
+	if s.InMemory {
+		return nil
+	}
+
+	data, jsonSize, err := s.encode()
+	if err != nil {
+		return err
+	}
+	if s.Path != "" {
+		err = writeContextFile(s.Path, data, "")
+	} else {
+		err = backend.Write(s.Directory, s.Session, data, s.Compress)
+	}
+	if err != nil {
+		return err
+	}
+	s.fileSize, s.jsonSize = int64(len(data)), jsonSize
+
+	s.removeUnreferencedBlobs()
+	return nil
+}
+
+// encode returns the store as it is saved, compressed and encrypted as
+// configured, and the size of its plain JSON
+func (s *Store) encode() ([]byte, int64, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal context: %w", err)
+	}
+	jsonSize := int64(len(data))
+
+	// Compress before encrypting, since ciphertext doesn't compress
+	if s.Compress {
+		if data, err = compress(data); err != nil {
+			return nil, 0, fmt.Errorf("failed to compress context: %w", err)
+		}
+	}
+
+	if s.encryptionKey != nil {
+		if data, err = encrypt(data, s.encryptionKey); err != nil {
+			return nil, 0, fmt.Errorf("failed to encrypt context: %w", err)
+		}
+	}
+
+	return data, jsonSize, nil
+}
+
+// FileSize returns the size of the context file as last loaded or saved, on
+// disk and as uncompressed JSON (both 0 if it has never been written)
+func (s *Store) FileSize() (disk, uncompressed int64) {
+	return s.fileSize, s.jsonSize
+}
 
-	// Ensure context directory exists
-	homeDir, err := os.UserHomeDir()
+// CheckWritable reports whether context files can be written, by creating
+// the context directory and a scratch file in it
+func CheckWritable() error {
+	baseDir, err := config.BaseDir()
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return err
 	}
 
-	contextDir := filepath.Join(homeDir, config.ContextDir)
+	contextDir := filepath.Join(baseDir, config.ContextDir)
 	if err := os.MkdirAll(contextDir, 0700); err != nil {
 		return fmt.Errorf("failed to create context directory: %w", err)
 	}
 
-	path := getContextFilePath(s.Directory)
+	f, err := os.CreateTemp(contextDir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("context directory %s isn't writable: %w", contextDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
 
-	data, err := json.MarshalIndent(s, "", "  ")
+// Delete removes every stored context for a directory: the default session's
+// file and each named session's, compressed or not, and the record of the
+// current session
+func Delete(directory string) error {
+	sessions, err := ListSessions(directory)
 	if err != nil {
-		return fmt.Errorf("failed to marshal context: %w", err)
+		return err
 	}
 
-	if err := os.WriteFile(path, data, 0600); err != nil {
-		return fmt.Errorf("failed to write context file: %w", err)
+	found := false
+	for _, session := range append([]string{""}, sessions...) {
+		removed, err := removeContext(directory, session)
+		if err != nil {
+			return err
+		}
+		found = found || removed
+	}
+	if err := os.Remove(currentSessionPath(directory)); err == nil {
+		found = true
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete current session: %w", err)
+	}
+	if !found {
+		return fmt.Errorf("no context found for %s", directory)
 	}
 
 	return nil
 }
 
+// DeleteFile removes an explicit context file (ASK_CONTEXT_FILE)
+func DeleteFile(path string) error {
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no context file at %s", path)
+		}
+		return fmt.Errorf("failed to delete context file: %w", err)
+	}
+	return nil
+}
+
+// Relocate moves the stored contexts of a directory, including its named
+// sessions and current session, to another path after the directory was
+// moved or renamed. Nothing is changed if any context fails to load or the
+// new path already has a context. Returns how many contexts were moved.
+func Relocate(from, to string, key *Key) (int, error) {
+	sessions, err := ListSessions(from)
+	if err != nil {
+		return 0, err
+	}
+
+	var stores []*Store
+	for _, session := range append([]string{""}, sessions...) {
+		if !contextExists(from, session) {
+			continue
+		}
+		if contextExists(to, session) {
+			return 0, fmt.Errorf("%s already has a context; remove it with --forget first", to)
+		}
+		store, err := LoadSession(from, session, key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load context: %w", err)
+		}
+		stores = append(stores, store)
+	}
+	if len(stores) == 0 {
+		return 0, fmt.Errorf("no context found for %s", from)
+	}
+
+	// Write every new file before removing any old one
+	for _, store := range stores {
+		store.Directory = to
+		if err := store.Save(); err != nil {
+			return 0, err
+		}
+	}
+	for _, store := range stores {
+		if err := os.Rename(blobDir(from, store.Session), blobDir(to, store.Session)); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to move blobs: %w", err)
+		}
+		if _, err := removeContext(from, store.Session); err != nil {
+			return 0, err
+		}
+	}
+
+	if current := CurrentSession(from); current != "" {
+		if err := SwitchSession(to, current); err != nil {
+			return 0, err
+		}
+		if err := SwitchSession(from, DefaultSession); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stores), nil
+}
+
+// contextExists reports whether a session has a stored context
+func contextExists(directory, session string) bool {
+	_, err := backend.Read(directory, session)
+	return err == nil
+}
+
+// removeContext removes a session's stored context and its blobs, and
+// reports whether there was a stored context
+func removeContext(directory, session string) (bool, error) {
+	found, err := backend.Delete(directory, session)
+	if err != nil {
+		return found, err
+	}
+	if err := os.RemoveAll(blobDir(directory, session)); err != nil {
+		return found, fmt.Errorf("failed to delete blobs: %w", err)
+	}
+	return found, nil
+}
+
 const (
 	// MaxMessageLength is the maximum allowed length for a single message
 	MaxMessageLength = 50000 // ~14k tokens max per message
@@ -126,27 +395,162 @@ const (
 	MaxFileTreeLength = 20000
 )
 
-// AddMessage adds a new message to the conversation with size limits
-func (s *Store) AddMessage(role, content string) {
+// Roles are the message roles the chat API accepts
+var Roles = []string{"system", "user", "assistant", "tool", "developer"}
+
+// NormalizeRole lowercases and trims role and checks it against Roles
+func NormalizeRole(role string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(role))
+	if !slices.Contains(Roles, normalized) {
+		return "", fmt.Errorf("invalid message role %q (want one of %s)", role, strings.Join(Roles, ", "))
+	}
+	return normalized, nil
+}
+
+// AddMessage adds a new message to the conversation with size limits,
+// redacting secrets first when enabled. Roles are normalized; an invalid
+// role is an error and nothing is stored, since the API would reject it on
+// every later turn.
+func (s *Store) AddMessage(role, content string) error {
+	role, err := NormalizeRole(role)
+	if err != nil {
+		return err
+	}
+
+	// Strip secrets before they can be persisted
+	if s.Redact {
+		var redacted int
+		content, redacted = Redact(content)
+		if redacted > 0 {
+			logging.Infof("⚠️  Warning: Redacted %d secret(s) from message before saving\n", redacted)
+		}
+	}
+
+	// Keep large pastes out of the active context, without losing them.
+	// Only the user's messages are pastes; answers stay inline. Blobs live
+	// under the home directory, so an explicit context file keeps
+	// everything inline to stay self-contained.
+	var blob string
+	if role == "user" && s.OffloadLarge > 0 && len(content) > s.OffloadLarge && !s.InMemory && s.Path == "" {
+		if id, err := s.offload(content); err != nil {
+			logging.Infof("⚠️  Warning: Failed to offload large message, keeping it in context: %v\n", err)
+		} else {
+			content, blob = blobReference(id, content), id
+		}
+	}
+
 	// Truncate if too long
+	limit := s.maxMessageLength()
 	truncated := false
-	if len(content) > MaxMessageLength {
-		content = content[:MaxMessageLength] + "\n\n[Content truncated - exceeded maximum message length]"
+	if len(content) > limit {
+		content = truncateMiddle(content, limit)
 		truncated = true
 	}
 
 	msg := Message{
 		Role:      role,
 		Content:   content,
-		Timestamp: time.Now(),
+		Timestamp: nowFunc(),
+		Blob:      blob,
 	}
 	s.Messages = append(s.Messages, msg)
 	s.Metadata.TotalMessages = len(s.Messages)
 	s.Metadata.TotalTokensEstimate = s.EstimateTokens()
 
 	if truncated {
-		fmt.Fprintf(os.Stderr, "⚠️  Warning: Message truncated (exceeded %d chars)\n", MaxMessageLength)
+		logging.Infof("⚠️  Warning: Message truncated (exceeded %d chars)\n", limit)
+	}
+	return nil
+}
+
+// removeLastMessage takes back the message AddMessage just added, deleting
+// the blob it was offloaded to unless another message refers to it
+func (s *Store) removeLastMessage() {
+	last := s.Messages[len(s.Messages)-1]
+	s.Messages = s.Messages[:len(s.Messages)-1]
+	s.Metadata.TotalMessages = len(s.Messages)
+	s.Metadata.TotalTokensEstimate = s.EstimateTokens()
+
+	if last.Blob == "" || slices.ContainsFunc(s.Messages, func(msg Message) bool { return msg.Blob == last.Blob }) {
+		return
+	}
+	if err := os.Remove(s.blobPath(last.Blob)); err != nil && !os.IsNotExist(err) {
+		logging.Infof("Warning: Failed to remove blob %s: %v\n", last.Blob, err)
+		return
+	}
+	delete(s.blobs, last.Blob)
+}
+
+// RepeatedAnswer returns the stored answer if question is identical to the
+// last question asked and that question has been answered
+func (s *Store) RepeatedAnswer(question string) (string, bool) {
+	n := len(s.Messages)
+	if n < 2 {
+		return "", false
+	}
+	asked, answer := s.Messages[n-2], s.Messages[n-1]
+	if asked.Role != "user" || answer.Role != "assistant" {
+		return "", false
+	}
+
+	// Compare against the question as it would have been stored
+	if s.Redact {
+		question, _ = Redact(question)
+	}
+	if strings.TrimSpace(asked.Content) != strings.TrimSpace(question) {
+		return "", false
+	}
+	return answer.Content, true
+}
+
+// maxMessageLength returns the configured message length limit or the default
+func (s *Store) maxMessageLength() int {
+	if s.MaxMessageLength > 0 {
+		return s.MaxMessageLength
+	}
+	return MaxMessageLength
+}
+
+// truncateMiddle shortens content to limit characters by keeping its head and
+// tail, since error messages and stack traces often matter most at the end
+func truncateMiddle(content string, limit int) string {
+	headEnd := limit / 2
+	tailStart := len(content) - (limit - headEnd)
+
+	// Don't split multi-byte characters
+	for headEnd > 0 && !utf8.RuneStart(content[headEnd]) {
+		headEnd--
 	}
+	for tailStart < len(content) && !utf8.RuneStart(content[tailStart]) {
+		tailStart++
+	}
+
+	return fmt.Sprintf("%s\n\n[Content truncated - %d characters elided from the middle]\n\n%s",
+		content[:headEnd], tailStart-headEnd, content[tailStart:])
+}
+
+// truncateBytes cuts content to at most limit bytes without splitting a
+// multi-byte character
+func truncateBytes(content string, limit int) string {
+	if len(content) <= limit {
+		return content
+	}
+	for limit > 0 && !utf8.RuneStart(content[limit]) {
+		limit--
+	}
+	return content[:limit]
+}
+
+// SeedWithSummary replaces the conversation history with a single system
+// message holding a summary of the earlier conversation
+func (s *Store) SeedWithSummary(summary string) {
+	s.Messages = []Message{{
+		Role:      "system",
+		Content:   summary,
+		Timestamp: nowFunc(),
+	}}
+	s.Metadata.TotalMessages = len(s.Messages)
+	s.Metadata.TotalTokensEstimate = s.EstimateTokens()
 }
 
 // EstimateTokens provides a rough estimate of token count
@@ -178,10 +582,17 @@ func (s *Store) EstimateTokens() int {
 	return total
 }
 
+// Stale reports whether the context has messages and hasn't been updated for
+// longer than ttl. A ttl of 0 means contexts never go stale.
+func (s *Store) Stale(ttl time.Duration, now time.Time) bool {
+	return ttl > 0 && len(s.Messages) > 0 && now.Sub(s.UpdatedAt) > ttl
+}
+
 // Reset clears all messages and analysis cache
 func (s *Store) Reset() {
 	s.Messages = []Message{}
 	s.AnalysisCache = nil
+	s.AnalysisParent = ""
 	s.LastAnalysisAt = nil
 	s.Metadata = Metadata{
 		TotalMessages:       0,
@@ -189,10 +600,3 @@ func (s *Store) Reset() {
 		PruneCount:          s.Metadata.PruneCount, // Preserve prune count
 	}
 }
-
-// getContextFilePath returns the path to the context file for a directory
-func getContextFilePath(directory string) string {
-	homeDir, _ := os.UserHomeDir()
-	dirHash := hash.DirectoryPath(directory)
-	return filepath.Join(homeDir, config.ContextDir, dirHash+".json")
-}
@@ -0,0 +1,250 @@
+package context
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/raitses/ask/internal/api"
+)
+
+// MaxToolRounds bounds how many rounds of tool calls one query may make
+const MaxToolRounds = 10
+
+// CommandTimeout bounds how long an approved command may run
+const CommandTimeout = 2 * time.Minute
+
+// MaxCommandOutput caps the command output sent back to the model
+const MaxCommandOutput = 10000
+
+// ProjectTool is a tool declared in a project's .ask/tools.json: a schema
+// offered to the model and a shell command template run when it is called.
+// {{name}} in Command is replaced with the shell-quoted argument name.
+type ProjectTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Parameters  map[string]any `json:"parameters"`
+	Command     string         `json:"command"`
+}
+
+var (
+	// toolNamePattern is what providers accept as a function name
+	toolNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+	// placeholderPattern matches {{name}} in a command template
+	placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+)
+
+// LoadProjectTools reads tool definitions from path, a JSON array of
+// ProjectTool. Every placeholder in a command must be a declared parameter.
+// A missing file is not an error.
+func LoadProjectTools(path string) ([]ProjectTool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tools: %w", err)
+	}
+
+	var tools []ProjectTool
+	if err := json.Unmarshal(data, &tools); err != nil {
+		return nil, fmt.Errorf("invalid tools in %s: %w", path, err)
+	}
+
+	seen := map[string]bool{api.ToolRunCommand: true}
+	for i := range tools {
+		tool := &tools[i]
+		switch {
+		case !toolNamePattern.MatchString(tool.Name):
+			return nil, fmt.Errorf("invalid tools in %s: tool %d has invalid name %q", path, i+1, tool.Name)
+		case seen[tool.Name]:
+			return nil, fmt.Errorf("invalid tools in %s: tool name %q is already used", path, tool.Name)
+		case tool.Description == "":
+			return nil, fmt.Errorf("invalid tools in %s: %s has no description", path, tool.Name)
+		case strings.TrimSpace(tool.Command) == "":
+			return nil, fmt.Errorf("invalid tools in %s: %s has no command", path, tool.Name)
+		}
+		seen[tool.Name] = true
+
+		if tool.Parameters == nil {
+			tool.Parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		properties, _ := tool.Parameters["properties"].(map[string]any)
+		for _, match := range placeholderPattern.FindAllStringSubmatch(tool.Command, -1) {
+			if _, ok := properties[match[1]]; !ok {
+				return nil, fmt.Errorf("invalid tools in %s: %s's command uses {{%s}}, which isn't one of its parameters", path, tool.Name, match[1])
+			}
+		}
+	}
+
+	return tools, nil
+}
+
+// Tool returns the definition offered to the model
+func (t ProjectTool) Tool() api.Tool {
+	return api.Tool{
+		Type: "function",
+		Function: api.ToolFunction{
+			Name:        t.Name,
+			Description: t.Description + " The user must approve the command before it runs.",
+			Parameters:  t.Parameters,
+		},
+	}
+}
+
+// BuildCommand fills the command template with a call's arguments. Values
+// are shell-quoted so the model can't inject extra commands through them;
+// missing arguments become empty strings.
+func (t ProjectTool) BuildCommand(call api.ToolCall) (string, error) {
+	args := map[string]any{}
+	if strings.TrimSpace(call.Function.Arguments) != "" {
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid %s arguments: %w", t.Name, err)
+		}
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(t.Command, func(match string) string {
+		name := placeholderPattern.FindStringSubmatch(match)[1]
+		value := ""
+		switch v := args[name].(type) {
+		case nil:
+		case string:
+			value = v
+		default:
+			encoded, _ := json.Marshal(v)
+			value = string(encoded)
+		}
+		return shellQuote(value)
+	}), nil
+}
+
+// shellQuote quotes s as a single POSIX shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// offeredTools returns the tools offered with --tools: run_command plus the
+// project's own
+func (m *Manager) offeredTools() []api.Tool {
+	tools := []api.Tool{api.RunCommandTool()}
+	for _, tool := range m.projectTools {
+		tools = append(tools, tool.Tool())
+	}
+	return tools
+}
+
+// completeWithTools sends messages with the offered tools, running each
+// approved call and feeding its result back until the model answers.
+// It returns the final completion and the messages including the tool exchange.
+// Tool calls and results are not stored in the context; only the final answer is.
+func (m *Manager) completeWithTools(messages []api.ChatMessage) (api.Completion, []api.ChatMessage, error) {
+	tools := m.offeredTools()
+	var usage api.Usage
+
+	for round := 0; ; round++ {
+		// Withhold tools on the last round so the model has to answer
+		if round == MaxToolRounds {
+			tools = nil
+		}
+
+		completion, err := m.completeOffering(messages, tools)
+		if err != nil {
+			return api.Completion{}, messages, err
+		}
+		usage = usage.Add(completion.Usage)
+		if len(completion.ToolCalls) == 0 {
+			completion.Usage = usage
+			return completion, messages, nil
+		}
+
+		messages = append(messages, api.ChatMessage{
+			Role:      "assistant",
+			Content:   completion.Content,
+			ToolCalls: completion.ToolCalls,
+		})
+		for _, call := range completion.ToolCalls {
+			messages = append(messages, api.ChatMessage{
+				Role:       "tool",
+				Content:    m.runToolCall(call),
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
+
+// completeOffering is complete with tools offered (none if tools is empty)
+func (m *Manager) completeOffering(messages []api.ChatMessage, tools []api.Tool) (api.Completion, error) {
+	if len(tools) == 0 {
+		return m.complete(messages)
+	}
+	return m.completeWith(func() (api.Completion, error) {
+		return m.client.CompleteWithTools(messages, tools)
+	})
+}
+
+// runToolCall executes a tool call after user approval and returns the
+// result text for the model
+func (m *Manager) runToolCall(call api.ToolCall) string {
+	command, err := m.toolCommand(call)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+
+	if m.approve == nil || !m.approve(command) {
+		fmt.Fprintf(os.Stderr, "Skipped: %s\n", command)
+		return "The user declined to run this command."
+	}
+
+	output, err := runCommand(m.store.Directory, command)
+	fmt.Fprint(os.Stderr, output)
+	if err != nil {
+		return fmt.Sprintf("%s\n[command failed: %v]", output, err)
+	}
+	return output
+}
+
+// toolCommand returns the shell command a tool call asks to run
+func (m *Manager) toolCommand(call api.ToolCall) (string, error) {
+	if call.Function.Name == api.ToolRunCommand {
+		return api.RunCommandArgs(call)
+	}
+	for _, tool := range m.projectTools {
+		if tool.Name == call.Function.Name {
+			return tool.BuildCommand(call)
+		}
+	}
+	return "", fmt.Errorf("unknown tool %q", call.Function.Name)
+}
+
+// runCommand runs command with the user's shell in dir and returns its
+// combined output, truncated to MaxCommandOutput characters
+func runCommand(dir, command string) (string, error) {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), CommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, shell, "-c", command)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+
+	output := string(out)
+	if len(output) > MaxCommandOutput {
+		output = output[:MaxCommandOutput] + "\n[output truncated]\n"
+	}
+	if ctx.Err() == stdcontext.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", CommandTimeout)
+	}
+	return output, err
+}
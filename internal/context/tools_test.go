@@ -0,0 +1,83 @@
+package context
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/raitses/ask/internal/api"
+)
+
+func TestLoadProjectTools(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // "" leaves the file missing
+		want    int
+		wantErr string
+	}{
+		{"missing file", "", 0, ""},
+		{"valid", `[{"name":"run_tests","description":"Run a package's tests","parameters":{"type":"object","properties":{"pkg":{"type":"string"}}},"command":"go test {{pkg}}"}]`, 1, ""},
+		{"no parameters", `[{"name":"lint","description":"Lint the project","command":"make lint"}]`, 1, ""},
+		{"invalid JSON", `{`, 0, "invalid tools"},
+		{"bad name", `[{"name":"run tests","description":"d","command":"x"}]`, 0, "invalid name"},
+		{"shadows run_command", `[{"name":"run_command","description":"d","command":"x"}]`, 0, "already used"},
+		{"no command", `[{"name":"lint","description":"d","command":" "}]`, 0, "no command"},
+		{"undeclared placeholder", `[{"name":"lint","description":"d","command":"lint {{path}}"}]`, 0, "{{path}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tools.json")
+			if tt.content != "" {
+				_ = os.WriteFile(path, []byte(tt.content), 0644)
+			}
+
+			tools, err := LoadProjectTools(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadProjectTools() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadProjectTools failed: %v", err)
+			}
+			if len(tools) != tt.want {
+				t.Errorf("got %d tools, want %d", len(tools), tt.want)
+			}
+			for _, tool := range tools {
+				if tool.Tool().Function.Parameters == nil {
+					t.Errorf("%s should always offer a parameters schema", tool.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestProjectToolBuildCommand(t *testing.T) {
+	tool := ProjectTool{Name: "grep_code", Command: "grep -rn {{pattern}} {{ path }} --max-count={{limit}}"}
+
+	tests := []struct {
+		name      string
+		arguments string
+		want      string
+	}{
+		{"all arguments", `{"pattern":"TODO","path":"src","limit":5}`, `grep -rn 'TODO' 'src' --max-count='5'`},
+		{"missing argument", `{"pattern":"TODO","limit":5}`, `grep -rn 'TODO' '' --max-count='5'`},
+		{"injection attempt", `{"pattern":"x'; rm -rf ~; echo '","path":"$(whoami)","limit":1}`, `grep -rn 'x'\''; rm -rf ~; echo '\''' '$(whoami)' --max-count='1'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := api.ToolCall{Function: api.ToolCallFunction{Name: tool.Name, Arguments: tt.arguments}}
+			got, err := tool.BuildCommand(call)
+			if err != nil {
+				t.Fatalf("BuildCommand failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("BuildCommand() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Package logging writes informational messages (progress notes and
+// warnings) to stderr, so they can be silenced with --quiet. Errors are not
+// routed through it and are always printed.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Level controls which messages are written
+type Level int32
+
+const (
+	// LevelQuiet suppresses informational messages
+	LevelQuiet Level = iota
+
+	// LevelInfo writes progress notes and warnings (the default)
+	LevelInfo
+)
+
+var (
+	level  atomic.Int32
+	output io.Writer = os.Stderr
+)
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// SetLevel sets the level for all later messages
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// SetOutput redirects messages, e.g. in tests. Call it before any are written.
+func SetOutput(w io.Writer) {
+	output = w
+}
+
+// Quiet reports whether informational output is suppressed
+func Quiet() bool {
+	return Level(level.Load()) < LevelInfo
+}
+
+// Infof writes an informational message unless quiet. Like fmt.Printf, it
+// adds no newline.
+func Infof(format string, args ...any) {
+	if Quiet() {
+		return
+	}
+	fmt.Fprintf(output, format, args...)
+}
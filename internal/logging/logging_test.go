@@ -0,0 +1,25 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestInfofLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	defer SetLevel(LevelInfo)
+
+	Infof("Analyzing %s...\n", "directory")
+	SetLevel(LevelQuiet)
+	Infof("Pruning context\n")
+
+	if got, want := buf.String(), "Analyzing directory...\n"; got != want {
+		t.Errorf("Output = %q, want %q", got, want)
+	}
+	if !Quiet() {
+		t.Error("Quiet() should be true at LevelQuiet")
+	}
+}
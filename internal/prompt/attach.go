@@ -0,0 +1,67 @@
+package prompt
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Attachment is a file attached to a single query
+type Attachment struct {
+	Path      string
+	Content   string
+	Truncated bool // Content was cut to fit the attachment budget
+}
+
+// QueryWithAttachments appends each attachment to the query as a labeled fenced block
+func QueryWithAttachments(query string, attachments []Attachment) string {
+	if len(attachments) == 0 {
+		return query
+	}
+
+	var b strings.Builder
+	b.WriteString(query)
+	b.WriteString("\n\nATTACHED FILES:")
+	for _, a := range attachments {
+		lang := strings.TrimPrefix(filepath.Ext(a.Path), ".")
+		fmt.Fprintf(&b, "\n\nFile: %s\n```%s\n%s", a.Path, lang, a.Content)
+		if !strings.HasSuffix(a.Content, "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString("```")
+		if a.Truncated {
+			b.WriteString("\n[File truncated]")
+		}
+	}
+
+	return b.String()
+}
+
+// AttachmentNote is stored in place of attachment contents so they don't
+// bloat future turns
+func AttachmentNote(query string, attachments []Attachment) string {
+	if len(attachments) == 0 {
+		return query
+	}
+
+	paths := make([]string, len(attachments))
+	for i, a := range attachments {
+		paths[i] = a.Path
+	}
+	return fmt.Sprintf("%s\n\n[Attached files: %s]", query, strings.Join(paths, ", "))
+}
+
+// ImageNote is stored in place of attached images, like AttachmentNote
+func ImageNote(query string, paths []string) string {
+	if len(paths) == 0 {
+		return query
+	}
+	return fmt.Sprintf("%s\n\n[Attached images: %s]", query, strings.Join(paths, ", "))
+}
+
+// SearchResults introduces the output of ASK_SEARCH_CMD, sent as a message
+// just before the query it was run for
+func SearchResults(results string) string {
+	return "SEARCH RESULTS for the next question. They may be more current than your training data: " +
+		"prefer them where they conflict, and say so if they don't answer the question.\n\n" + results
+}
@@ -1,6 +1,8 @@
 package prompt
 
 import (
+	"strings"
+
 	"github.com/raitses/ask/internal/api"
 )
 
@@ -15,42 +17,103 @@ type AnalysisCache struct {
 	FileTree       string
 	ReadmeContent  string
 	PrimaryConfigs []string
+	Untrusted      []string // Sources flagged as containing instruction-like text
+}
+
+// Mode selects how the system prompt frames the conversation
+type Mode int
+
+const (
+	// ModeDefault uses the full CLI system prompt (concise, no markdown)
+	ModeDefault Mode = iota
+
+	// ModeRaw omits the base system prompt so the model's defaults apply.
+	// Analysis, persona, and saved summaries are still included.
+	ModeRaw
+)
+
+// BuildOptions holds everything BuildMessages needs
+type BuildOptions struct {
+	Directory string         // Directory the conversation is about
+	OS        string         // Platform description (see Platform)
+	Messages  []Message      // Stored conversation, including instruction messages
+	Examples  []Message      // Few-shot examples sent before the conversation
+	Analysis  *AnalysisCache // Directory analysis, if any
+
+	Mode            Mode
+	Format          Format
+	Persona         string // Appended to the system prompt when set
+	InstructionRole string // Role the system prompt is sent with ("system" if empty)
+	UseClaudeCache  bool   // Mark the system prompt for Claude's prompt cache
 }
 
 // BuildMessages converts messages to API messages with system prompt
-func BuildMessages(directory, osType string, messages []Message, analysis *AnalysisCache, useClaudeCache bool) []api.ChatMessage {
-	apiMessages := make([]api.ChatMessage, 0, len(messages)+1)
+func BuildMessages(opts BuildOptions) []api.ChatMessage {
+	messages := opts.Messages
+	apiMessages := make([]api.ChatMessage, 0, len(opts.Examples)+len(messages)+1)
 
 	// Build system prompt
-	systemPrompt := BaseSystemPrompt(osType, directory)
+	systemPrompt := ""
+	if opts.Mode != ModeRaw {
+		systemPrompt = BaseSystemPrompt(opts.OS, opts.Directory, opts.Format)
+	}
 
 	// Add analysis if available
-	if analysis != nil {
+	if analysis := opts.Analysis; analysis != nil {
 		systemPrompt += AnalysisSystemPrompt(
 			analysis.FileTree,
 			analysis.ReadmeContent,
 			analysis.PrimaryConfigs,
+			analysis.Untrusted,
 		)
 	}
 
-	// Add system message with cache control for Claude API
-	systemMsg := api.ChatMessage{
-		Role:    "system",
-		Content: systemPrompt,
+	// Add persona if selected
+	if opts.Persona != "" {
+		systemPrompt += PersonaSystemPrompt(opts.Persona)
 	}
 
-	// Mark for caching if using Claude API
-	// This caches the entire system prompt + analysis (typically 4,000+ tokens)
-	if useClaudeCache {
-		systemMsg.CacheControl = &api.CacheControl{Type: "ephemeral"}
+	// Fold stored instruction messages (e.g. saved summaries) into the system prompt
+	for _, msg := range messages {
+		if api.IsInstructionRole(msg.Role) {
+			systemPrompt += SummarySystemPrompt(msg.Content)
+		}
 	}
 
-	apiMessages = append(apiMessages, systemMsg)
+	// Add system message with cache control for Claude API
+	// (raw mode with nothing to add sends no system message at all)
+	systemPrompt = strings.TrimSpace(systemPrompt)
+	if systemPrompt != "" {
+		instructionRole := opts.InstructionRole
+		if instructionRole == "" {
+			instructionRole = api.RoleSystem
+		}
+		systemMsg := api.ChatMessage{
+			Role:    instructionRole,
+			Content: systemPrompt,
+		}
+
+		// Mark for caching if using Claude API
+		// This caches the entire system prompt + analysis (typically 4,000+ tokens)
+		if opts.UseClaudeCache {
+			systemMsg.CacheControl = &api.CacheControl{Type: "ephemeral"}
+		}
+
+		apiMessages = append(apiMessages, systemMsg)
+	}
+
+	// Add few-shot examples, which are never stored and so never pruned
+	for _, msg := range opts.Examples {
+		apiMessages = append(apiMessages, api.ChatMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		})
+	}
 
-	// Add conversation history (skip old system messages)
+	// Add conversation history (skip old system/developer messages)
 	for _, msg := range messages {
-		if msg.Role == "system" {
-			// Skip old system messages - we built a fresh one
+		if api.IsInstructionRole(msg.Role) {
+			// Skip stored instruction messages - already folded into the fresh one
 			continue
 		}
 		apiMessages = append(apiMessages, api.ChatMessage{
@@ -0,0 +1,52 @@
+package prompt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// MaxExamplesBytes caps the total content of few-shot examples, which are
+// sent with every request
+const MaxExamplesBytes = 16 * 1024
+
+// LoadExamples reads few-shot examples from path, a JSON array of
+// {"role": ..., "content": ...} messages with user or assistant roles.
+// A missing file is not an error.
+func LoadExamples(path string) ([]Message, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read examples: %w", err)
+	}
+
+	var raw []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid examples in %s: %w", path, err)
+	}
+
+	examples := make([]Message, 0, len(raw))
+	size := 0
+	for i, msg := range raw {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return nil, fmt.Errorf("invalid examples in %s: message %d has role %q, want user or assistant", path, i+1, msg.Role)
+		}
+		if msg.Content == "" {
+			return nil, fmt.Errorf("invalid examples in %s: message %d has no content", path, i+1)
+		}
+		size += len(msg.Content)
+		examples = append(examples, Message{Role: msg.Role, Content: msg.Content})
+	}
+	if size > MaxExamplesBytes {
+		return nil, fmt.Errorf("examples in %s are %d bytes, over the %d byte limit", path, size, MaxExamplesBytes)
+	}
+
+	return examples, nil
+}
@@ -0,0 +1,55 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuiltinPersonas are the persona presets available without any configuration
+var BuiltinPersonas = map[string]string{
+	"reviewer": `Act as a senior code reviewer.
+- Point out bugs, edge cases, and security issues first
+- Suggest concrete improvements with short examples
+- Be direct; skip praise`,
+	"teacher": `Act as a patient teacher.
+- Explain concepts step by step, defining terms as you go
+- Explain why, not just how
+- End with a short check-your-understanding question`,
+	"shell-wizard": `Act as a shell expert.
+- Answer with working one-liners first, explanation after
+- Prefer POSIX tools; note GNU/BSD differences when relevant
+- Warn before anything destructive`,
+}
+
+// LoadPersonas returns the built-in personas merged with *.md files in dir.
+// A file named <name>.md overrides the built-in persona of the same name.
+// A missing directory is not an error.
+func LoadPersonas(dir string) (map[string]string, error) {
+	personas := make(map[string]string, len(BuiltinPersonas))
+	for name, text := range BuiltinPersonas {
+		personas[name] = text
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.md"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list personas: %w", err)
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read persona %s: %w", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".md")
+		personas[name] = strings.TrimSpace(string(data))
+	}
+
+	return personas, nil
+}
+
+// PersonaSystemPrompt returns the system prompt addendum for a persona
+func PersonaSystemPrompt(persona string) string {
+	return fmt.Sprintf("\n\nPERSONA:\n%s", persona)
+}
@@ -0,0 +1,55 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// TemplateInput is replaced by the query text given on the command line
+	TemplateInput = "{{input}}"
+
+	// TemplateStdin is replaced by text piped on standard input
+	TemplateStdin = "{{stdin}}"
+)
+
+// LoadTemplates returns the query templates in dir (*.txt) by name.
+// A missing directory is not an error.
+func LoadTemplates(dir string) (map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	templates := make(map[string]string, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template %s: %w", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".txt")
+		templates[name] = strings.TrimSpace(string(data))
+	}
+
+	return templates, nil
+}
+
+// ExpandTemplate fills in a template's placeholders. If the template has no
+// {{input}} placeholder, input is appended so it isn't lost.
+func ExpandTemplate(template, input, stdin string) string {
+	query := strings.ReplaceAll(template, TemplateStdin, strings.TrimSpace(stdin))
+	if strings.Contains(query, TemplateInput) {
+		return strings.ReplaceAll(query, TemplateInput, input)
+	}
+	if input != "" {
+		query += "\n\n" + input
+	}
+	return query
+}
+
+// UsesStdin reports whether a template reads standard input
+func UsesStdin(template string) bool {
+	return strings.Contains(template, TemplateStdin)
+}
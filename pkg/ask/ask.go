@@ -0,0 +1,341 @@
+// Package ask provides a library API for embedding ask's per-directory
+// conversational context, analysis, and pruning in other Go programs.
+package ask
+
+import (
+	"time"
+
+	"github.com/raitses/ask/internal/api"
+	"github.com/raitses/ask/internal/config"
+	"github.com/raitses/ask/internal/context"
+	"github.com/raitses/ask/internal/logging"
+	"github.com/raitses/ask/internal/prompt"
+)
+
+// Config holds the runtime configuration
+type Config = config.Config
+
+// ContextInfo holds statistics about a directory's context
+type ContextInfo = context.ContextInfo
+
+// Usage is the token usage reported by the provider
+type Usage = api.Usage
+
+// PruneResult describes the context before and after Prune
+type PruneResult = context.PruneResult
+
+// Analysis is the structured result of analyzing a directory
+type Analysis = context.Analysis
+
+// Output format presets for Config.Format
+const (
+	FormatPlain    = config.FormatPlain
+	FormatMarkdown = config.FormatMarkdown
+	FormatJSON     = config.FormatJSON
+	FormatShell    = config.FormatShell
+)
+
+// Errors returned by Ask and the other requests can be matched with errors.Is
+var (
+	ErrAuth                  = api.ErrAuth
+	ErrRateLimited           = api.ErrRateLimited
+	ErrServer                = api.ErrServer
+	ErrNetwork               = api.ErrNetwork
+	ErrInvalidResponse       = api.ErrInvalidResponse
+	ErrContextLengthExceeded = api.ErrContextLengthExceeded
+)
+
+// LoadConfig reads configuration from .env files and environment variables
+// Priority: env vars > local .env > global .env
+func LoadConfig() (*Config, error) {
+	return config.Load()
+}
+
+// LoadConfigProfile reads configuration like LoadConfig, layering the named
+// profile (~/.config/ask/profiles/<name>.env) over the global .env
+func LoadConfigProfile(profile string) (*Config, error) {
+	return config.LoadProfile(profile)
+}
+
+// Client is a conversation bound to a directory's context (Config.Dir, or
+// the current directory)
+type Client struct {
+	manager *context.Manager
+}
+
+// New creates a client for the context of cfg.Dir, or the current directory
+func New(cfg *Config) (*Client, error) {
+	manager, err := context.NewManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{manager: manager}, nil
+}
+
+// Ask sends a query with conversation context and returns the response
+func (c *Client) Ask(query string) (string, error) {
+	return c.manager.Query(query)
+}
+
+// AskWithFiles sends a query with the given files attached to it.
+// The file contents are not kept in the stored conversation.
+func (c *Client) AskWithFiles(query string, paths []string) (string, error) {
+	return c.manager.QueryWithFiles(query, paths)
+}
+
+// AskWithImages sends a query like AskWithFiles with images (PNG, JPEG,
+// GIF, or WebP) attached, for models that accept image input
+func (c *Client) AskWithImages(query string, paths, images []string) (string, error) {
+	return c.manager.QueryWithImages(query, paths, images)
+}
+
+// Wait blocks until background pruning from the last Ask has finished.
+// Call it before the program exits so pruning results are saved.
+func (c *Client) Wait() {
+	c.manager.Wait()
+}
+
+// Reset clears the conversation context
+func (c *Client) Reset() error {
+	return c.manager.Reset()
+}
+
+// Trim removes messages older than cutoff, keeping pinned messages and the
+// most recent exchanges (Config.KeepRecent). Returns how many messages were removed.
+func (c *Client) Trim(cutoff time.Time) (int, error) {
+	return c.manager.Trim(cutoff)
+}
+
+// WarmCache primes Claude's prompt cache with the system prompt and
+// analysis so the next Ask reads them from the cache. It returns the
+// provider's usage, including cache creation and read token counts.
+func (c *Client) WarmCache() (Usage, error) {
+	return c.manager.WarmCache()
+}
+
+// Prune removes older messages now instead of waiting for a limit, letting
+// the model choose what to drop when an API key is configured, and saves
+// the result
+func (c *Client) Prune() (PruneResult, error) {
+	return c.manager.Prune()
+}
+
+// Analyze performs directory analysis and caches the results
+func (c *Client) Analyze() error {
+	return c.manager.Analyze()
+}
+
+// AnalyzeEphemeral performs directory analysis used by this client's
+// queries only, without saving it to the context
+func (c *Client) AnalyzeEphemeral() error {
+	return c.manager.AnalyzeEphemeral()
+}
+
+// Summarize returns a structured summary of the conversation. If save is
+// true, the history is replaced by the summary.
+func (c *Client) Summarize(save bool) (string, error) {
+	return c.manager.Summarize(save)
+}
+
+// Replay re-sends the last question to the configured model and returns the
+// answer. With save, it replaces the stored answer; otherwise nothing changes.
+func (c *Client) Replay(save bool) (string, error) {
+	return c.manager.Replay(save)
+}
+
+// ContinuePrompt is the query that asks for the rest of a truncated answer
+const ContinuePrompt = context.ContinuePrompt
+
+// LastAnswerTruncated reports whether the last stored answer was cut off by
+// the output token limit, so asking ContinuePrompt would get the rest
+func (c *Client) LastAnswerTruncated() bool {
+	return c.manager.LastAnswerTruncated()
+}
+
+// Info returns information about the current context
+func (c *Client) Info() string {
+	return c.manager.GetInfo()
+}
+
+// UsageFooter returns how full the context was after the last Ask, e.g.
+// "(context: 18k/25k tokens)", or "" before the first Ask
+func (c *Client) UsageFooter() string {
+	return c.manager.UsageFooter()
+}
+
+// SystemFingerprint returns the provider's system_fingerprint for the last
+// Ask, or "" if it reported none
+func (c *Client) SystemFingerprint() string {
+	return c.manager.SystemFingerprint()
+}
+
+// Latency returns how long the provider took to answer the last Ask, or 0
+// before the first Ask
+func (c *Client) Latency() time.Duration {
+	return c.manager.Latency()
+}
+
+// Stats returns statistics about the current context
+func (c *Client) Stats() ContextInfo {
+	return c.manager.Info()
+}
+
+// History returns the conversation, previewing each message to previewLen
+// characters unless full is set
+func (c *Client) History(previewLen int, full bool) string {
+	return c.manager.GetHistory(previewLen, full)
+}
+
+// SetConfirm sets the callback used to ask before sending large prompts
+func (c *Client) SetConfirm(confirm func(question string) bool) {
+	c.manager.SetConfirm(confirm)
+}
+
+// SetApproveCommand sets the callback that approves each shell command the
+// model asks to run when Config.Tools is enabled. Without one, every command is declined.
+func (c *Client) SetApproveCommand(approve func(command string) bool) {
+	c.manager.SetApproveCommand(approve)
+}
+
+// SetChooseResponse sets the callback that picks one of several responses
+// when Config.Choices is above 1, returning its index. Without one, the
+// first response is used.
+func (c *Client) SetChooseResponse(choose func(choices []string) int) {
+	c.manager.SetChooseResponse(choose)
+}
+
+// SetSaveGuard sets a function called before saving an answer or pruned
+// history; the release function it returns is called once the save is
+// done, e.g. to hold back Ctrl-C meanwhile. Without one, saves aren't guarded.
+func (c *Client) SetSaveGuard(guard func() (release func())) {
+	c.manager.SetSaveGuard(guard)
+}
+
+// Personas returns the available persona presets by name
+func Personas() (map[string]string, error) {
+	return context.LoadPersonas()
+}
+
+// Templates returns the query templates in ~/.config/ask/templates by name
+func Templates() (map[string]string, error) {
+	return context.LoadTemplates()
+}
+
+// ExpandTemplate fills a template's {{input}} and {{stdin}} placeholders.
+// Without an {{input}} placeholder, input is appended.
+func ExpandTemplate(template, input, stdin string) string {
+	return prompt.ExpandTemplate(template, input, stdin)
+}
+
+// TemplateUsesStdin reports whether a template has a {{stdin}} placeholder
+func TemplateUsesStdin(template string) bool {
+	return prompt.UsesStdin(template)
+}
+
+// ScanDirectory analyzes a directory and returns its structure, README,
+// configuration files, and project type without touching any context
+func ScanDirectory(directory string) (*Analysis, error) {
+	return context.NewAnalyzer(directory).Scan()
+}
+
+// CheckResult is the outcome of Check
+type CheckResult struct {
+	Model        string
+	APIURL       string
+	APIKeySource string        // Empty if no key is set
+	Latency      time.Duration // Time taken by the test request
+	Reply        string
+	Err          error  // Nil if the configuration works
+	Problem      string // Likely cause of Err
+}
+
+// Check validates the configuration and sends a tiny test request without
+// retries, reporting whether it worked and how long it took
+func Check(cfg *Config) CheckResult {
+	result := CheckResult{Model: cfg.Model, APIURL: cfg.APIURL, APIKeySource: cfg.APIKeySource}
+	if err := cfg.Validate(); err != nil {
+		result.Err = err
+		result.Problem = "invalid configuration"
+		return result
+	}
+
+	checkCfg := *cfg
+	checkCfg.Retries = 0
+	result.Reply, result.Latency, result.Err = api.NewClient(&checkCfg).Ping()
+	if result.Err != nil {
+		result.Problem = api.Diagnose(result.Err)
+	}
+	return result
+}
+
+// Forget deletes the stored contexts for a directory, including its named
+// sessions, or cfg's context file (Config.ContextFile) if one is set, since
+// that is the context in use
+func Forget(cfg *Config, directory string) error {
+	if cfg.ContextFile != "" {
+		return context.DeleteFile(cfg.ContextFile)
+	}
+	if err := context.UseStore(cfg.Store); err != nil {
+		return err
+	}
+	return context.Delete(directory)
+}
+
+// Relocate moves the stored contexts of a directory (including its named
+// sessions) to another path after the directory was moved or renamed, so
+// the conversation carries over. It uses cfg's store and encryption key.
+// Returns how many contexts were moved.
+func Relocate(cfg *Config, from, to string) (int, error) {
+	if err := context.UseStore(cfg.Store); err != nil {
+		return 0, err
+	}
+	return context.Relocate(from, to, context.DeriveKey(cfg.EncryptionKey))
+}
+
+// Sessions returns the named sessions of a directory in cfg's store and the
+// current one ("" for the default session)
+func Sessions(cfg *Config, directory string) ([]string, string, error) {
+	if err := context.UseStore(cfg.Store); err != nil {
+		return nil, "", err
+	}
+	sessions, err := context.ListSessions(directory)
+	if err != nil {
+		return nil, "", err
+	}
+	return sessions, context.CurrentSession(directory), nil
+}
+
+// SwitchSession makes session the default for later queries in a directory.
+// "default" switches back to the directory's original context.
+func SwitchSession(directory, session string) error {
+	return context.SwitchSession(directory, session)
+}
+
+// ClearResponseCache deletes every cached response and returns how many
+// were removed
+func ClearResponseCache() (int, error) {
+	return context.ClearResponseCache()
+}
+
+// ParseAge parses a duration like time.ParseDuration, adding day (d) and
+// week (w) units, e.g. "90d" or "2w"
+func ParseAge(value string) (time.Duration, error) {
+	return config.ParseAge(value)
+}
+
+// SetQuiet silences the progress notes and warnings written to stderr.
+// Errors are still returned as usual.
+func SetQuiet(quiet bool) {
+	if quiet {
+		logging.SetLevel(logging.LevelQuiet)
+	} else {
+		logging.SetLevel(logging.LevelInfo)
+	}
+}
+
+// Infof writes a progress note or warning to stderr unless SetQuiet(true)
+// was called
+func Infof(format string, args ...any) {
+	logging.Infof(format, args...)
+}
@@ -12,3 +12,12 @@ func DirectoryPath(path string) string {
 	h.Write([]byte(path))
 	return hex.EncodeToString(h.Sum(nil))[:8]
 }
+
+// SessionPath computes the context file identifier for a named session of a
+// directory. The default session ("") uses DirectoryPath unchanged.
+func SessionPath(path, session string) string {
+	if session == "" {
+		return DirectoryPath(path)
+	}
+	return DirectoryPath(path) + "@" + session
+}